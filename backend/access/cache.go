@@ -0,0 +1,91 @@
+/*
+cache.go
+In this file you will find the cache in front of the access policies. The auth middleware looks the user up on every
+protected request; the answers (including "unknown user") are kept for a short while, and dropped as soon as the
+attempts of the user change.
+*/
+package access
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+// CachedPolicy caches the lookups of another policy.
+type CachedPolicy struct {
+	policy AccessPolicy
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+// cacheEntry is a cached lookup: the access of the user, or the error saying it is unknown.
+type cacheEntry struct {
+	access    models.UserAccess
+	err       error
+	expiresAt time.Time
+}
+
+// NewCachedPolicy() => wraps a policy, caching its lookups for ttl.
+func NewCachedPolicy(policy AccessPolicy, ttl time.Duration) *CachedPolicy {
+	return &CachedPolicy{policy: policy, ttl: ttl, entries: make(map[string]cacheEntry)}
+}
+
+// UserAccess() => returns the access of a user, from the cache when it is fresh enough.
+func (p *CachedPolicy) UserAccess(ctx context.Context, userID string) (*models.UserAccess, error) {
+	now := time.Now()
+	p.mu.Lock()
+	entry, ok := p.entries[userID]
+	p.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		if entry.err != nil {
+			return nil, entry.err
+		}
+		access := entry.access
+		return &access, nil
+	}
+
+	access, err := p.policy.UserAccess(ctx, userID)
+	// Failures to reach the policy are not cached, only its answers
+	if err != nil && !errors.Is(err, ErrUnknownUser) {
+		return nil, err
+	}
+	entry = cacheEntry{err: err, expiresAt: now.Add(p.ttl)}
+	if access != nil {
+		entry.access = *access
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, cached := range p.entries {
+		if now.After(cached.expiresAt) {
+			delete(p.entries, id)
+		}
+	}
+	p.entries[userID] = entry
+	return access, err
+}
+
+// ConsumeTrial() => takes one attempt of a trial from a user, through the policy.
+func (p *CachedPolicy) ConsumeTrial(ctx context.Context, userID, trial string) error {
+	defer p.forget(userID)
+	return p.policy.ConsumeTrial(ctx, userID, trial)
+}
+
+// RefundTrial() => gives back an attempt of a trial, through the policy.
+func (p *CachedPolicy) RefundTrial(ctx context.Context, userID, trial string) error {
+	defer p.forget(userID)
+	return p.policy.RefundTrial(ctx, userID, trial)
+}
+
+// forget() => drops the cached access of a user.
+func (p *CachedPolicy) forget(userID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.entries, userID)
+}
@@ -0,0 +1,59 @@
+/*
+database.go
+In this file you will find the database access policy: the users are the rows of the user_access table of the server
+registry, which its single-statement updates keep consistent when creations run in parallel.
+*/
+package access
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/storage"
+)
+
+// DatabasePolicy is the access policy kept in the server registry.
+type DatabasePolicy struct {
+	repository storage.AccessRepository
+}
+
+// NewDatabasePolicy() => creates the access policy kept in repository.
+func NewDatabasePolicy(repository storage.AccessRepository) *DatabasePolicy {
+	return &DatabasePolicy{repository: repository}
+}
+
+// UserAccess() => reads the access of a user.
+func (p *DatabasePolicy) UserAccess(ctx context.Context, userID string) (*models.UserAccess, error) {
+	access, err := p.repository.UserAccess(ctx, userID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownUser, err)
+	}
+	return access, err
+}
+
+// ConsumeTrial() => takes one attempt of a trial from a user.
+func (p *DatabasePolicy) ConsumeTrial(ctx context.Context, userID, trial string) error {
+	return p.addTrialAttempts(ctx, userID, trial, -1)
+}
+
+// RefundTrial() => gives an attempt of a trial back to a user.
+func (p *DatabasePolicy) RefundTrial(ctx context.Context, userID, trial string) error {
+	return p.addTrialAttempts(ctx, userID, trial, 1)
+}
+
+// addTrialAttempts() => adds delta to a trial counter of a user. The counter never goes below zero.
+func (p *DatabasePolicy) addTrialAttempts(ctx context.Context, userID, trial string, delta int) error {
+	changed, err := p.repository.AddTrialAttempts(ctx, userID, trial, delta)
+	if errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("%w: %v", ErrUnknownUser, err)
+	}
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return fmt.Errorf("%w (%s)", ErrNoTrialAttempts, trial)
+	}
+	return nil
+}
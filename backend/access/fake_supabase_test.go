@@ -0,0 +1,191 @@
+/*
+fake_supabase_test.go
+In this file you will find FakeSupabase, an httptest server answering like the REST API of Supabase for the profiles
+table, so the Supabase policy can be tested without a project. It serves the GET and the conditional PATCH the
+policy sends (id=eq.<id>, and <column>=eq.<value> on the counters), and rejects requests without the service-role key.
+*/
+package access
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+// FakeSupabase is an in-memory profiles table behind a local HTTP server.
+type FakeSupabase struct {
+	server         *httptest.Server
+	serviceRoleKey string
+
+	mu          sync.Mutex
+	profiles    map[string]map[string]any // Columns of each profile, by user ID
+	requests    int
+	beforePatch func() // Run once, before the next PATCH is applied
+}
+
+// NewFakeSupabase() => starts a fake Supabase accepting serviceRoleKey. Close() it when done.
+func NewFakeSupabase(serviceRoleKey string) *FakeSupabase {
+	fake := &FakeSupabase{serviceRoleKey: serviceRoleKey, profiles: make(map[string]map[string]any)}
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.serve))
+	return fake
+}
+
+// URL() => returns the project URL of the fake, to give to NewSupabasePolicy().
+func (f *FakeSupabase) URL() string {
+	return f.server.URL
+}
+
+// Close() => stops the fake.
+func (f *FakeSupabase) Close() {
+	f.server.Close()
+}
+
+// SetProfile() => creates or replaces the profile of a user.
+func (f *FakeSupabase) SetProfile(access models.UserAccess) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.profiles[access.UserID] = map[string]any{
+		"id":                           access.UserID,
+		"approved":                     access.Approved,
+		"test_service_trial_attempts":  access.TestServiceTrialAttempts,
+		"custom_server_trial_attempts": access.CustomServerTrialAttempts,
+	}
+}
+
+// Profile() => returns the profile of a user, and whether it exists.
+func (f *FakeSupabase) Profile(userID string) (models.UserAccess, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	row, ok := f.profiles[userID]
+	if !ok {
+		return models.UserAccess{}, false
+	}
+	return models.UserAccess{
+		UserID:                    userID,
+		Approved:                  row["approved"].(bool),
+		TestServiceTrialAttempts:  row["test_service_trial_attempts"].(int),
+		CustomServerTrialAttempts: row["custom_server_trial_attempts"].(int),
+	}, true
+}
+
+// Requests() => returns how many requests the fake received, to check what the cache saved.
+func (f *FakeSupabase) Requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+// BeforeNextPatch() => runs hook right before the next PATCH is applied, e.g. to change a counter in between the read
+// and the write of a compare-and-set.
+func (f *FakeSupabase) BeforeNextPatch(hook func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.beforePatch = hook
+}
+
+// serve() => answers a request to the REST API.
+func (f *FakeSupabase) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPatch {
+		f.mu.Lock()
+		hook := f.beforePatch
+		f.beforePatch = nil
+		f.mu.Unlock()
+		if hook != nil {
+			hook()
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+
+	if r.Header.Get("apikey") != f.serviceRoleKey || r.Header.Get("Authorization") != "Bearer "+f.serviceRoleKey {
+		http.Error(w, `{"message":"Invalid API key"}`, http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path != "/rest/v1/"+profilesTable {
+		http.Error(w, `{"message":"relation does not exist"}`, http.StatusNotFound)
+		return
+	}
+
+	// Filters (column=eq.value) and the columns to return
+	query := r.URL.Query()
+	var columns []string
+	filters := make(map[string]string)
+	for column, values := range query {
+		if column == "select" {
+			columns = strings.Split(values[0], ",")
+		} else if value, ok := strings.CutPrefix(values[0], "eq."); ok {
+			filters[column] = value
+		}
+	}
+
+	var matched []map[string]any
+	for _, row := range f.profiles {
+		if rowMatches(row, filters) {
+			matched = append(matched, row)
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var changes map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			http.Error(w, `{"message":"invalid body"}`, http.StatusBadRequest)
+			return
+		}
+		for _, row := range matched {
+			for column, value := range changes {
+				// JSON numbers decode as float64; the counters are kept as int
+				if number, ok := value.(float64); ok {
+					value = int(number)
+				}
+				row[column] = value
+			}
+		}
+	default:
+		http.Error(w, `{"message":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	// PostgREST always returns an array, with the selected columns of the matched rows
+	rows := make([]map[string]any, 0, len(matched))
+	for _, row := range matched {
+		selected := make(map[string]any, len(columns))
+		for _, column := range columns {
+			selected[column] = row[column]
+		}
+		rows = append(rows, selected)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rows)
+}
+
+// rowMatches() => reports whether a row holds the value of every filter.
+func rowMatches(row map[string]any, filters map[string]string) bool {
+	for column, value := range filters {
+		switch held := row[column].(type) {
+		case int:
+			if strconv.Itoa(held) != value {
+				return false
+			}
+		case bool:
+			if strconv.FormatBool(held) != value {
+				return false
+			}
+		case string:
+			if held != value {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+/*
+policy.go
+In this file you will find the AccessPolicy interface: who may use the backend, and how many servers they may still
+create on each trial. The auth middleware asks it whether a user is approved, and the server creations consume (and
+refund) their trial attempts through it. Three policies are available, chosen with ACCESS_POLICY:
+
+	supabase  the profiles table of Supabase, through its REST API (default when SUPABASE_URL is set)
+	static    a YAML file listing the approved users and their attempts (ACCESS_POLICY_FILE)
+	database  the user_access table of the server registry
+
+Lookups are cached for ACCESS_CACHE_TTL (default: 30s), so the policy is not queried on every request.
+*/
+package access
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/storage"
+)
+
+var (
+	// ErrUnknownUser is returned (wrapped) when a policy does not know a user.
+	ErrUnknownUser = errors.New("unknown user")
+	// ErrNoTrialAttempts is returned (wrapped) when a user has no attempt left on a trial.
+	ErrNoTrialAttempts = errors.New("no trial attempts remaining")
+	// ErrInvalidAPIKey is returned (wrapped) when an API key is unknown, wrong, revoked or expired.
+	ErrInvalidAPIKey = errors.New("invalid API key")
+)
+
+const defaultCacheTTL = 30 * time.Second
+
+// AccessPolicy decides who may use the backend, and keeps count of their trial attempts.
+type AccessPolicy interface {
+	// UserAccess() => returns the access of a user, or ErrUnknownUser.
+	UserAccess(ctx context.Context, userID string) (*models.UserAccess, error)
+	// ConsumeTrial() => takes one attempt of a trial from a user, or fails with ErrNoTrialAttempts.
+	ConsumeTrial(ctx context.Context, userID, trial string) error
+	// RefundTrial() => gives back an attempt of a trial consumed by a creation that failed.
+	RefundTrial(ctx context.Context, userID, trial string) error
+}
+
+/*
+NewPolicyFromEnv() => creates the access policy configured by the environment, wrapped in a cache. The database policy
+keeps the users in repository. It returns a nil policy when none is configured: no Supabase user gets through then.
+*/
+func NewPolicyFromEnv(repository storage.AccessRepository) (AccessPolicy, string, error) {
+	kind := os.Getenv("ACCESS_POLICY")
+	if kind == "" && os.Getenv("SUPABASE_URL") != "" {
+		kind = "supabase"
+	}
+
+	var policy AccessPolicy
+	var description string
+	switch kind {
+	case "":
+		return nil, "", nil
+	case "supabase":
+		supabaseURL, serviceRoleKey := os.Getenv("SUPABASE_URL"), os.Getenv("SUPABASE_SERVICE_ROLE_KEY")
+		if supabaseURL == "" || serviceRoleKey == "" {
+			return nil, "", errors.New("ACCESS_POLICY=supabase needs SUPABASE_URL and SUPABASE_SERVICE_ROLE_KEY")
+		}
+		policy, description = NewSupabasePolicy(supabaseURL, serviceRoleKey), "Supabase profiles ("+supabaseURL+")"
+	case "static":
+		path := os.Getenv("ACCESS_POLICY_FILE")
+		if path == "" {
+			return nil, "", errors.New("ACCESS_POLICY=static needs ACCESS_POLICY_FILE")
+		}
+		static, err := NewStaticPolicyFromFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+		policy, description = static, "static file "+path
+	case "database":
+		policy, description = NewDatabasePolicy(repository), "server registry"
+	default:
+		return nil, "", fmt.Errorf("invalid ACCESS_POLICY %q (supabase, static or database)", kind)
+	}
+
+	ttl := defaultCacheTTL
+	if value := os.Getenv("ACCESS_CACHE_TTL"); value != "" {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil || ttl < 0 {
+			return nil, "", fmt.Errorf("invalid ACCESS_CACHE_TTL %q", value)
+		}
+	}
+	if ttl > 0 {
+		policy = NewCachedPolicy(policy, ttl)
+	}
+	return policy, description, nil
+}
+
+// trialAttempts() => returns the attempts left on a trial, failing for unknown trials.
+func trialAttempts(access *models.UserAccess, trial string) (int, error) {
+	attempts, ok := access.TrialAttempts(trial)
+	if !ok {
+		return 0, fmt.Errorf("unknown trial %q", trial)
+	}
+	return attempts, nil
+}
@@ -0,0 +1,306 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/storage"
+)
+
+const testServiceRoleKey = "service-role-key"
+
+var testUser = models.UserAccess{UserID: "user-1", Approved: true, TestServiceTrialAttempts: 3, CustomServerTrialAttempts: 1}
+
+// newTestPolicies() => returns each policy, knowing testUser.
+func newTestPolicies(t *testing.T) map[string]AccessPolicy {
+	t.Helper()
+	fake := NewFakeSupabase(testServiceRoleKey)
+	t.Cleanup(fake.Close)
+	fake.SetProfile(testUser)
+
+	registry, err := storage.NewSQLiteRegistry(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { registry.Close() })
+	if err := registry.SaveUserAccess(context.Background(), testUser); err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]AccessPolicy{
+		"supabase": NewSupabasePolicy(fake.URL(), testServiceRoleKey),
+		"static":   NewStaticPolicy(map[string]models.UserAccess{testUser.UserID: testUser}),
+		"database": NewDatabasePolicy(registry),
+		"cached":   NewCachedPolicy(NewStaticPolicy(map[string]models.UserAccess{testUser.UserID: testUser}), time.Minute),
+	}
+}
+
+// attemptsLeft() => returns the attempts of testUser left on a trial.
+func attemptsLeft(t *testing.T, policy AccessPolicy, trial string) int {
+	t.Helper()
+	access, err := policy.UserAccess(context.Background(), testUser.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts, _ := access.TrialAttempts(trial)
+	return attempts
+}
+
+func TestPolicyTrials(t *testing.T) {
+	ctx := context.Background()
+	for name, policy := range newTestPolicies(t) {
+		t.Run(name, func(t *testing.T) {
+			access, err := policy.UserAccess(ctx, testUser.UserID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *access != testUser {
+				t.Fatalf("UserAccess() = %+v, want %+v", *access, testUser)
+			}
+			if _, err := policy.UserAccess(ctx, "nobody"); !errors.Is(err, ErrUnknownUser) {
+				t.Fatalf("UserAccess(unknown) error = %v, want ErrUnknownUser", err)
+			}
+
+			if err := policy.ConsumeTrial(ctx, testUser.UserID, models.TrialCustom); err != nil {
+				t.Fatal(err)
+			}
+			if err := policy.ConsumeTrial(ctx, testUser.UserID, models.TrialCustom); !errors.Is(err, ErrNoTrialAttempts) {
+				t.Fatalf("ConsumeTrial() with no attempt left error = %v, want ErrNoTrialAttempts", err)
+			}
+			if got := attemptsLeft(t, policy, models.TrialCustom); got != 0 {
+				t.Fatalf("custom attempts = %d, want 0", got)
+			}
+			if err := policy.RefundTrial(ctx, testUser.UserID, models.TrialCustom); err != nil {
+				t.Fatal(err)
+			}
+			if got := attemptsLeft(t, policy, models.TrialCustom); got != 1 {
+				t.Fatalf("custom attempts after a refund = %d, want 1", got)
+			}
+			if got := attemptsLeft(t, policy, models.TrialTest); got != testUser.TestServiceTrialAttempts {
+				t.Fatalf("test attempts = %d, want %d untouched", got, testUser.TestServiceTrialAttempts)
+			}
+
+			if err := policy.ConsumeTrial(ctx, "nobody", models.TrialTest); !errors.Is(err, ErrUnknownUser) {
+				t.Fatalf("ConsumeTrial(unknown) error = %v, want ErrUnknownUser", err)
+			}
+			if err := policy.ConsumeTrial(ctx, testUser.UserID, "premium"); err == nil {
+				t.Fatal("ConsumeTrial() accepted an unknown trial")
+			}
+		})
+	}
+}
+
+// TestPolicyParallelConsumes checks that creations sent at the same time never take more attempts than the user has.
+func TestPolicyParallelConsumes(t *testing.T) {
+	const consumers = 8
+	for name, policy := range newTestPolicies(t) {
+		t.Run(name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			errs := make(chan error, consumers)
+			for i := 0; i < consumers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs <- policy.ConsumeTrial(context.Background(), testUser.UserID, models.TrialTest)
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			consumed := 0
+			for err := range errs {
+				switch {
+				case err == nil:
+					consumed++
+				case !errors.Is(err, ErrNoTrialAttempts):
+					t.Errorf("ConsumeTrial() error = %v", err)
+				}
+			}
+			if consumed != testUser.TestServiceTrialAttempts {
+				t.Errorf("%d consumes succeeded, want %d", consumed, testUser.TestServiceTrialAttempts)
+			}
+			if got := attemptsLeft(t, policy, models.TrialTest); got != 0 {
+				t.Errorf("test attempts = %d, want 0", got)
+			}
+		})
+	}
+}
+
+// TestSupabaseCompareAndSet changes the counter in between the read and the PATCH of a consume: the PATCH must not
+// match, and the consume start over from the new value.
+func TestSupabaseCompareAndSet(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeSupabase(testServiceRoleKey)
+	defer fake.Close()
+	fake.SetProfile(testUser)
+	policy := NewSupabasePolicy(fake.URL(), testServiceRoleKey)
+
+	fake.BeforeNextPatch(func() {
+		changed := testUser
+		changed.TestServiceTrialAttempts = 1
+		fake.SetProfile(changed)
+	})
+	if err := policy.ConsumeTrial(ctx, testUser.UserID, models.TrialTest); err != nil {
+		t.Fatal(err)
+	}
+	profile, _ := fake.Profile(testUser.UserID)
+	if profile.TestServiceTrialAttempts != 0 {
+		t.Fatalf("test attempts = %d, want 0 (the concurrent change to 1, minus the consume)", profile.TestServiceTrialAttempts)
+	}
+
+	// A counter changing before every PATCH makes the consume give up instead of looping
+	rounds := 0
+	var change func()
+	change = func() {
+		rounds++
+		changed := testUser
+		changed.CustomServerTrialAttempts = 10 + rounds
+		fake.SetProfile(changed)
+		fake.BeforeNextPatch(change)
+	}
+	fake.BeforeNextPatch(change)
+	err := policy.ConsumeTrial(ctx, testUser.UserID, models.TrialCustom)
+	fake.BeforeNextPatch(nil)
+	if err == nil || errors.Is(err, ErrNoTrialAttempts) {
+		t.Fatalf("ConsumeTrial() on a counter that keeps changing error = %v, want a retry error", err)
+	}
+	if rounds != maxTrialRetries {
+		t.Fatalf("%d compare-and-set rounds, want %d", rounds, maxTrialRetries)
+	}
+}
+
+func TestSupabaseWrongServiceRoleKey(t *testing.T) {
+	fake := NewFakeSupabase(testServiceRoleKey)
+	defer fake.Close()
+	fake.SetProfile(testUser)
+
+	_, err := NewSupabasePolicy(fake.URL(), "wrong-key").UserAccess(context.Background(), testUser.UserID)
+	if err == nil || errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("UserAccess() with a wrong key error = %v, want a request failure", err)
+	}
+}
+
+func TestCachedPolicy(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeSupabase(testServiceRoleKey)
+	defer fake.Close()
+	fake.SetProfile(testUser)
+	policy := NewCachedPolicy(NewSupabasePolicy(fake.URL(), testServiceRoleKey), time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if _, err := policy.UserAccess(ctx, testUser.UserID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := policy.UserAccess(ctx, "nobody"); !errors.Is(err, ErrUnknownUser) {
+			t.Fatalf("UserAccess(unknown) error = %v, want ErrUnknownUser", err)
+		}
+	}
+	if got := fake.Requests(); got != 2 {
+		t.Fatalf("%d requests to Supabase, want 2 (one per user, then the cache)", got)
+	}
+
+	// A consume drops the cached access: the next lookup sees the new count
+	if err := policy.ConsumeTrial(ctx, testUser.UserID, models.TrialTest); err != nil {
+		t.Fatal(err)
+	}
+	if got := attemptsLeft(t, policy, models.TrialTest); got != testUser.TestServiceTrialAttempts-1 {
+		t.Fatalf("test attempts = %d, want %d", got, testUser.TestServiceTrialAttempts-1)
+	}
+}
+
+func TestStaticPolicyFromFile(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"valid", "users:\n  - id: user-1\n    approved: true\n    test_service_trial_attempts: 3\n    custom_server_trial_attempts: 1\n", ""},
+		{"duplicate", "users:\n  - id: user-1\n  - id: user-1\n", "twice"},
+		{"no id", "users:\n  - approved: true\n", "without id"},
+		{"negative attempts", "users:\n  - id: user-1\n    test_service_trial_attempts: -1\n", "negative"},
+		{"not yaml", "users: [", "failed to parse"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "access.yaml")
+			if err := os.WriteFile(path, []byte(c.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			policy, err := NewStaticPolicyFromFile(path)
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("NewStaticPolicyFromFile() error = %v, want one about %q", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			access, err := policy.UserAccess(context.Background(), "user-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *access != testUser {
+				t.Fatalf("UserAccess() = %+v, want %+v", *access, testUser)
+			}
+		})
+	}
+
+	if _, err := NewStaticPolicyFromFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("NewStaticPolicyFromFile() accepted a missing file")
+	}
+}
+
+func TestNewPolicyFromEnv(t *testing.T) {
+	for _, name := range []string{"ACCESS_POLICY", "ACCESS_POLICY_FILE", "ACCESS_CACHE_TTL", "SUPABASE_URL", "SUPABASE_SERVICE_ROLE_KEY"} {
+		t.Setenv(name, "")
+	}
+	staticFile := filepath.Join(t.TempDir(), "access.yaml")
+	if err := os.WriteFile(staticFile, []byte("users:\n  - id: user-1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		env     map[string]string
+		wantNil bool
+		wantErr bool
+		cached  bool
+	}{
+		{"nothing configured", nil, true, false, false},
+		{"supabase by default", map[string]string{"SUPABASE_URL": "https://project.supabase.co", "SUPABASE_SERVICE_ROLE_KEY": "key"}, false, false, true},
+		{"supabase without its key", map[string]string{"ACCESS_POLICY": "supabase", "SUPABASE_URL": "https://project.supabase.co"}, false, true, false},
+		{"static", map[string]string{"ACCESS_POLICY": "static", "ACCESS_POLICY_FILE": staticFile}, false, false, true},
+		{"static without its file", map[string]string{"ACCESS_POLICY": "static"}, false, true, false},
+		{"database without cache", map[string]string{"ACCESS_POLICY": "database", "ACCESS_CACHE_TTL": "0"}, false, false, false},
+		{"invalid cache TTL", map[string]string{"ACCESS_POLICY": "database", "ACCESS_CACHE_TTL": "soon"}, false, true, false},
+		{"unknown policy", map[string]string{"ACCESS_POLICY": "ldap"}, false, true, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for name, value := range c.env {
+				t.Setenv(name, value)
+			}
+			policy, _, err := NewPolicyFromEnv(nil)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("NewPolicyFromEnv() error = %v, want error %t", err, c.wantErr)
+			}
+			if c.wantErr {
+				return
+			}
+			if (policy == nil) != c.wantNil {
+				t.Fatalf("NewPolicyFromEnv() = %v, want nil %t", policy, c.wantNil)
+			}
+			if _, cached := policy.(*CachedPolicy); cached != c.cached {
+				t.Fatalf("NewPolicyFromEnv() = %T, want cached %t", policy, c.cached)
+			}
+		})
+	}
+}
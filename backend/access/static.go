@@ -0,0 +1,113 @@
+/*
+static.go
+In this file you will find the static access policy: the approved users and their trial attempts are listed in a
+YAML file, for deployments without Supabase profiles. The attempts consumed are only counted in memory, so they are
+back to the values of the file when the backend restarts.
+
+	users:
+	  - id: 6f1c0b8e-...            # Supabase user ID ("sub" of their JWTs)
+	    approved: true
+	    test_service_trial_attempts: 3
+	    custom_server_trial_attempts: 1
+*/
+package access
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/goccy/go-yaml"
+)
+
+// staticFile is the layout of the YAML file of the static policy.
+type staticFile struct {
+	Users []models.UserAccess `yaml:"users"`
+}
+
+// StaticPolicy is the access policy listed in a file.
+type StaticPolicy struct {
+	mu    sync.Mutex
+	users map[string]models.UserAccess
+}
+
+// NewStaticPolicyFromFile() => loads the static access policy of a YAML file.
+func NewStaticPolicyFromFile(path string) (*StaticPolicy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access policy file: %w", err)
+	}
+	var file staticFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse access policy file %s: %w", path, err)
+	}
+
+	users := make(map[string]models.UserAccess, len(file.Users))
+	for _, user := range file.Users {
+		if user.UserID == "" {
+			return nil, fmt.Errorf("access policy file %s lists a user without id", path)
+		}
+		if _, duplicate := users[user.UserID]; duplicate {
+			return nil, fmt.Errorf("access policy file %s lists user %s twice", path, user.UserID)
+		}
+		if user.TestServiceTrialAttempts < 0 || user.CustomServerTrialAttempts < 0 {
+			return nil, fmt.Errorf("access policy file %s gives user %s negative attempts", path, user.UserID)
+		}
+		users[user.UserID] = user
+	}
+	return NewStaticPolicy(users), nil
+}
+
+// NewStaticPolicy() => creates a static access policy of the given users, by user ID.
+func NewStaticPolicy(users map[string]models.UserAccess) *StaticPolicy {
+	return &StaticPolicy{users: users}
+}
+
+// UserAccess() => returns the access of a user listed in the file.
+func (p *StaticPolicy) UserAccess(ctx context.Context, userID string) (*models.UserAccess, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	access, ok := p.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not listed", ErrUnknownUser, userID)
+	}
+	return &access, nil
+}
+
+// ConsumeTrial() => takes one attempt of a trial from a user.
+func (p *StaticPolicy) ConsumeTrial(ctx context.Context, userID, trial string) error {
+	return p.addTrialAttempts(userID, trial, -1)
+}
+
+// RefundTrial() => gives an attempt of a trial back to a user.
+func (p *StaticPolicy) RefundTrial(ctx context.Context, userID, trial string) error {
+	return p.addTrialAttempts(userID, trial, 1)
+}
+
+// addTrialAttempts() => adds delta to a trial counter of a user. The counter never goes below zero.
+func (p *StaticPolicy) addTrialAttempts(userID, trial string, delta int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	access, ok := p.users[userID]
+	if !ok {
+		return fmt.Errorf("%w: %s is not listed", ErrUnknownUser, userID)
+	}
+	current, err := trialAttempts(&access, trial)
+	if err != nil {
+		return err
+	}
+	if current+delta < 0 {
+		return fmt.Errorf("%w (%s)", ErrNoTrialAttempts, trial)
+	}
+	if trial == models.TrialTest {
+		access.TestServiceTrialAttempts += delta
+	} else {
+		access.CustomServerTrialAttempts += delta
+	}
+	p.users[userID] = access
+	return nil
+}
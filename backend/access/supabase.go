@@ -0,0 +1,147 @@
+/*
+supabase.go
+In this file you will find the Supabase access policy: the users are the rows of the profiles table, read and written
+through the Supabase REST API (PostgREST) with the service-role key, which bypasses RLS so any row can be read.
+
+PostgREST cannot decrement a column, so every change of a trial counter is a compare-and-set: the counter is read,
+then written with a PATCH that only matches the row while the counter still holds the value read. When another change
+got in between, the PATCH matches nothing and the change starts over.
+*/
+package access
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+const (
+	profilesTable          = "profiles" // PostgREST table that holds per-user access fields
+	profileColumns         = "approved,test_service_trial_attempts,custom_server_trial_attempts"
+	supabaseRequestTimeout = 10 * time.Second
+	maxTrialRetries        = 5 // Compare-and-set rounds before giving up on a counter that keeps changing
+)
+
+// Counter of each trial in the profiles table
+var profileTrialColumns = map[string]string{
+	models.TrialTest:   "test_service_trial_attempts",
+	models.TrialCustom: "custom_server_trial_attempts",
+}
+
+// SupabasePolicy is the access policy kept in the profiles table of Supabase.
+type SupabasePolicy struct {
+	url            string // Supabase project URL
+	serviceRoleKey string
+	client         *http.Client
+}
+
+// NewSupabasePolicy() => creates the Supabase access policy of a project.
+func NewSupabasePolicy(supabaseURL, serviceRoleKey string) *SupabasePolicy {
+	return &SupabasePolicy{
+		url:            strings.TrimSuffix(supabaseURL, "/"),
+		serviceRoleKey: serviceRoleKey,
+		client:         &http.Client{Timeout: supabaseRequestTimeout},
+	}
+}
+
+// UserAccess() => reads the profile of a user.
+func (p *SupabasePolicy) UserAccess(ctx context.Context, userID string) (*models.UserAccess, error) {
+	// PostgREST always returns an array even for a single-row filter.
+	var profiles []models.UserAccess
+	query := url.Values{"select": {profileColumns}, "id": {"eq." + userID}}
+	if err := p.do(ctx, http.MethodGet, query, nil, &profiles); err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("%w: no profile for ID %s", ErrUnknownUser, userID)
+	}
+	profiles[0].UserID = userID
+	return &profiles[0], nil
+}
+
+// ConsumeTrial() => takes one attempt of a trial from a user.
+func (p *SupabasePolicy) ConsumeTrial(ctx context.Context, userID, trial string) error {
+	return p.addTrialAttempts(ctx, userID, trial, -1)
+}
+
+// RefundTrial() => gives an attempt of a trial back to a user.
+func (p *SupabasePolicy) RefundTrial(ctx context.Context, userID, trial string) error {
+	return p.addTrialAttempts(ctx, userID, trial, 1)
+}
+
+// addTrialAttempts() => adds delta to a trial counter of a user, with a compare-and-set. The counter never goes below zero.
+func (p *SupabasePolicy) addTrialAttempts(ctx context.Context, userID, trial string, delta int) error {
+	column, ok := profileTrialColumns[trial]
+	if !ok {
+		return fmt.Errorf("unknown trial %q", trial)
+	}
+
+	for round := 0; round < maxTrialRetries; round++ {
+		access, err := p.UserAccess(ctx, userID)
+		if err != nil {
+			return err
+		}
+		current, _ := access.TrialAttempts(trial)
+		if current+delta < 0 {
+			return fmt.Errorf("%w (%s)", ErrNoTrialAttempts, trial)
+		}
+
+		// Only matches the row while the counter still holds the value read
+		query := url.Values{
+			"select": {column},
+			"id":     {"eq." + userID},
+			column:   {fmt.Sprintf("eq.%d", current)},
+		}
+		var updated []map[string]int
+		if err := p.do(ctx, http.MethodPatch, query, map[string]int{column: current + delta}, &updated); err != nil {
+			return err
+		}
+		if len(updated) > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("the %s trial attempts of %s kept changing, please try again", trial, userID)
+}
+
+// do() => sends a request to the profiles table, and decodes the rows it returns into out.
+func (p *SupabasePolicy) do(ctx context.Context, method string, query url.Values, body any, out any) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.url+"/rest/v1/"+profilesTable+"?"+query.Encode(), &payload)
+	if err != nil {
+		return fmt.Errorf("failed to build Supabase request: %w", err)
+	}
+
+	// Both headers are required by Supabase PostgREST.
+	req.Header.Set("apikey", p.serviceRoleKey)
+	req.Header.Set("Authorization", "Bearer "+p.serviceRoleKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Prefer", "return=representation") // Return the updated rows, to know whether the PATCH matched
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Supabase: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Supabase returned unexpected status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode Supabase response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+admin_handler.go
+In this file, you'll find the handlers of the /admin endpoints, reserved to admins (see middleware/admin.go):
+they review and run the cleanup of the AWS resources the generator left behind, and manage the API keys of the clients.
+*/
+
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/services"
+	"github.com/gin-gonic/gin"
+)
+
+type AdminHandler struct {
+	garbageCollector *services.GarbageCollector
+	apiKeyService    *services.APIKeyService
+}
+
+// NewAdminHandler() => creates a new admin handler.
+func NewAdminHandler(garbageCollector *services.GarbageCollector, apiKeyService *services.APIKeyService) *AdminHandler {
+	return &AdminHandler{
+		garbageCollector: garbageCollector,
+		apiKeyService:    apiKeyService,
+	}
+}
+
+// GET - CleanupReport() => Handles GET /admin/cleanup: a dry run listing what the cleanup would do, nothing is deleted.
+func (h *AdminHandler) CleanupReport(c *gin.Context) {
+	h.cleanup(c, true)
+}
+
+// POST - RunCleanup() => Handles POST /admin/cleanup: terminates and deletes the orphaned resources (?dry_run=true only reports them).
+func (h *AdminHandler) RunCleanup(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	h.cleanup(c, dryRun)
+}
+
+// cleanup() => runs a garbage collection pass and sends its report.
+func (h *AdminHandler) cleanup(c *gin.Context, dryRun bool) {
+	report, err := h.garbageCollector.Collect(c.Request.Context(), dryRun)
+	if err != nil {
+		log.Printf("Cleanup failed: %v", err)
+		c.JSON(http.StatusBadGateway, models.ErrorResponse{
+			Error:   "Cleanup Failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if !dryRun {
+		log.Printf("Cleanup run by %s (%s): %d resources", c.GetString("user_id"), c.GetString("auth_method"), report.Count)
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+/*
+POST - CreateAPIKey() => Handles POST /admin/api-keys: creates an API key for owner_id with the given scopes (create,
+read, stop, admin) and optional expiry. The key is only returned in this response, the backend keeps its hash.
+*/
+func (h *AdminHandler) CreateAPIKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid Request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	createdBy := c.GetString("user_id")
+	if createdBy == "" {
+		createdBy = c.GetString("auth_method")
+	}
+	created, err := h.apiKeyService.Create(c.Request.Context(), req, createdBy)
+	if err != nil {
+		log.Printf("Failed to create API key: %v", err)
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid Request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	log.Printf("API key %s (%s) created by %s for %s with scopes %v", created.APIKey.ID, created.APIKey.Name, createdBy, created.APIKey.OwnerID, created.APIKey.Scopes)
+	c.JSON(http.StatusCreated, created)
+}
+
+// GET - ListAPIKeys() => Handles GET /admin/api-keys: every API key, revoked ones included (never the keys themselves).
+func (h *AdminHandler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.apiKeyService.List(c.Request.Context())
+	if err != nil {
+		log.Printf("Failed to list API keys: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Listing Failed",
+			Message: err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIKeyListResponse{
+		APIKeys: keys,
+		Count:   len(keys),
+	})
+}
+
+// DELETE - RevokeAPIKey() => Handles DELETE /admin/api-keys/:id: the key is refused from now on.
+func (h *AdminHandler) RevokeAPIKey(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.apiKeyService.Revoke(c.Request.Context(), id); err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, models.ErrorResponse{
+				Error:   "Not Found",
+				Message: err.Error(),
+			})
+			return
+		}
+		log.Printf("Failed to revoke API key %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Revocation Failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	log.Printf("API key %s revoked by %s (%s)", id, c.GetString("user_id"), c.GetString("auth_method"))
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked", "id": id})
+}
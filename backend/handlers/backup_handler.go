@@ -0,0 +1,116 @@
+/*
+backup_handler.go
+In this file, you'll find the handlers of the world backups of a Minecraft server (/minecraft/servers/:id/backups):
+listing them and requesting a new one, and the download of its current world (/minecraft/servers/:id/world). The backups
+and the downloads are packaged by the server itself (see services/backup_service.go and services/world_download.go).
+*/
+
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/services"
+	"github.com/gin-gonic/gin"
+)
+
+type BackupHandler struct {
+	minecraftHandler *MinecraftHandler // Checks that the caller owns the server
+	backupService    *services.BackupService
+}
+
+// NewBackupHandler() => creates a new backup handler.
+func NewBackupHandler(minecraftHandler *MinecraftHandler, backupService *services.BackupService) *BackupHandler {
+	return &BackupHandler{
+		minecraftHandler: minecraftHandler,
+		backupService:    backupService,
+	}
+}
+
+/*
+GET - ListBackups() => Handles GET /minecraft/servers/:id/backups. Lists the backups of a server owned by the caller,
+newest first, including the requested ones not taken yet. Backups outlive their server.
+*/
+func (h *BackupHandler) ListBackups(c *gin.Context) {
+	instanceID := c.Param("id")
+	if !h.minecraftHandler.authorizeServer(c, instanceID) {
+		return
+	}
+
+	backups, err := h.backupService.ListBackups(c.Request.Context(), instanceID)
+	if err != nil {
+		log.Printf("Failed to list the backups of %s: %v", instanceID, err)
+		backupError(c, err, "Failed to List Backups")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.BackupListResponse{
+		InstanceID: instanceID,
+		Backups:    backups,
+		Count:      len(backups),
+	})
+}
+
+/*
+POST - RequestBackup() => Handles POST /minecraft/servers/:id/backups. Asks a running server owned by the caller for
+a backup; it is taken within a minute and shows up in the list as "completed".
+*/
+func (h *BackupHandler) RequestBackup(c *gin.Context) {
+	instanceID := c.Param("id")
+	if !h.minecraftHandler.authorizeServer(c, instanceID) {
+		return
+	}
+
+	log.Printf("Backup of %s requested (user %s)", instanceID, c.GetString("user_id"))
+	backup, err := h.backupService.RequestBackup(c.Request.Context(), instanceID)
+	if err != nil {
+		log.Printf("Failed to request a backup of %s: %v", instanceID, err)
+		backupError(c, err, "Failed to Request Backup")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, backup)
+}
+
+/*
+GET - DownloadWorld() => Handles GET /minecraft/servers/:id/world. Has a running server owned by the caller save and
+zip its current world, and returns a pre-signed link to the zip (valid for an hour). It answers within a few minutes.
+*/
+func (h *BackupHandler) DownloadWorld(c *gin.Context) {
+	instanceID := c.Param("id")
+	if !h.minecraftHandler.authorizeServer(c, instanceID) {
+		return
+	}
+
+	log.Printf("Download of the world of %s requested (user %s)", instanceID, c.GetString("user_id"))
+	download, err := h.backupService.DownloadWorld(c.Request.Context(), instanceID)
+	if err != nil {
+		log.Printf("Failed to download the world of %s: %v", instanceID, err)
+		backupError(c, err, "Failed to Download World")
+		return
+	}
+
+	c.JSON(http.StatusOK, download)
+}
+
+// backupError() => sends the error response of a backup operation.
+func backupError(c *gin.Context, err error, title string) {
+	status := http.StatusBadGateway // The bucket could not be reached
+	switch {
+	case errors.Is(err, services.ErrBackupsDisabled):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, services.ErrServerNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, services.ErrServerNotRunning):
+		status = http.StatusConflict
+	case errors.Is(err, services.ErrWorldDownloadTimeout):
+		status = http.StatusGatewayTimeout
+	}
+	c.JSON(status, models.ErrorResponse{
+		Error:   title,
+		Message: err.Error(),
+	})
+}
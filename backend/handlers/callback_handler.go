@@ -0,0 +1,70 @@
+/*
+callback_handler.go
+In this file, you'll find the handler of POST /minecraft/callbacks, where the servers report their lifecycle events
+(see services/callback_service.go). It is not behind the AuthMiddleware: every callback is signed with the callback
+token of the server it comes from.
+*/
+
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Callbacks are a few hundred bytes: anything bigger is not one
+const maxCallbackSize = 4 << 10
+
+type CallbackHandler struct {
+	callbackService *services.CallbackService
+}
+
+// NewCallbackHandler() => creates a new callback handler.
+func NewCallbackHandler(callbackService *services.CallbackService) *CallbackHandler {
+	return &CallbackHandler{
+		callbackService: callbackService,
+	}
+}
+
+/*
+POST - ReceiveCallback() => Handles POST /minecraft/callbacks. The body is a JSON models.InstanceCallback, the
+X-Callback-Timestamp header its Unix time and X-Callback-Signature "sha256=<hex HMAC of '<timestamp>.<body>'>".
+Answers 204 No Content once the callback is recorded, 401 Unauthorized when it cannot be trusted.
+*/
+func (h *CallbackHandler) ReceiveCallback(c *gin.Context) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxCallbackSize))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, models.ErrorResponse{
+			Error:   "Invalid Callback",
+			Message: "callback body is too large",
+		})
+		return
+	}
+
+	callback, err := h.callbackService.HandleCallback(c.Request.Context(),
+		c.GetHeader("X-Callback-Timestamp"), c.GetHeader("X-Callback-Signature"), body)
+	if err != nil {
+		log.Printf("Rejected callback from %s: %v", c.ClientIP(), err)
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrCallbacksDisabled):
+			status = http.StatusServiceUnavailable
+		case errors.Is(err, services.ErrInvalidCallback):
+			status = http.StatusUnauthorized
+		}
+		c.JSON(status, models.ErrorResponse{
+			Error:   "Callback Rejected",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	log.Printf("Callback from %s: %s", callback.InstanceID, callback.Event)
+	c.Status(http.StatusNoContent)
+}
@@ -8,29 +8,77 @@ package handlers
 
 //Importing the necessary libraries.
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
 	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/services"
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 )
 
 
 type MinecraftHandler struct {
-	minecraftService *services.MinecraftService
+	minecraftService    *services.MinecraftService
+	provisioningService *services.ProvisioningService
+	serverMonitor       *services.ServerMonitor
 }
 
 // NewMinecraftHandler() => creates a new Minecraft handler and returns an object (struct) of type MinecraftHandler (defined in line 22).
-func NewMinecraftHandler(minecraftService *services.MinecraftService) *MinecraftHandler {
+func NewMinecraftHandler(minecraftService *services.MinecraftService, provisioningService *services.ProvisioningService, serverMonitor *services.ServerMonitor) *MinecraftHandler {
 	return &MinecraftHandler{
-		minecraftService: minecraftService,
+		minecraftService:    minecraftService,
+		provisioningService: provisioningService,
+		serverMonitor:       serverMonitor,
 	}
 }
 
+// requesterFrom() => identifies the caller from what the AuthMiddleware stored in the request context.
+func requesterFrom(c *gin.Context) services.Requester {
+	return services.Requester{
+		UserID: c.GetString("user_id"),
+		Admin:  c.GetBool("is_admin"),
+	}
+}
+
+/*
+authorizeServer() => checks that the caller owns the Minecraft server instanceID. When it does not, the error
+response is written (404 for instances that are not Minecraft servers, 403 for servers of other users) and false is returned.
+*/
+func (h *MinecraftHandler) authorizeServer(c *gin.Context, instanceID string) bool {
+	err := h.minecraftService.AuthorizeServerAccess(c.Request.Context(), instanceID, requesterFrom(c))
+	if err == nil {
+		return true
+	}
+
+	log.Printf("Access to server %s refused for user %s: %v", instanceID, c.GetString("user_id"), err)
+	if errors.Is(err, services.ErrServerAccessDenied) {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:   "Forbidden",
+			Message: "You can only manage the servers you created",
+		})
+		return false
+	}
+	c.JSON(http.StatusNotFound, models.ErrorResponse{
+		Error:   "Server Not Found",
+		Message: err.Error(),
+	})
+	return false
+}
+
+// ownsJob() => reports whether the caller may see a provisioning job (its creator, or an admin).
+func ownsJob(c *gin.Context, job *models.ProvisioningJob) bool {
+	requester := requesterFrom(c)
+	return requester.Admin || (requester.UserID != "" && job.OwnerID == requester.UserID)
+}
+
 /* 
 POST - CreateMinecraftServer() => This method handles POST requests to the endpoint /minecraft/create, whose request body includes
 the parameters that are necessary to create a new mineraft server (basically information that can be changed in the server.properties file).
@@ -52,6 +100,21 @@ func (h *MinecraftHandler) CreateMinecraftServer(c *gin.Context) {
 	log.Printf("DEBUG Handler: OnlineMode value after JSON binding: %t", req.OnlineMode)
 	log.Printf("DEBUG Handler: PVP value: %t, EnableCommand value: %t", req.PVP, req.EnableCommand)
 
+	// The server belongs to the authenticated user (set by the AuthMiddleware), never to what the body claims.
+	req.OwnerID = c.GetString("user_id")
+
+	// And it is charged on one of their trial attempts, admins excepted (API_KEY and the admin API keys included)
+	req.ChargeTrial = req.OwnerID != "" && !requesterFrom(c).Admin
+
+	// Only admins may keep a server up when nobody plays on it
+	if req.IdlePolicy.NeverIdle && !requesterFrom(c).Admin {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:   "Forbidden",
+			Message: "idle_policy.never_idle is reserved to admins",
+		})
+		return
+	}
+
 	// Setting the req.ServerName to "minecraft-server" as default if it is not specified in the request body.
 	if req.ServerName == "" {
 		req.ServerName = "minecraft-server"
@@ -61,25 +124,144 @@ func (h *MinecraftHandler) CreateMinecraftServer(c *gin.Context) {
 	log.Printf("Received request to create Minecraft server: %s (Type: %s, Version: %s)", 
 		req.ServerName, req.MinecraftType, req.Version)
 
-	/* Executing the method CreateMinecraftServer() defined at /services/minecraftService.go using as parameter the information obtained 
-	from the request body and stored in the var req. The method returns an object (struct) of type MinecraftServerResponse (whose 
-	definition can be found at /models/minecraft) and is currently stored in server. If not successful, the service method returns an error.
+	/* Queueing the creation in the ProvisioningService (defined at /services/provisioning_service.go) using as parameter the
+	information obtained from the request body and stored in the var req. Creating the server takes several minutes, so the
+	request is answered right away (202 Accepted) with a job ID that can be polled at GET /minecraft/jobs/:id.
 	*/
-	server, err := h.minecraftService.CreateMinecraftServer(req)
-	//If an error is returned from the CreateMinecraftServer() service, then it is displayed as log on the terminal.
+	job, err := h.provisioningService.Submit(c.Request.Context(), req)
 	if err != nil {
-		log.Printf("Failed to create Minecraft server: %v", err)
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+		log.Printf("Failed to queue Minecraft server creation: %v", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidServerRequest) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, services.ErrNoTrialAttempts) {
+			status = http.StatusForbidden
+		} else if errors.Is(err, services.ErrProvisioningQueueFull) {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, models.ErrorResponse{
 			Error:   "Minecraft Server Creation Failed",
 			Message: err.Error(),
 		})
 		return
 	}
-	//Log to the terminal if the server was created successfully.
-	log.Printf("Successfully created Minecraft server: %s (IP: %s)", server.InstanceID, server.PublicIP)
+	//Log to the terminal if the server creation was queued successfully.
+	log.Printf("Queued Minecraft server creation: %s (Job: %s)", req.ServerName, job.JobID)
+
+	// Return accepted response, pointing to the job status endpoint.
+	statusURL := fmt.Sprintf("/minecraft/jobs/%s", job.JobID)
+	c.Header("Location", statusURL)
+	c.JSON(http.StatusAccepted, models.ProvisioningJobAccepted{
+		JobID:     job.JobID,
+		Phase:     job.Phase,
+		StatusURL: statusURL,
+		Message:   "Minecraft server creation started. It usually takes 2-3 minutes for the server to be ready.",
+	})
+}
+
+// GET - GetJob() => Handles GET /minecraft/jobs/:id, reporting the progress of a server creation.
+func (h *MinecraftHandler) GetJob(c *gin.Context) {
+	jobID := c.Param("id")
+
+	job, err := h.provisioningService.GetJob(jobID)
+	if err == nil && !ownsJob(c, job) {
+		err = services.ErrJobNotFound // Other users' jobs are not revealed
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Job Not Found",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+/*
+GET - StreamServerEvents() => Handles GET /minecraft/servers/:id/events. It streams the lifecycle of a server as
+Server-Sent Events (EC2 state changes, setup stages, Docker image pull and the Minecraft "Done" line). The id can
+be the instance ID or the job ID returned by POST /minecraft/create. Clients that reconnect with the Last-Event-ID
+header only receive the events they missed.
+*/
+func (h *MinecraftHandler) StreamServerEvents(c *gin.Context) {
+	id := c.Param("id")
+	lastEventID, _ := strconv.ParseInt(c.GetHeader("Last-Event-ID"), 10, 64)
+
+	// The stream of a job is visible to the job's owner, the stream of an instance to the server's owner.
+	if job, err := h.provisioningService.GetJob(id); err == nil {
+		if !ownsJob(c, job) {
+			c.JSON(http.StatusNotFound, models.ErrorResponse{
+				Error:   "Server Not Found",
+				Message: services.ErrJobNotFound.Error(),
+			})
+			return
+		}
+	} else if !h.authorizeServer(c, id) {
+		return
+	}
+
+	events, unsubscribe, err := h.serverMonitor.Subscribe(id, lastEventID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Server Not Found",
+			Message: err.Error(),
+		})
+		return
+	}
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // Ask reverse proxies not to buffer the stream
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-keepAlive.C:
+			// SSE comment line: ignored by clients, but keeps proxies from closing an idle connection.
+			fmt.Fprint(w, ": keep-alive\n\n")
+			return true
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.Render(-1, sse.Event{
+				Id:    strconv.FormatInt(event.ID, 10),
+				Event: string(event.Type),
+				Data:  event,
+			})
+			return true
+		}
+	})
+}
+
+// GET - ListServers() => Handles GET /minecraft/servers, returning the servers created by the caller.
+// Terminated servers are included with ?include_terminated=true.
+func (h *MinecraftHandler) ListServers(c *gin.Context) {
+	includeTerminated, _ := strconv.ParseBool(c.Query("include_terminated"))
+	servers, err := h.minecraftService.ListServers(c.Request.Context(), requesterFrom(c), includeTerminated)
+	if err != nil {
+		log.Printf("Failed to list servers for user %s: %v", c.GetString("user_id"), err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrServerAccessDenied) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, models.ErrorResponse{
+			Error:   "Failed to List Servers",
+			Message: err.Error(),
+		})
+		return
+	}
 
-	// Return success response.
-	c.JSON(http.StatusCreated, server)
+	c.JSON(http.StatusOK, models.MinecraftServerListResponse{
+		Servers: servers,
+		Count:   len(servers),
+	})
 }
 
 // GET - GetServerInfo() => Handles GET /minecraft/info/:instance_id
@@ -96,10 +278,15 @@ func (h *MinecraftHandler) GetServerInfo(c *gin.Context) {
 		return
 	}
 
-	// Fetch instance information from EC2
+	// Only the owner of a Minecraft server may see it
+	if !h.authorizeServer(c, instanceID) {
+		return
+	}
+
+	// Fetch the server from the registry (refreshed from EC2 when the recorded state is not recent)
 	log.Printf("Fetching information for instance: %s", instanceID)
 	
-	instanceInfo, err := h.minecraftService.GetInstanceInfo(instanceID)
+	server, err := h.minecraftService.GetServer(c.Request.Context(), instanceID)
 	if err != nil {
 		log.Printf("Failed to get instance info: %v", err)
 		c.JSON(http.StatusNotFound, models.ErrorResponse{
@@ -108,24 +295,108 @@ func (h *MinecraftHandler) GetServerInfo(c *gin.Context) {
 		})
 		return
 	}
+	history, err := h.minecraftService.ServerHistory(c.Request.Context(), instanceID)
+	if err != nil {
+		log.Printf("Failed to get history of %s: %v", instanceID, err)
+	}
 
 	// Build Minecraft server response
 	response := models.MinecraftServerResponse{
-		InstanceID:       instanceInfo.InstanceID,
-		PublicIP:         instanceInfo.PublicIP,
-		PrivateIP:        instanceInfo.PrivateIP,
-		State:            instanceInfo.State,
-		InstanceType:     instanceInfo.InstanceType,
-		LaunchTime:       instanceInfo.LaunchTime,
-		AvailabilityZone: instanceInfo.AvailabilityZone,
-		ServerPort:       25565,
-		ServerAddress:    fmt.Sprintf("%s:25565", instanceInfo.PublicIP),
-		Message:          fmt.Sprintf("Instance is %s", instanceInfo.State),
+		InstanceID:        server.InstanceID,
+		PublicIP:          server.PublicIP,
+		PrivateIP:         server.PrivateIP,
+		State:             server.State,
+		InstanceType:      server.InstanceType,
+		LaunchTime:        server.LaunchTime,
+		AvailabilityZone:  server.AvailabilityZone,
+		ServerName:        server.ServerName,
+		MinecraftVersion:  server.MinecraftVersion,
+		ServerType:        server.ServerType,
+		ServerPort:        25565,
+		Message:           fmt.Sprintf("Instance is %s", server.State),
+		CreatedAt:         server.CreatedAt,
+		TerminatedAt:      server.TerminatedAt,
+		TerminationReason: server.TerminationReason,
+		History:           history,
+		DataVolumeID:      server.DataVolumeID,
+		ReadyAt:           server.ReadyAt,
+		LastCallback:      server.LastCallback,
+		LastCallbackAt:    server.LastCallbackAt,
+	}
+	if server.Request != nil {
+		// Servers created before idle policies existed have the default one
+		policy := server.Request.IdlePolicy
+		policy.SetDefaults()
+		response.IdlePolicy = &policy
+	}
+	if server.PublicIP != "" {
+		response.ServerAddress = fmt.Sprintf("%s:25565", server.PublicIP)
+	}
+
+	// A running instance is not enough: ask Minecraft itself (Server List Ping) whether it accepts players.
+	if server.State == "running" && server.PublicIP != "" {
+		response.MinecraftStatus = h.minecraftService.GetMinecraftStatus(response.ServerAddress)
+		if response.MinecraftStatus.Online {
+			response.Message = fmt.Sprintf("Minecraft server is ready (%d/%d players online)",
+				response.MinecraftStatus.PlayersOnline, response.MinecraftStatus.PlayersMax)
+		} else {
+			response.Message = "Instance is running, the Minecraft server is still starting"
+		}
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
+/*
+POST - RunServerCommand() => Handles POST /minecraft/servers/:id/command. It runs a console command on a running
+server through RCON (e.g. {"command": "say Hello!"}, "op Steve", "weather clear") and returns the server's output.
+*/
+func (h *MinecraftHandler) RunServerCommand(c *gin.Context) {
+	instanceID := c.Param("id")
+
+	var req models.ServerCommandRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid Request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Only the owner of a Minecraft server may run commands on it
+	if !h.authorizeServer(c, instanceID) {
+		return
+	}
+
+	// Commands are logged for auditing (the output is not, it may be long).
+	log.Printf("Running command on %s (user %s): %s", instanceID, c.GetString("user_id"), req.Command)
+
+	output, err := h.minecraftService.ExecuteCommand(c.Request.Context(), instanceID, req.Command)
+	if err != nil {
+		log.Printf("Failed to run command on %s: %v", instanceID, err)
+		status := http.StatusBadGateway // The server did not accept the RCON connection or the command
+		switch {
+		case errors.Is(err, services.ErrInvalidServerRequest):
+			status = http.StatusBadRequest
+		case errors.Is(err, services.ErrServerNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, services.ErrServerNotRunning), errors.Is(err, services.ErrCredentialsNotFound):
+			status = http.StatusConflict
+		}
+		c.JSON(status, models.ErrorResponse{
+			Error:   "Command Failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ServerCommandResponse{
+		InstanceID: instanceID,
+		Command:    req.Command,
+		Output:     output,
+	})
+}
+
 // GET HealthCheck() => Handles request GET /minecraft/health
 func (h *MinecraftHandler) HealthCheck(c *gin.Context) {
 	// Try to list all instances to verify service is working
@@ -229,7 +500,7 @@ func extractIPFromOutput(output string) string {
 	return ""
 }
 
-// DELETE - StopServer() => Handles DELETE /minecraft/stop/:instance_id
+// DELETE - StopServer() => Handles DELETE /minecraft/stop/:instance_id (kept for the frontend, same as POST /minecraft/servers/:id/stop)
 func (h *MinecraftHandler) StopServer(c *gin.Context) {
 	instanceID := c.Param("instance_id")
 	
@@ -241,22 +512,89 @@ func (h *MinecraftHandler) StopServer(c *gin.Context) {
 		return
 	}
 
-	log.Printf("Stopping Minecraft server instance: %s", instanceID)
+	h.changeServerState(c, instanceID, "stop")
+}
+
+// POST - StopMinecraftServer() => Handles POST /minecraft/servers/:id/stop. The server keeps its world.
+func (h *MinecraftHandler) StopMinecraftServer(c *gin.Context) {
+	h.changeServerState(c, c.Param("id"), "stop")
+}
+
+// POST - StartMinecraftServer() => Handles POST /minecraft/servers/:id/start. Its event stream reports when it runs again, with its new IP.
+func (h *MinecraftHandler) StartMinecraftServer(c *gin.Context) {
+	h.changeServerState(c, c.Param("id"), "start")
+}
+
+// DELETE - TerminateMinecraftServer() => Handles DELETE /minecraft/servers/:id. The server and its world are deleted, unless the world is on a data volume.
+func (h *MinecraftHandler) TerminateMinecraftServer(c *gin.Context) {
+	h.changeServerState(c, c.Param("id"), "terminate")
+}
+
+/*
+changeServerState() => runs a lifecycle operation (stop, start or terminate) on a server owned by the caller and
+sends the resulting state. Operations that are already done (stopping a stopped server...) simply succeed. A start is
+only accepted (202): the server boots for minutes, which its event stream reports.
+*/
+func (h *MinecraftHandler) changeServerState(c *gin.Context, instanceID, action string) {
+	// Only the owner of a Minecraft server may change its state
+	if !h.authorizeServer(c, instanceID) {
+		return
+	}
+
+	log.Printf("Request to %s Minecraft server %s (user %s)", action, instanceID, c.GetString("user_id"))
 
-	err := h.minecraftService.StopInstance(instanceID)
+	ctx := c.Request.Context()
+	var server *models.ServerRecord
+	var err error
+	var message, errorTitle string
+	status := http.StatusOK
+	switch action {
+	case "stop":
+		server, err = h.minecraftService.StopServer(ctx, instanceID, "stopped by the owner through the API")
+		message, errorTitle = "Server is stopping. Its world is kept and it can be started again.", "Failed to Stop Server"
+	case "start":
+		server, err = h.minecraftService.StartServer(ctx, instanceID, "started by the owner through the API")
+		message, errorTitle = "Server is running with its world.", "Failed to Start Server"
+		if err == nil && server.State != "running" {
+			// EC2 boots it for minutes: its event stream reports it running, with its new public IP
+			h.serverMonitor.Watch(instanceID, instanceID)
+			status = http.StatusAccepted
+			message = "Server is starting with its world. It gets a new public IP every time it starts, sent by GET " +
+				"/minecraft/servers/" + instanceID + "/events once it is running."
+		}
+	case "terminate":
+		server, err = h.minecraftService.TerminateServer(ctx, instanceID, "terminated by the owner through the API")
+		message, errorTitle = "Server is being terminated. Its world is deleted with it.", "Failed to Terminate Server"
+		if err == nil && server.DataVolumeID != "" {
+			message = fmt.Sprintf("Server is being terminated. Its world is kept on data volume %s: create a server with "+
+				"\"data_volume_id\": %q to play it again.", server.DataVolumeID, server.DataVolumeID)
+		}
+	}
 	if err != nil {
-		log.Printf("Failed to stop instance: %v", err)
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to Stop Server",
+		log.Printf("Failed to %s server %s: %v", action, instanceID, err)
+		status = http.StatusBadGateway
+		switch {
+		case errors.Is(err, services.ErrServerNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, services.ErrServerTerminated), errors.Is(err, services.ErrServerNotStopped):
+			status = http.StatusConflict
+		}
+		c.JSON(status, models.ErrorResponse{
+			Error:   errorTitle,
 			Message: err.Error(),
 		})
 		return
 	}
 
-	log.Printf("Successfully stopped instance: %s", instanceID)
-	c.JSON(http.StatusOK, gin.H{
-		"status":  "success",
-		"message": "Server stopped successfully",
-		"instance_id": instanceID,
-	})
+	response := models.ServerLifecycleResponse{
+		InstanceID: instanceID,
+		Action:     action,
+		State:      server.State,
+		PublicIP:   server.PublicIP,
+		Message:    message,
+	}
+	if server.PublicIP != "" {
+		response.ServerAddress = fmt.Sprintf("%s:25565", server.PublicIP)
+	}
+	c.JSON(status, response)
 }
@@ -0,0 +1,92 @@
+/*
+world_handler.go
+In this file, you'll find the handler of POST /minecraft/worlds, where players upload an existing world (a zip
+archive) to create a server with it (see services/world_service.go).
+*/
+
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/services"
+	"github.com/gin-gonic/gin"
+)
+
+type WorldHandler struct {
+	worldService *services.WorldService
+}
+
+// NewWorldHandler() => creates a new world handler.
+func NewWorldHandler(worldService *services.WorldService) *WorldHandler {
+	return &WorldHandler{
+		worldService: worldService,
+	}
+}
+
+/*
+POST - UploadWorld() => Handles POST /minecraft/worlds, a multipart form whose "world" file is a zip archive of a
+Minecraft world. It is validated (level.dat, size, no path escaping the world) and stored; the returned world_id
+can then be used when creating a server.
+*/
+func (h *WorldHandler) UploadWorld(c *gin.Context) {
+	if !h.worldService.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, models.ErrorResponse{
+			Error:   "World Uploads Unavailable",
+			Message: services.ErrWorldUploadsDisabled.Error(),
+		})
+		return
+	}
+
+	// The multipart overhead is small: anything well above the archive limit is refused while it is read
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.worldService.MaxUploadSize()+1<<20)
+	fileHeader, err := c.FormFile("world")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, models.ErrorResponse{
+				Error:   "World Too Large",
+				Message: fmt.Sprintf("World archives are limited to %d MiB", h.worldService.MaxUploadSize()>>20),
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid Request",
+			Message: "Send the world as a zip archive in the \"world\" field of a multipart form: " + err.Error(),
+		})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid Request",
+			Message: err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	world, err := h.worldService.UploadWorld(c.Request.Context(), file, fileHeader.Size, fileHeader.Filename, c.GetString("user_id"))
+	if err != nil {
+		log.Printf("World upload of user %s refused: %v", c.GetString("user_id"), err)
+		status := http.StatusBadGateway // The bucket could not be reached
+		switch {
+		case errors.Is(err, services.ErrInvalidWorld):
+			status = http.StatusBadRequest
+		case errors.Is(err, services.ErrWorldTooLarge):
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.JSON(status, models.ErrorResponse{
+			Error:   "World Upload Failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, world)
+}
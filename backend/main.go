@@ -8,12 +8,24 @@ package main
 
 //Importing necessary packages.
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/access"
 	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/handlers"
 	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/middleware"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
 	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/services"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/storage"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -25,6 +37,11 @@ func main() {
 		log.Println("No .env file found")
 	}
 
+	// Cancelled on SIGINT / SIGTERM (Render sends SIGTERM on deploys), so background loops and the HTTP server stop cleanly.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var background sync.WaitGroup
+
 
 	//Obtaining the only allowed origin from the .env file.
 	allowedOrigin := os.Getenv("ALLOWED_ORIGIN")
@@ -36,19 +53,250 @@ func main() {
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{allowedOrigin}, // Assigning allowed origin obtained from .env
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Last-Event-ID", "X-API-Key"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 	}))
 
 	// Initialize EC2 Service
-	ec2Service, err := services.NewEC2Service()
-	if err != nil { // Handling error if EC2 service fails to initialize
- 		log.Fatalf("Failed to initialize EC2 service: %v", err)
+	var ec2Service *services.EC2Service
+	if os.Getenv("EC2_BACKEND") == "fake" {
+		// Offline mode: the in-memory fake cloud simulates EC2, so the whole flow can be tried on a laptop.
+		log.Println("EC2_BACKEND=fake: using the in-memory fake cloud, no AWS resources will be created")
+		fakeCloud := services.NewFakeEC2(os.Getenv("AWS_REGION"))
+		fakeCloud.BootDelay = 5 * time.Second
+		fakeCloud.StopDelay = 5 * time.Second
+		fakeCloud.BootConsole = services.SimulatedBootConsole(10 * time.Second)
+		ec2Service = services.NewEC2ServiceWithClient(fakeCloud)
+		ec2Service.SetPollInterval(time.Second)
+	} else {
+		var err error
+		ec2Service, err = services.NewEC2Service()
+		if err != nil { // Handling error if EC2 service fails to initialize
+ 			log.Fatalf("Failed to initialize EC2 service: %v", err)
+		}
+	}
+
+	/*
+	Initialize the Server Registry: the record of every server created (request, owner, lifecycle, termination reason)
+	and of their secrets. It is a SQLite file by default; the fake cloud keeps it in memory unless REGISTRY_PATH is set.
+	*/
+	registryPath := os.Getenv("REGISTRY_PATH")
+	if registryPath == "" && os.Getenv("EC2_BACKEND") == "fake" {
+		registryPath = ":memory:"
+	} else if registryPath == "" {
+		registryPath = "data/minecraft-servers.db"
+	}
+	registry, err := storage.NewSQLiteRegistry(registryPath)
+	if err != nil {
+		log.Fatalf("Failed to open the server registry: %v", err)
+	}
+	defer registry.Close()
+	log.Printf("Server registry: %s", registryPath)
+
+	// Load the keys verifying the Supabase JWTs: a JWKS document (URL or file) cached and reloaded on key rotation,
+	// or the single key of SUPABASE_JWT_PUBLIC_KEY
+	keyProvider, err := middleware.NewKeyProviderFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid JWT signing key configuration: %v", err)
+	}
+	if keyProvider != nil {
+		middleware.SetKeyProvider(keyProvider)
+		log.Printf("JWT signing keys: %s", keyProvider.Source())
+	} else {
+		log.Println("Warning: no JWT signing key configured, only API key requests will be authorized")
+	}
+
+	// And what their claims must say: issuer, audience, expiry (with some leeway), a logged in user. Without signing
+	// keys no JWT is accepted, so there is nothing to check
+	if keyProvider != nil {
+		claimRules, err := middleware.ClaimRulesFromEnv()
+		if err != nil {
+			log.Fatalf("Invalid JWT claim configuration: %v", err)
+		}
+		middleware.SetClaimRules(claimRules)
+		log.Printf("JWT issuer: %s", claimRules.Issuer)
+	}
+
+	// Choose who may use the backend: the Supabase profiles, a static file or the registry (ACCESS_POLICY)
+	accessPolicy, accessSource, err := access.NewPolicyFromEnv(registry)
+	if err != nil {
+		log.Fatalf("Invalid access policy configuration: %v", err)
+	}
+	if accessPolicy != nil {
+		middleware.SetAccessPolicy(accessPolicy)
+		log.Printf("Access policy: %s", accessSource)
+	} else {
+		log.Println("Warning: no access policy configured, only API key requests will be authorized")
+	}
+
+	// Scoped API keys of the clients, stored hashed in the registry and managed by the admins under /admin/api-keys.
+	// API_KEY still works, with every scope: prefer scoped keys, which can be revoked and expire.
+	apiKeyService := services.NewAPIKeyService(registry)
+	middleware.SetAPIKeyAuthenticator(apiKeyService)
+	if os.Getenv("API_KEY") != "" {
+		log.Println("Warning: API_KEY grants full admin access, prefer scoped API keys (POST /admin/api-keys)")
+	}
+
+	// Check the user data template of the servers (embedded in the binary) before launching any
+	if err := services.ValidateUserDataTemplate(); err != nil {
+		log.Fatalf("Invalid EC2 user data template: %v", err)
 	}
 
 	// Initialize Minecraft Service
-	minecraftService := services.NewMinecraftService(ec2Service)
+	minecraftService := services.NewMinecraftService(ec2Service, registry)
+
+	// Initialize Provisioning Service: server creations run in the background on a small pool of workers, and their
+	// progress (plus what the ServerMonitor observes on the instances) is published as server events.
+	provisioningWorkers, err := strconv.Atoi(os.Getenv("PROVISIONING_WORKERS"))
+	if err != nil || provisioningWorkers < 1 {
+		provisioningWorkers = 4
+	}
+	eventBroker := services.NewEventBroker()
+	serverMonitor := services.NewServerMonitor(ec2Service, eventBroker)
+	provisioningService := services.NewProvisioningService(minecraftService, eventBroker, serverMonitor, provisioningWorkers)
+	provisioningService.Start()
+
+	// Initialize the Reconciler: it keeps the registry in line with EC2 (instances terminated by the auto-shutdown
+	// script, stopped from the console...). RECONCILE_INTERVAL is a Go duration ("30s", "5m"); "0" disables it.
+	reconcileInterval := time.Minute
+	if value := os.Getenv("RECONCILE_INTERVAL"); value != "" {
+		reconcileInterval, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid RECONCILE_INTERVAL %q: %v", value, err)
+		}
+	}
+	if reconcileInterval > 0 {
+		reconciler := services.NewReconciler(minecraftService, eventBroker, reconcileInterval)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			reconciler.Run(ctx)
+		}()
+	} else {
+		log.Println("Reconciler disabled (RECONCILE_INTERVAL=0)")
+	}
+
+	/*
+	Initialize the Garbage Collector: it terminates the instances of failed launches and the servers running for longer
+	than MAX_SERVER_LIFETIME since their last start (default 24h, "0" disables it; never_idle servers are exempt) or
+	stopped for longer than MAX_STOPPED_LIFETIME (default 168h, "0" keeps them), deletes the world volumes unused for
+	WORLD_VOLUME_RETENTION (default 720h, "0" keeps them) and unused minecraft-server-sg groups. Admins can review it with GET /admin/cleanup; it only runs on its own when CLEANUP_INTERVAL is set (e.g. "1h").
+	*/
+	cleanupConfig := services.GarbageCollectorConfig{
+		MaxServerLifetime:    24 * time.Hour,
+		MaxStoppedLifetime:   7 * 24 * time.Hour,
+		WorldVolumeRetention: 30 * 24 * time.Hour,
+	}
+	if value := os.Getenv("MAX_SERVER_LIFETIME"); value != "" {
+		cleanupConfig.MaxServerLifetime, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid MAX_SERVER_LIFETIME %q: %v", value, err)
+		}
+	}
+	if value := os.Getenv("MAX_STOPPED_LIFETIME"); value != "" {
+		cleanupConfig.MaxStoppedLifetime, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid MAX_STOPPED_LIFETIME %q: %v", value, err)
+		}
+	}
+	if value := os.Getenv("WORLD_VOLUME_RETENTION"); value != "" {
+		cleanupConfig.WorldVolumeRetention, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid WORLD_VOLUME_RETENTION %q: %v", value, err)
+		}
+	}
+	garbageCollector := services.NewGarbageCollector(minecraftService, eventBroker, cleanupConfig)
+	if value := os.Getenv("CLEANUP_INTERVAL"); value != "" {
+		cleanupInterval, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid CLEANUP_INTERVAL %q: %v", value, err)
+		}
+		if cleanupInterval > 0 {
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				garbageCollector.Run(ctx, cleanupInterval)
+			}()
+		}
+	}
+
+	/*
+	Initialize the Backup Service: the servers back their world up to an S3-compatible bucket (BACKUP_BUCKET) on a
+	schedule (BACKUP_INTERVAL, default 6h, "0" disables it), when they stop or are terminated, and on demand.
+	BACKUP_S3_ENDPOINT points to another S3-compatible storage (e.g. MinIO); the fake cloud keeps backups in memory.
+	*/
+	backupConfig := services.BackupConfig{
+		Bucket:          os.Getenv("BACKUP_BUCKET"),
+		Prefix:          os.Getenv("BACKUP_PREFIX"),
+		Endpoint:        os.Getenv("BACKUP_S3_ENDPOINT"),
+		Region:          os.Getenv("BACKUP_S3_REGION"),
+		AccessKeyID:     os.Getenv("BACKUP_S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("BACKUP_S3_SECRET_ACCESS_KEY"),
+		Interval:        6 * time.Hour,
+	}
+	if backupConfig.Region == "" {
+		backupConfig.Region = os.Getenv("AWS_REGION")
+	}
+	if backupConfig.Region == "" {
+		backupConfig.Region = "us-east-1"
+	}
+	if value := os.Getenv("BACKUP_INTERVAL"); value != "" {
+		backupConfig.Interval, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid BACKUP_INTERVAL %q: %v", value, err)
+		}
+	}
+	var s3Client services.S3API
+	var s3Presigner services.S3Presigner
+	if os.Getenv("EC2_BACKEND") == "fake" && backupConfig.Endpoint == "" {
+		if backupConfig.Bucket == "" {
+			backupConfig.Bucket = "minecraft-backups"
+		}
+		fakeStorage := services.NewFakeS3()
+		s3Client, s3Presigner = fakeStorage, fakeStorage
+	} else if backupConfig.Bucket != "" {
+		client, err := services.NewS3Client(ctx, backupConfig)
+		if err != nil {
+			log.Fatalf("Failed to initialize the backup storage: %v", err)
+		}
+		s3Client, s3Presigner = client, s3.NewPresignClient(client)
+	}
+	backupService := services.NewBackupService(s3Client, s3Presigner, backupConfig, minecraftService)
+	if backupService.Enabled() {
+		log.Printf("World backups enabled (bucket %s)", backupConfig.Bucket)
+	} else {
+		log.Println("World backups disabled (BACKUP_BUCKET is not set)")
+	}
+
+	// Initialize the World Service: players upload their own worlds (zip archives of up to MAX_WORLD_UPLOAD_MB, default
+	// 500) to the backup bucket, and create servers with them.
+	maxWorldUploadMB := 500
+	if value := os.Getenv("MAX_WORLD_UPLOAD_MB"); value != "" {
+		maxWorldUploadMB, err = strconv.Atoi(value)
+		if err != nil || maxWorldUploadMB < 1 {
+			log.Fatalf("Invalid MAX_WORLD_UPLOAD_MB %q", value)
+		}
+	}
+	worldService := services.NewWorldService(s3Client, s3Presigner, backupConfig.Bucket, int64(maxWorldUploadMB)<<20, minecraftService)
+
+	// Initialize the Callback Service: the servers report their lifecycle (setup, ready, idle, shutdown) to
+	// CALLBACK_BASE_URL/minecraft/callbacks. CALLBACK_BASE_URL is the public URL of this backend, as the servers reach it.
+	callbackService := services.NewCallbackService(os.Getenv("CALLBACK_BASE_URL"), minecraftService, eventBroker)
+	if callbackService.Enabled() {
+		log.Printf("Server callbacks enabled (%s)", os.Getenv("CALLBACK_BASE_URL"))
+	} else {
+		log.Println("Server callbacks disabled (CALLBACK_BASE_URL is not set)")
+	}
+
+	// Initialize the Trial Service: creating a server consumes a trial attempt of its owner (the counters of the access
+	// policy), given back when the creation fails
+	trialService := services.NewTrialService(accessPolicy, minecraftService)
+	if trialService.Enabled() {
+		log.Println("Trial attempts enabled: server creations are charged on the access policy")
+	} else {
+		log.Println("Trial attempts disabled (no access policy configured)")
+	}
 
 	// Initialize Version Service and start auto-refresh
 	versionService := services.GetVersionService()
@@ -56,18 +304,23 @@ func main() {
 
 	// Initialize Handlers: Handles the requests and responses from HTTP requests and call the appropriate service methods.
 	ec2Handler := handlers.NewEC2Handler(ec2Service)
-	minecraftHandler := handlers.NewMinecraftHandler(minecraftService)
+	minecraftHandler := handlers.NewMinecraftHandler(minecraftService, provisioningService, serverMonitor)
+	adminHandler := handlers.NewAdminHandler(garbageCollector, apiKeyService)
+	backupHandler := handlers.NewBackupHandler(minecraftHandler, backupService)
+	worldHandler := handlers.NewWorldHandler(worldService)
+	callbackHandler := handlers.NewCallbackHandler(callbackService)
 
 	// Register EC2 routes. API Endpoints related to EC2 instance management.
 	ec2Routes := router.Group("/ec2")
 	
 	/*
-	Protected endpoint: The following endpoint requires authentication. The AuthMiddleware checks for valid authentication tokens from the frontend 
-	before allowing access. It is important to protect this endpoint to prevent unauthorized access to EC2 instances (basically create a Minecraft Server),
-	otherwise everyone could create as many servers as they want and generate an UNEXPECTED increase in computing costs.
+	Admin endpoints: a bare EC2 launch is not charged on any trial attempt and has no owner, so it is reserved to the
+	admins. Everyone else creates servers through POST /minecraft/create, which charges the trial attempt of the owner.
+	Otherwise everyone could create as many servers as they want and generate an UNEXPECTED increase in computing costs.
 	*/
-	ec2Routes.Use(middleware.AuthMiddleware()) // Protected routes
+	ec2Routes.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
 	{
+		ec2Routes.GET("/health", ec2Handler.HealthCheck)
 		ec2Routes.POST("/create", ec2Handler.CreateInstance)
 	}
 
@@ -78,10 +331,33 @@ func main() {
 		minecraftRoutes.GET("/health", minecraftHandler.HealthCheck)
 		
 		// Protected routes (auth required): As explained in line 60, only auth users are allowed to access the following endpoints.
-		minecraftRoutes.POST("/create", middleware.AuthMiddleware(), minecraftHandler.CreateMinecraftServer)
-		minecraftRoutes.GET("/info/:instance_id", middleware.AuthMiddleware(), minecraftHandler.GetServerInfo)
-		minecraftRoutes.DELETE("/stop/:instance_id", middleware.AuthMiddleware(), minecraftHandler.StopServer)
-		minecraftRoutes.POST("/test", middleware.AuthMiddleware(), minecraftHandler.TestServerCreation)
+		minecraftRoutes.POST("/create", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeCreate), minecraftHandler.CreateMinecraftServer)
+		minecraftRoutes.POST("/worlds", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeCreate), worldHandler.UploadWorld)
+		minecraftRoutes.POST("/callbacks", callbackHandler.ReceiveCallback) // Signed by the servers, see the handler
+		minecraftRoutes.GET("/jobs/:id", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeRead), minecraftHandler.GetJob)
+		minecraftRoutes.GET("/servers", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeRead), minecraftHandler.ListServers)
+		minecraftRoutes.GET("/servers/:id/events", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeRead), minecraftHandler.StreamServerEvents)
+		minecraftRoutes.POST("/servers/:id/command", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeStop), minecraftHandler.RunServerCommand)
+		minecraftRoutes.POST("/servers/:id/stop", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeStop), minecraftHandler.StopMinecraftServer)
+		minecraftRoutes.POST("/servers/:id/start", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeStop), minecraftHandler.StartMinecraftServer)
+		minecraftRoutes.DELETE("/servers/:id", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeStop), minecraftHandler.TerminateMinecraftServer)
+		minecraftRoutes.GET("/servers/:id/backups", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeRead), backupHandler.ListBackups)
+		minecraftRoutes.POST("/servers/:id/backups", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeStop), backupHandler.RequestBackup)
+		minecraftRoutes.GET("/servers/:id/world", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeRead), backupHandler.DownloadWorld)
+		minecraftRoutes.GET("/info/:instance_id", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeRead), minecraftHandler.GetServerInfo)
+		minecraftRoutes.DELETE("/stop/:instance_id", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeStop), minecraftHandler.StopServer)
+		minecraftRoutes.POST("/test", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeCreate), minecraftHandler.TestServerCreation)
+	}
+
+	// Register admin routes. Reserved to admins: API_KEY, the API keys with the admin scope, or the Supabase users listed in ADMIN_USER_IDS.
+	adminRoutes := router.Group("/admin")
+	adminRoutes.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
+	{
+		adminRoutes.GET("/cleanup", adminHandler.CleanupReport)
+		adminRoutes.POST("/cleanup", adminHandler.RunCleanup)
+		adminRoutes.POST("/api-keys", adminHandler.CreateAPIKey)
+		adminRoutes.GET("/api-keys", adminHandler.ListAPIKeys)
+		adminRoutes.DELETE("/api-keys/:id", adminHandler.RevokeAPIKey)
 	}
 
 	// Register version routes (public endpoint)
@@ -91,8 +367,22 @@ func main() {
 	port := os.Getenv("PORT")
 
 	//Information logs for the server. Error handling when the server fails.
-	log.Printf("Server starting on port %s", port)
-	if err := router.Run(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	server := &http.Server{Addr: ":" + port, Handler: router}
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// Wait for a shutdown signal, then let in-flight requests finish (event streams are cut after the timeout).
+	<-ctx.Done()
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Forced shutdown of the HTTP server: %v", err)
 	}
+	background.Wait()
+	log.Println("Server stopped")
 }
\ No newline at end of file
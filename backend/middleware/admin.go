@@ -0,0 +1,46 @@
+/*
+admin.go
+In this file you can find how admins are recognized. The operator calling with the API key is always an admin;
+Supabase users become admins when their user ID is listed in ADMIN_USER_IDS (comma separated).
+Admins may manage every Minecraft server and reach the /admin endpoints.
+*/
+
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isAdminUser reports whether a Supabase user ID is listed in ADMIN_USER_IDS.
+func isAdminUser(userID string) bool {
+	if userID == "" {
+		return false
+	}
+	for _, adminID := range strings.Split(os.Getenv("ADMIN_USER_IDS"), ",") {
+		if strings.TrimSpace(adminID) == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminMiddleware only lets admins through. It must run after AuthMiddleware, which identifies the caller.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("is_admin") {
+			log.Printf("Admin access refused for user %s from IP: %s", c.GetString("user_id"), c.ClientIP())
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   "Forbidden",
+				"message": "This endpoint is reserved to admins.",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
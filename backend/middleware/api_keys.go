@@ -0,0 +1,96 @@
+/*
+api_keys.go
+In this file you can find how the scoped API keys are checked (see services/api_key_service.go). A key acts for its
+owner: the servers it creates belong to the owner and are charged on their trial attempts, and the owner must be
+approved, like a user logged in with Supabase, admin keys included. Keys with the admin scope are admins.
+RequireScope() then restricts each route to the keys granting its scope; Supabase users and the API_KEY of the
+operator are not restricted.
+*/
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/access"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyAuthenticator returns the record of a presented API key, or access.ErrInvalidAPIKey when it must be refused.
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (*models.APIKey, error)
+}
+
+// apiKeys authenticates the scoped API keys (nil: only API_KEY is accepted), set at startup by SetAPIKeyAuthenticator().
+var apiKeys APIKeyAuthenticator
+
+// SetAPIKeyAuthenticator() => sets what authenticates the scoped API keys.
+func SetAPIKeyAuthenticator(authenticator APIKeyAuthenticator) {
+	apiKeys = authenticator
+}
+
+// authenticateAPIKey() => identifies the caller by a scoped API key. When it is refused, the error response is written and false is returned.
+func authenticateAPIKey(c *gin.Context, presented string) bool {
+	clientIP := c.ClientIP()
+	if apiKeys == nil {
+		log.Printf("Unauthorized request from IP: %s - API key presented but none is accepted", clientIP)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":   "Unauthorized",
+			"message": "Invalid API key",
+		})
+		c.Abort()
+		return false
+	}
+
+	key, err := apiKeys.Authenticate(c.Request.Context(), presented)
+	if err != nil {
+		log.Printf("Refused API key from IP: %s - Error: %v", clientIP, err)
+		status, message := http.StatusUnauthorized, "Invalid API key"
+		if !errors.Is(err, access.ErrInvalidAPIKey) {
+			status, message = http.StatusInternalServerError, "Unable to verify the API key"
+		}
+		c.JSON(status, gin.H{
+			"error":   http.StatusText(status),
+			"message": message,
+		})
+		c.Abort()
+		return false
+	}
+
+	admin := key.HasScope(models.ScopeAdmin)
+	c.Set("user_id", key.OwnerID)
+	c.Set("auth_method", "api_key")
+	c.Set("api_key_id", key.ID)
+	c.Set("api_key_scopes", key.Scopes)
+	c.Set("is_admin", admin)
+	log.Printf("Request authorized via API key %s (%s) of %s from IP: %s", key.ID, key.Name, key.OwnerID, clientIP)
+
+	// Every key goes through the checks of its owner, admin keys included: revoking the owner revokes their keys
+	return checkApproval(c, key.OwnerID)
+}
+
+// RequireScope() => only lets through the API key callers whose key grants scope. It must run after AuthMiddleware.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("auth_method") != "api_key" {
+			c.Next()
+			return
+		}
+		scopes := c.GetStringSlice("api_key_scopes")
+		if !slices.Contains(scopes, scope) && !slices.Contains(scopes, models.ScopeAdmin) {
+			log.Printf("API key %s refused on %s: missing scope %s", c.GetString("api_key_id"), c.FullPath(), scope)
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   "Forbidden",
+				"message": "This API key lacks the \"" + scope + "\" scope.",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/access"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/gin-gonic/gin"
+)
+
+// testAPIKeys authenticates the keys it holds, by their plaintext.
+type testAPIKeys map[string]models.APIKey
+
+func (k testAPIKeys) Authenticate(ctx context.Context, presented string) (*models.APIKey, error) {
+	key, ok := k[presented]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key", access.ErrInvalidAPIKey)
+	}
+	return &key, nil
+}
+
+func TestAPIKeysNeedAnApprovedOwner(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("API_KEY", "")
+	previousKeys, previousPolicy := apiKeys, accessPolicy
+	t.Cleanup(func() {
+		SetAPIKeyAuthenticator(previousKeys)
+		SetAccessPolicy(previousPolicy)
+	})
+
+	SetAccessPolicy(access.NewStaticPolicy(map[string]models.UserAccess{
+		"approved":   {UserID: "approved", Approved: true},
+		"unapproved": {UserID: "unapproved"},
+	}))
+	SetAPIKeyAuthenticator(testAPIKeys{
+		"read-approved":    {ID: "1", OwnerID: "approved", Scopes: []string{models.ScopeRead}},
+		"admin-approved":   {ID: "2", OwnerID: "approved", Scopes: []string{models.ScopeAdmin}},
+		"read-unapproved":  {ID: "3", OwnerID: "unapproved", Scopes: []string{models.ScopeRead}},
+		"admin-unapproved": {ID: "4", OwnerID: "unapproved", Scopes: []string{models.ScopeAdmin}},
+		"admin-unknown":    {ID: "5", OwnerID: "nobody", Scopes: []string{models.ScopeAdmin}},
+	})
+
+	router := gin.New()
+	router.GET("/servers", AuthMiddleware(), RequireScope(models.ScopeRead), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	cases := []struct {
+		key    string
+		status int
+	}{
+		{"read-approved", http.StatusOK},
+		{"admin-approved", http.StatusOK},
+		{"read-unapproved", http.StatusForbidden},
+		{"admin-unapproved", http.StatusForbidden},
+		{"admin-unknown", http.StatusForbidden},
+		{"forged", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		t.Run(c.key, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/servers", nil)
+			req.Header.Set("X-API-Key", c.key)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			if recorder.Code != c.status {
+				t.Fatalf("status = %d, want %d", recorder.Code, c.status)
+			}
+		})
+	}
+}
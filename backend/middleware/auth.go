@@ -8,81 +8,69 @@ and make my bank account go directly to zero because of infinite debt in AWS cre
 package middleware
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"encoding/base64"
-	"encoding/json"
-	"fmt"
+	"crypto/subtle"
+	"errors"
 	"log"
-	"math/big"
 	"net/http"
 	"os"
 	"strings"
 
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/access"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// JWK represents a JSON Web Key
-type JWK struct {
-	X      string   `json:"x"`
-	Y      string   `json:"y"`
-	Alg    string   `json:"alg"`
-	Crv    string   `json:"crv"`
-	Ext    bool     `json:"ext"`
-	Kid    string   `json:"kid"`
-	Kty    string   `json:"kty"`
-	KeyOps []string `json:"key_ops"`
-}
+// keyProvider verifies the Supabase JWTs (nil: no key configured), set at startup by SetKeyProvider().
+var keyProvider *KeyProvider
 
-// parseJWKToPublicKey converts a JWK to an ECDSA public key
-func parseJWKToPublicKey(jwkJSON string) (*ecdsa.PublicKey, error) {
-	var jwk JWK
-	if err := json.Unmarshal([]byte(jwkJSON), &jwk); err != nil {
-		return nil, fmt.Errorf("failed to parse JWK: %w", err)
-	}
+// SetKeyProvider() => sets where the keys verifying the Supabase JWTs come from.
+func SetKeyProvider(provider *KeyProvider) {
+	keyProvider = provider
+}
 
-	// Decode base64url encoded x and y coordinates
-	xBytes, err := base64.RawURLEncoding.DecodeString(jwk.X)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode x coordinate: %w", err)
-	}
+// accessPolicy decides which Supabase users are approved (nil: none is), set at startup by SetAccessPolicy().
+var accessPolicy access.AccessPolicy
 
-	yBytes, err := base64.RawURLEncoding.DecodeString(jwk.Y)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode y coordinate: %w", err)
-	}
+// SetAccessPolicy() => sets the access policy approving the Supabase users.
+func SetAccessPolicy(policy access.AccessPolicy) {
+	accessPolicy = policy
+}
 
-	// Create the public key
-	publicKey := &ecdsa.PublicKey{
-		Curve: elliptic.P256(),
-		X:     new(big.Int).SetBytes(xBytes),
-		Y:     new(big.Int).SetBytes(yBytes),
+// parseSupabaseToken() => verifies a Supabase JWT with the key its kid header names, and its claims (see claims.go).
+func parseSupabaseToken(tokenString string) (*jwt.Token, error) {
+	// The parser skips an empty issuer: refuse every token rather than tokens of any project
+	if claimRules.Issuer == "" {
+		return nil, errors.New("the issuer of the JWTs is not configured")
 	}
-
-	return publicKey, nil
+	return jwt.Parse(tokenString, keyProvider.Keyfunc, claimRules.parserOptions()...)
 }
 
 // AuthMiddleware validates requests using Supabase JWT tokens or API keys.
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get configuration from environment
-		supabaseJWK := os.Getenv("SUPABASE_JWT_PUBLIC_KEY")
 		apiKey := os.Getenv("API_KEY")
 		
 		clientIP := c.ClientIP()
 		
-		// Strategy 1: Check for API key in header
-		if apiKey != "" {
-			requestAPIKey := c.GetHeader("X-API-Key")
-			if requestAPIKey == apiKey {
+		// Strategy 1: Check for API key in header: the API_KEY of the operator, or a scoped key (see api_keys.go)
+		if requestAPIKey := c.GetHeader("X-API-Key"); requestAPIKey != "" {
+			if apiKey != "" && subtle.ConstantTimeCompare([]byte(requestAPIKey), []byte(apiKey)) == 1 {
 				log.Printf("Request authorized via API key from IP: %s", clientIP)
+				c.Set("auth_method", "api_key")
+				c.Set("api_key_scopes", models.APIKeyScopes)
+				c.Set("is_admin", true)
 				c.Next()
 				return
 			}
+			if authenticateAPIKey(c, requestAPIKey) {
+				c.Next()
+			}
+			return
 		}
 		
-		// Strategy 2: Verify Supabase JWT token with ES256
+		// Strategy 2: Verify Supabase JWT token with the signing keys of Supabase (see jwks.go)
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			log.Printf("Unauthorized request from IP: %s - No authorization provided", clientIP)
@@ -97,10 +85,9 @@ func AuthMiddleware() gin.HandlerFunc {
 		// Extract Bearer token
 		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
 		
-		// Parse JWK to public key
-		publicKey, err := parseJWKToPublicKey(supabaseJWK)
-		if err != nil {
-			log.Printf("Failed to parse JWK: %v", err)
+		// No signing key configured (see jwks.go)
+		if keyProvider == nil {
+			log.Printf("No JWT signing key configured (SUPABASE_JWKS_URL, SUPABASE_JWKS_FILE or SUPABASE_JWT_PUBLIC_KEY)")
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error":   "Configuration Error",
 				"message": "Invalid JWT signing key configuration",
@@ -109,17 +96,8 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 		
-		// Parse and validate JWT token with ES256
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate signing method is ES256
-			if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			if token.Method.Alg() != "ES256" {
-				return nil, fmt.Errorf("expected ES256 signing method, got %s", token.Method.Alg())
-			}
-			return publicKey, nil
-		})
+		// Parse and validate JWT token with the key named by its kid
+		token, err := parseSupabaseToken(tokenString)
 		
 		if err != nil {
 			log.Printf("Invalid token from IP: %s - Error: %v", clientIP, err)
@@ -152,75 +130,82 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		userID := fmt.Sprintf("%v", claims["sub"])
-		c.Set("user_id", userID)
-		c.Set("user_email", claims["email"])
-		log.Printf("JWT validated for user: %v from IP: %s", claims["email"], clientIP)
-
-		// --- Supabase DB access-control check ---
-		// Fetch the user's profile to verify approval status and remaining trial attempts.
-		profile, err := fetchUserProfile(userID)
+		// Only logged in users with a subject: no anon, service-role or anonymous tokens
+		userID, err := supabaseSubject(claims)
 		if err != nil {
-			log.Printf("Could not fetch profile for user %s: %v", userID, err)
-			c.JSON(http.StatusForbidden, gin.H{
-				"error":   "Forbidden",
-				"message": "Unable to verify user access. Please contact support.",
+			log.Printf("Refused token from IP: %s - Error: %v", clientIP, err)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":   "Unauthorized",
+				"message": "Invalid token claims",
 			})
 			c.Abort()
 			return
 		}
+		c.Set("user_id", userID)
+		c.Set("user_email", claims["email"])
+		c.Set("auth_method", "jwt")
+		c.Set("is_admin", isAdminUser(userID))
+		log.Printf("JWT validated for user: %v from IP: %s", claims["email"], clientIP)
 
-		// 1. Only approved users are allowed through.
-		if !profile.Approved {
-			log.Printf("Access denied for user %s: account not approved", userID)
-			c.JSON(http.StatusForbidden, gin.H{
-				"error":   "Forbidden",
-				"message": "Your account has not been approved yet.",
-			})
-			c.Abort()
-			return
+		if checkApproval(c, userID) {
+			c.Next()
 		}
+	}
+}
 
-		// 2. Both trial-attempt counters must be greater than zero.
-		if profile.TestServiceTrialAttempts <= 0 || profile.CustomServerTrialAttempts <= 0 {
-			log.Printf("Access denied for user %s: no trial attempts remaining (test=%d, custom=%d)",
-				userID, profile.TestServiceTrialAttempts, profile.CustomServerTrialAttempts)
-			c.JSON(http.StatusForbidden, gin.H{
-				"error":   "Forbidden",
-				"message": "You have no trial attempts remaining.",
-			})
-			c.Abort()
-			return
-		}
+/*
+checkApproval() => asks the access policy whether a user is approved (cached briefly, see the access package). When
+it is not, the 403 response is written and false is returned. Trial attempts are consumed by the server creations
+themselves, on the counter they are charged on.
+*/
+func checkApproval(c *gin.Context, userID string) bool {
+	profile, err := lookupUserAccess(c, userID)
+	if err != nil {
+		log.Printf("Could not fetch access of user %s: %v", userID, err)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "Forbidden",
+			"message": "Unable to verify user access. Please contact support.",
+		})
+		c.Abort()
+		return false
+	}
 
-		c.Next()
+	// Only approved users are allowed through.
+	if !profile.Approved {
+		log.Printf("Access denied for user %s: account not approved", userID)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "Forbidden",
+			"message": "Your account has not been approved yet.",
+		})
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// lookupUserAccess() => returns the access of a user, failing when no access policy is configured.
+func lookupUserAccess(c *gin.Context, userID string) (*models.UserAccess, error) {
+	if accessPolicy == nil {
+		return nil, errors.New("no access policy configured (ACCESS_POLICY)")
 	}
+	return accessPolicy.UserAccess(c.Request.Context(), userID)
 }
 
 // OptionalAuthMiddleware allows requests but adds user info if authenticated
 func OptionalAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		supabaseJWK := os.Getenv("SUPABASE_JWT_PUBLIC_KEY")
 		authHeader := c.GetHeader("Authorization")
 		
-		if authHeader != "" && supabaseJWK != "" {
+		if authHeader != "" && keyProvider != nil {
 			tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
 			
-			publicKey, err := parseJWKToPublicKey(supabaseJWK)
-			if err == nil {
-				token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-					if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
-						return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-					}
-					return publicKey, nil
-				})
-				
-				if err == nil && token.Valid {
-					if claims, ok := token.Claims.(jwt.MapClaims); ok {
-						c.Set("user_id", claims["sub"])
-						c.Set("user_email", claims["email"])
-						c.Set("authenticated", true)
-					}
+			token, err := parseSupabaseToken(tokenString)
+			if err == nil && token.Valid {
+				claims, ok := token.Claims.(jwt.MapClaims)
+				if userID, err := supabaseSubject(claims); ok && err == nil {
+					c.Set("user_id", userID)
+					c.Set("user_email", claims["email"])
+					c.Set("authenticated", true)
 				}
 			}
 		}
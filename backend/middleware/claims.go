@@ -0,0 +1,111 @@
+/*
+claims.go
+In this file you can find what the claims of a Supabase JWT must say, beyond a valid signature. The token must be
+issued by our Supabase project ("iss"), for logged in users ("aud"), not be expired (with some leeway for the clocks),
+and carry the "authenticated" role of a real user: the anon key, the service-role key and anonymous sign-ins are
+refused, and so are the tokens without a subject ("sub"), since the subject is who owns the servers.
+
+Configuration:
+  SUPABASE_JWT_ISSUER    – required issuer (default: <SUPABASE_URL>/auth/v1; one of them must be set)
+  SUPABASE_JWT_AUDIENCE  – required audience (default: authenticated)
+  JWT_LEEWAY             – clock skew tolerated on exp, nbf and iat (default: 30s)
+*/
+
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Role of the Supabase users logged in (the anon key has "anon", the service-role key "service_role").
+const authenticatedRole = "authenticated"
+
+const defaultJWTLeeway = 30 * time.Second
+
+// ClaimRules are the claims required on the Supabase JWTs.
+type ClaimRules struct {
+	Issuer   string        // Required "iss", always checked
+	Audience string        // Required in "aud" ("" : not checked)
+	Leeway   time.Duration // Clock skew tolerated on exp, nbf and iat
+}
+
+// claimRules are the rules applied by the middlewares, set at startup by SetClaimRules().
+var claimRules = ClaimRules{Audience: authenticatedRole, Leeway: defaultJWTLeeway}
+
+/*
+ClaimRulesFromEnv() => returns the claim rules configured by the environment. It fails when the issuer is unknown
+(neither SUPABASE_JWT_ISSUER nor SUPABASE_URL is set): tokens of any Supabase project signed with the same keys would
+get through otherwise.
+*/
+func ClaimRulesFromEnv() (ClaimRules, error) {
+	rules := ClaimRules{
+		Issuer:   os.Getenv("SUPABASE_JWT_ISSUER"),
+		Audience: os.Getenv("SUPABASE_JWT_AUDIENCE"),
+		Leeway:   defaultJWTLeeway,
+	}
+	if rules.Issuer == "" && os.Getenv("SUPABASE_URL") != "" {
+		rules.Issuer = strings.TrimSuffix(os.Getenv("SUPABASE_URL"), "/") + "/auth/v1"
+	}
+	if rules.Issuer == "" {
+		return ClaimRules{}, errors.New("the issuer of the JWTs is unknown, set SUPABASE_URL or SUPABASE_JWT_ISSUER")
+	}
+	if rules.Audience == "" {
+		rules.Audience = authenticatedRole
+	}
+	if value := os.Getenv("JWT_LEEWAY"); value != "" {
+		leeway, err := time.ParseDuration(value)
+		if err != nil || leeway < 0 {
+			return ClaimRules{}, fmt.Errorf("invalid JWT_LEEWAY %q", value)
+		}
+		rules.Leeway = leeway
+	}
+	return rules, nil
+}
+
+// SetClaimRules() => sets the claims required on the Supabase JWTs.
+func SetClaimRules(rules ClaimRules) {
+	claimRules = rules
+}
+
+// parserOptions() => returns the options of the JWT parser enforcing the rules.
+func (r ClaimRules) parserOptions() []jwt.ParserOption {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(supportedSigningMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(r.Leeway),
+		jwt.WithIssuer(r.Issuer),
+	}
+	if r.Audience != "" {
+		options = append(options, jwt.WithAudience(r.Audience))
+	}
+	return options
+}
+
+/*
+supabaseSubject() => checks that the claims belong to a logged in Supabase user, and returns its user ID (the
+subject).
+*/
+func supabaseSubject(claims jwt.MapClaims) (string, error) {
+	role, _ := claims["role"].(string)
+	if role != authenticatedRole {
+		return "", fmt.Errorf("role %q is not allowed", role)
+	}
+	if anonymous, _ := claims["is_anonymous"].(bool); anonymous {
+		return "", errors.New("anonymous users are not allowed")
+	}
+	subject, err := claims.GetSubject()
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(subject) == "" {
+		return "", errors.New("the token has no subject")
+	}
+	return subject, nil
+}
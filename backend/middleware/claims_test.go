@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testIssuer = "https://project.supabase.co/auth/v1"
+
+// newTestECKey() => generates an EC key pair on curve.
+func newTestECKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// ecJWK() => returns the public JWK of an EC key.
+func ecJWK(kid string, key *ecdsa.PrivateKey) JWK {
+	crv := key.Curve.Params().Name
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return JWK{
+		Kty: "EC",
+		Crv: crv,
+		Kid: kid,
+		X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+// signToken() => signs claims with key, under the kid header.
+func signToken(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// userClaims() => returns the claims of a token Supabase issues to a logged in user.
+func userClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":  testIssuer,
+		"aud":  "authenticated",
+		"sub":  "6f1c0b8e-0000-4000-8000-000000000001",
+		"role": "authenticated",
+		"iat":  now.Unix(),
+		"exp":  now.Add(time.Hour).Unix(),
+	}
+}
+
+// useKeyProvider() => makes the middlewares verify tokens with provider and rules, for the duration of the test.
+func useKeyProvider(t *testing.T, provider *KeyProvider, rules ClaimRules) {
+	t.Helper()
+	previousProvider, previousRules := keyProvider, claimRules
+	t.Cleanup(func() {
+		SetKeyProvider(previousProvider)
+		SetClaimRules(previousRules)
+	})
+	SetKeyProvider(provider)
+	SetClaimRules(rules)
+}
+
+func TestClaimRulesFromEnv(t *testing.T) {
+	cases := []struct {
+		name    string
+		env     map[string]string
+		want    ClaimRules
+		wantErr bool
+	}{
+		{
+			name: "issuer of the project",
+			env:  map[string]string{"SUPABASE_URL": "https://project.supabase.co/"},
+			want: ClaimRules{Issuer: testIssuer, Audience: "authenticated", Leeway: defaultJWTLeeway},
+		},
+		{
+			name: "explicit issuer, audience and leeway",
+			env: map[string]string{"SUPABASE_URL": "https://project.supabase.co", "SUPABASE_JWT_ISSUER": "https://auth.example.com",
+				"SUPABASE_JWT_AUDIENCE": "api", "JWT_LEEWAY": "5s"},
+			want: ClaimRules{Issuer: "https://auth.example.com", Audience: "api", Leeway: 5 * time.Second},
+		},
+		{
+			name: "issuer without SUPABASE_URL",
+			env:  map[string]string{"SUPABASE_JWT_ISSUER": testIssuer},
+			want: ClaimRules{Issuer: testIssuer, Audience: "authenticated", Leeway: defaultJWTLeeway},
+		},
+		{name: "no issuer", env: nil, wantErr: true},
+		{name: "invalid leeway", env: map[string]string{"SUPABASE_URL": "https://project.supabase.co", "JWT_LEEWAY": "-1s"}, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, name := range []string{"SUPABASE_URL", "SUPABASE_JWT_ISSUER", "SUPABASE_JWT_AUDIENCE", "JWT_LEEWAY"} {
+				t.Setenv(name, c.env[name])
+			}
+			rules, err := ClaimRulesFromEnv()
+			if (err != nil) != c.wantErr {
+				t.Fatalf("ClaimRulesFromEnv() error = %v, want error %t", err, c.wantErr)
+			}
+			if rules != c.want {
+				t.Fatalf("ClaimRulesFromEnv() = %+v, want %+v", rules, c.want)
+			}
+		})
+	}
+}
+
+func TestSupabaseTokenClaims(t *testing.T) {
+	key := newTestECKey(t, elliptic.P256())
+	jwk, err := json.Marshal(ecJWK("key-1", key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewStaticKeyProvider(string(jwk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeyProvider(t, provider, ClaimRules{Issuer: testIssuer, Audience: "authenticated", Leeway: 30 * time.Second})
+
+	now := time.Now()
+	cases := []struct {
+		name   string
+		change func(jwt.MapClaims)
+		valid  bool
+	}{
+		{"logged in user", func(jwt.MapClaims) {}, true},
+		{"audience list", func(c jwt.MapClaims) { c["aud"] = []string{"other", "authenticated"} }, true},
+		{"expired within the leeway", func(c jwt.MapClaims) { c["exp"] = now.Add(-10 * time.Second).Unix() }, true},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() }, false},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }, false},
+		{"issued in the future", func(c jwt.MapClaims) { c["iat"] = now.Add(time.Hour).Unix() }, false},
+		{"other project", func(c jwt.MapClaims) { c["iss"] = "https://other.supabase.co/auth/v1" }, false},
+		{"no issuer", func(c jwt.MapClaims) { delete(c, "iss") }, false},
+		{"other audience", func(c jwt.MapClaims) { c["aud"] = "service" }, false},
+		{"anon key", func(c jwt.MapClaims) { c["role"] = "anon" }, false},
+		{"service-role key", func(c jwt.MapClaims) { c["role"] = "service_role" }, false},
+		{"anonymous sign-in", func(c jwt.MapClaims) { c["is_anonymous"] = true }, false},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }, false},
+		{"blank subject", func(c jwt.MapClaims) { c["sub"] = " " }, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			claims := userClaims()
+			c.change(claims)
+			token, err := parseSupabaseToken(signToken(t, jwt.SigningMethodES256, key, "key-1", claims))
+			if err == nil {
+				_, err = supabaseSubject(token.Claims.(jwt.MapClaims))
+			}
+			if (err == nil) != c.valid {
+				t.Fatalf("token accepted = %t, want %t (error: %v)", err == nil, c.valid, err)
+			}
+		})
+	}
+
+	t.Run("signed by another key", func(t *testing.T) {
+		other := newTestECKey(t, elliptic.P256())
+		if _, err := parseSupabaseToken(signToken(t, jwt.SigningMethodES256, other, "key-1", userClaims())); err == nil {
+			t.Fatal("a token signed by another key was accepted")
+		}
+	})
+	t.Run("HMAC", func(t *testing.T) {
+		if _, err := parseSupabaseToken(signToken(t, jwt.SigningMethodHS256, []byte("secret"), "key-1", userClaims())); err == nil {
+			t.Fatal("an HS256 token was accepted")
+		}
+	})
+}
+
+// TestZeroClaimRulesCheckTheIssuer checks that rules without an issuer refuse every token, instead of skipping the check.
+func TestZeroClaimRulesCheckTheIssuer(t *testing.T) {
+	key := newTestECKey(t, elliptic.P256())
+	jwk, _ := json.Marshal(ecJWK("key-1", key))
+	provider, err := NewStaticKeyProvider(string(jwk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeyProvider(t, provider, ClaimRules{Audience: "authenticated"})
+
+	if _, err := parseSupabaseToken(signToken(t, jwt.SigningMethodES256, key, "key-1", userClaims())); err == nil {
+		t.Fatal("a token was accepted without an issuer to check")
+	}
+}
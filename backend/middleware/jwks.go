@@ -0,0 +1,389 @@
+/*
+jwks.go
+In this file you can find where the keys verifying the Supabase JWTs come from. The KeyProvider loads a JWKS document
+(the JSON Web Key Set Supabase publishes at <SUPABASE_URL>/auth/v1/.well-known/jwks.json) from a URL or a file, keeps
+it cached, and picks the key of each token by its "kid" header. When Supabase rotates its signing keys, tokens signed
+with a key the cache does not know yet make it reload the set, at most once every JWKS_MIN_REFRESH_INTERVAL, so a
+flood of forged kids cannot hammer the JWKS endpoint. EC keys on P-256 (ES256) and P-384 (ES384) and RSA keys
+(RS256, RS384, RS512) are supported.
+
+Configuration (first one set wins):
+  SUPABASE_JWKS_URL        – URL of the JWKS document
+  SUPABASE_JWKS_FILE       – path of a JWKS document on disk
+  SUPABASE_JWT_PUBLIC_KEY  – a single JWK (the former configuration, no rotation)
+*/
+
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing methods accepted on the Supabase JWTs.
+var supportedSigningMethods = []string{"ES256", "ES384", "RS256", "RS384", "RS512"}
+
+const (
+	defaultJWKSCacheTTL           = 10 * time.Minute // The set is reloaded on the first lookup after this long
+	defaultJWKSMinRefreshInterval = 30 * time.Second // Unknown kids reload the set at most this often
+	jwksFetchTimeout              = 10 * time.Second
+	maxJWKSSize                   = 1 << 20
+)
+
+// JWK represents a JSON Web Key
+type JWK struct {
+	X      string   `json:"x"`
+	Y      string   `json:"y"`
+	N      string   `json:"n"`
+	E      string   `json:"e"`
+	Alg    string   `json:"alg"`
+	Crv    string   `json:"crv"`
+	Ext    bool     `json:"ext"`
+	Kid    string   `json:"kid"`
+	Kty    string   `json:"kty"`
+	Use    string   `json:"use"`
+	KeyOps []string `json:"key_ops"`
+}
+
+// JWKS represents a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// verificationKey is a public key of the set, along with the signing method it verifies.
+type verificationKey struct {
+	key any    // *ecdsa.PublicKey or *rsa.PublicKey
+	alg string // Signing method required by the key ("" for any method of its type)
+}
+
+// KeyProvider hands out the public keys verifying the Supabase JWTs, by kid.
+type KeyProvider struct {
+	load               func() ([]byte, error) // Reads the JWKS document (nil for a static key)
+	source             string                 // Where the set comes from, for the logs
+	cacheTTL           time.Duration
+	minRefreshInterval time.Duration
+
+	refreshMu   sync.Mutex // Held while the set is reloaded, so a single reload runs at a time
+	mu          sync.Mutex // Guards the fields below; never held while the set is fetched
+	keys        map[string]verificationKey
+	loadedAt    time.Time // When the set was last loaded successfully
+	attemptedAt time.Time // When the set was last loaded, successfully or not
+}
+
+/*
+NewKeyProviderFromEnv() => creates the key provider configured by the environment, and loads its keys once so that a
+bad configuration shows up at startup. It returns nil when no key source is configured.
+*/
+func NewKeyProviderFromEnv() (*KeyProvider, error) {
+	var provider *KeyProvider
+	switch {
+	case os.Getenv("SUPABASE_JWKS_URL") != "":
+		provider = NewJWKSURLProvider(os.Getenv("SUPABASE_JWKS_URL"))
+	case os.Getenv("SUPABASE_JWKS_FILE") != "":
+		provider = NewJWKSFileProvider(os.Getenv("SUPABASE_JWKS_FILE"))
+	case os.Getenv("SUPABASE_JWT_PUBLIC_KEY") != "":
+		return NewStaticKeyProvider(os.Getenv("SUPABASE_JWT_PUBLIC_KEY"))
+	default:
+		return nil, nil
+	}
+
+	if value := os.Getenv("JWKS_CACHE_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid JWKS_CACHE_TTL %q", value)
+		}
+		provider.cacheTTL = ttl
+	}
+	if value := os.Getenv("JWKS_MIN_REFRESH_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("invalid JWKS_MIN_REFRESH_INTERVAL %q", value)
+		}
+		provider.minRefreshInterval = interval
+	}
+
+	// The set is reloaded on demand: a failure here does not keep the backend from starting
+	if err := provider.refresh(); err != nil {
+		log.Printf("Warning: could not load the JWT signing keys from %s: %v", provider.source, err)
+	}
+	return provider, nil
+}
+
+// NewJWKSURLProvider() => creates a key provider loading the JWKS document served at url.
+func NewJWKSURLProvider(url string) *KeyProvider {
+	client := &http.Client{Timeout: jwksFetchTimeout}
+	return newKeyProvider(url, func() ([]byte, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	})
+}
+
+// NewJWKSFileProvider() => creates a key provider loading the JWKS document stored at path.
+func NewJWKSFileProvider(path string) *KeyProvider {
+	return newKeyProvider(path, func() ([]byte, error) {
+		return os.ReadFile(path)
+	})
+}
+
+// NewStaticKeyProvider() => creates a key provider holding the single JWK jwkJSON, which verifies every kid.
+func NewStaticKeyProvider(jwkJSON string) (*KeyProvider, error) {
+	var jwk JWK
+	if err := json.Unmarshal([]byte(jwkJSON), &jwk); err != nil {
+		return nil, fmt.Errorf("failed to parse JWK: %w", err)
+	}
+	key, err := parseJWK(jwk)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyProvider{source: "SUPABASE_JWT_PUBLIC_KEY", keys: map[string]verificationKey{"": key}}, nil
+}
+
+func newKeyProvider(source string, load func() ([]byte, error)) *KeyProvider {
+	return &KeyProvider{
+		load:               load,
+		source:             source,
+		cacheTTL:           defaultJWKSCacheTTL,
+		minRefreshInterval: defaultJWKSMinRefreshInterval,
+		keys:               make(map[string]verificationKey),
+	}
+}
+
+// Source() => tells where the keys come from.
+func (p *KeyProvider) Source() string {
+	return p.source
+}
+
+/*
+Keyfunc() => returns the key verifying a token (a jwt.Keyfunc). The key is picked by the kid header of the token, and
+must be meant for the signing method of the token.
+*/
+func (p *KeyProvider) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := p.lookup(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	alg := token.Method.Alg()
+	if key.alg != "" && key.alg != alg {
+		return nil, fmt.Errorf("key %q is for %s, the token is signed with %s", kid, key.alg, alg)
+	}
+	switch key.key.(type) {
+	case *ecdsa.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
+			return nil, fmt.Errorf("key %q is an EC key, the token is signed with %s", kid, alg)
+		}
+	case *rsa.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("key %q is an RSA key, the token is signed with %s", kid, alg)
+		}
+	}
+	return key.key, nil
+}
+
+// lookup() => returns the key of a kid, reloading the set when it is stale or does not know the kid.
+func (p *KeyProvider) lookup(kid string) (verificationKey, error) {
+	if p.load == nil {
+		// A single configured key verifies every token
+		return p.keys[""], nil
+	}
+
+	p.mu.Lock()
+	stale := time.Since(p.loadedAt) > p.cacheTTL
+	p.mu.Unlock()
+	if stale {
+		if err := p.refresh(); err != nil {
+			// Keep using the keys loaded before
+			log.Printf("Warning: could not reload the JWT signing keys from %s: %v", p.source, err)
+		}
+	}
+	if key, ok := p.key(kid); ok {
+		return key, nil
+	}
+
+	// A kid the set does not know: the keys may have been rotated
+	if err := p.refresh(); err != nil {
+		return verificationKey{}, fmt.Errorf("unknown signing key %q (reloading the keys failed: %v)", kid, err)
+	}
+	if key, ok := p.key(kid); ok {
+		return key, nil
+	}
+	return verificationKey{}, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// key() => returns the key of a kid in the current set.
+func (p *KeyProvider) key(kid string) (verificationKey, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+/*
+refresh() => reloads the set, unless it was last attempted less than minRefreshInterval ago. A single reload runs at
+a time and the set is fetched without holding p.mu, so the lookups of known kids go on meanwhile; the callers waiting
+for a reload find it just attempted once it is done, and use its keys.
+*/
+func (p *KeyProvider) refresh() error {
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+
+	p.mu.Lock()
+	attemptedAt := time.Now()
+	if attemptedAt.Sub(p.attemptedAt) < p.minRefreshInterval {
+		p.mu.Unlock()
+		return nil
+	}
+	p.attemptedAt = attemptedAt
+	p.mu.Unlock()
+
+	keys, err := p.fetch()
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.loadedAt = attemptedAt
+	p.mu.Unlock()
+	log.Printf("Loaded %d JWT signing keys from %s", len(keys), p.source)
+	return nil
+}
+
+// fetch() => loads and parses the set.
+func (p *KeyProvider) fetch() (map[string]verificationKey, error) {
+	document, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	var set JWKS
+	if err := json.Unmarshal(document, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]verificationKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(jwk)
+		if err != nil {
+			// One unsupported key does not invalidate the others
+			log.Printf("Warning: skipping JWT signing key %q from %s: %v", jwk.Kid, p.source, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("the JWKS holds no usable signing key")
+	}
+	return keys, nil
+}
+
+// parseJWK() => converts a JWK to the public key it describes.
+func parseJWK(jwk JWK) (verificationKey, error) {
+	switch jwk.Kty {
+	case "EC":
+		return parseECJWK(jwk)
+	case "RSA":
+		return parseRSAJWK(jwk)
+	default:
+		return verificationKey{}, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+// parseECJWK converts an EC JWK (P-256 or P-384) to an ECDSA public key
+func parseECJWK(jwk JWK) (verificationKey, error) {
+	var curve elliptic.Curve
+	var alg string
+	switch jwk.Crv {
+	case "P-256":
+		curve, alg = elliptic.P256(), "ES256"
+	case "P-384":
+		curve, alg = elliptic.P384(), "ES384"
+	default:
+		return verificationKey{}, fmt.Errorf("unsupported curve %q", jwk.Crv)
+	}
+	if jwk.Alg != "" && jwk.Alg != alg {
+		return verificationKey{}, fmt.Errorf("algorithm %s does not match curve %s", jwk.Alg, jwk.Crv)
+	}
+
+	// Decode base64url encoded x and y coordinates
+	xBytes, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return verificationKey{}, fmt.Errorf("failed to decode x coordinate: %w", err)
+	}
+	yBytes, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		return verificationKey{}, fmt.Errorf("failed to decode y coordinate: %w", err)
+	}
+
+	// Uncompressed point (0x04 || x || y), which the parser checks is on the curve
+	size := (curve.Params().BitSize + 7) / 8
+	if len(xBytes) > size || len(yBytes) > size {
+		return verificationKey{}, errors.New("coordinates are too long for the curve")
+	}
+	point := make([]byte, 1+2*size)
+	point[0] = 4
+	copy(point[1+size-len(xBytes):1+size], xBytes)
+	copy(point[1+2*size-len(yBytes):], yBytes)
+	publicKey, err := ecdsa.ParseUncompressedPublicKey(curve, point)
+	if err != nil {
+		return verificationKey{}, fmt.Errorf("invalid EC public key: %w", err)
+	}
+	return verificationKey{key: publicKey, alg: alg}, nil
+}
+
+// parseRSAJWK converts an RSA JWK to an RSA public key
+func parseRSAJWK(jwk JWK) (verificationKey, error) {
+	switch jwk.Alg {
+	case "", "RS256", "RS384", "RS512":
+	default:
+		return verificationKey{}, fmt.Errorf("unsupported RSA algorithm %q", jwk.Alg)
+	}
+
+	nBytes, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return verificationKey{}, fmt.Errorf("failed to decode modulus: %w", err)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return verificationKey{}, fmt.Errorf("failed to decode exponent: %w", err)
+	}
+	if len(eBytes) == 0 || len(eBytes) > 4 {
+		return verificationKey{}, errors.New("invalid exponent")
+	}
+	exponent := 0
+	for _, b := range eBytes {
+		exponent = exponent<<8 | int(b)
+	}
+
+	publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(nBytes), E: exponent}
+	if publicKey.N.BitLen() < 2048 {
+		return verificationKey{}, fmt.Errorf("RSA key of %d bits is too short", publicKey.N.BitLen())
+	}
+	if exponent < 3 || exponent%2 == 0 {
+		return verificationKey{}, errors.New("invalid exponent")
+	}
+	return verificationKey{key: publicKey, alg: jwk.Alg}, nil
+}
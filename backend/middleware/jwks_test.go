@@ -0,0 +1,275 @@
+package middleware
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testJWKSServer serves a JWKS document the test can change, and counts how often it is fetched.
+type testJWKSServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	set     JWKS
+	fetches atomic.Int32
+	block   chan struct{} // When set, each fetch waits for it to be closed
+}
+
+// newTestJWKSServer() => starts a server of the keys. It is closed with the test.
+func newTestJWKSServer(t *testing.T, keys ...JWK) *testJWKSServer {
+	t.Helper()
+	server := &testJWKSServer{set: JWKS{Keys: keys}}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.fetches.Add(1)
+		server.mu.Lock()
+		set, block := server.set, server.block
+		server.mu.Unlock()
+		if block != nil {
+			<-block
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// setKeys() => replaces the keys served, like a rotation.
+func (s *testJWKSServer) setKeys(keys ...JWK) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set = JWKS{Keys: keys}
+}
+
+// newTestProvider() => returns a provider of the keys of server, loaded once.
+func newTestProvider(t *testing.T, server *testJWKSServer, minRefreshInterval time.Duration) *KeyProvider {
+	t.Helper()
+	provider := NewJWKSURLProvider(server.URL)
+	provider.minRefreshInterval = minRefreshInterval
+	if err := provider.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// newTestRSAKey() => generates an RSA key pair of bits.
+func newTestRSAKey(t *testing.T, bits int) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// rsaJWK() => returns the public JWK of an RSA key.
+func rsaJWK(kid, alg string, key *rsa.PrivateKey) JWK {
+	return JWK{
+		Kty: "RSA",
+		Kid: kid,
+		Alg: alg,
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// verify() => parses a token with the keys of provider, checking its signature only.
+func verify(provider *KeyProvider, token string) error {
+	_, err := jwt.Parse(token, provider.Keyfunc, jwt.WithValidMethods(supportedSigningMethods))
+	return err
+}
+
+func TestKeyProviderPicksTheKeyOfTheKid(t *testing.T) {
+	first, second := newTestECKey(t, elliptic.P256()), newTestECKey(t, elliptic.P256())
+	provider := newTestProvider(t, newTestJWKSServer(t, ecJWK("first", first), ecJWK("second", second)), time.Hour)
+
+	cases := []struct {
+		name  string
+		key   any
+		kid   string
+		valid bool
+	}{
+		{"first key", first, "first", true},
+		{"second key", second, "second", true},
+		{"kid of another key", second, "first", false},
+		{"unknown kid", first, "third", false},
+		{"no kid", first, "", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := verify(provider, signToken(t, jwt.SigningMethodES256, c.key, c.kid, userClaims()))
+			if (err == nil) != c.valid {
+				t.Fatalf("token accepted = %t, want %t (error: %v)", err == nil, c.valid, err)
+			}
+		})
+	}
+}
+
+func TestKeyProviderAlgorithms(t *testing.T) {
+	p256, p384 := newTestECKey(t, elliptic.P256()), newTestECKey(t, elliptic.P384())
+	rsaKey := newTestRSAKey(t, 2048)
+	provider := newTestProvider(t, newTestJWKSServer(t,
+		ecJWK("p256", p256), ecJWK("p384", p384), rsaJWK("rsa", "", rsaKey), rsaJWK("rs512", "RS512", rsaKey)), time.Hour)
+
+	cases := []struct {
+		name   string
+		method jwt.SigningMethod
+		key    any
+		kid    string
+		valid  bool
+	}{
+		{"ES256 on P-256", jwt.SigningMethodES256, p256, "p256", true},
+		{"ES384 on P-384", jwt.SigningMethodES384, p384, "p384", true},
+		{"RS256", jwt.SigningMethodRS256, rsaKey, "rsa", true},
+		{"RS384 on a key without alg", jwt.SigningMethodRS384, rsaKey, "rsa", true},
+		{"RS512 on an RS512 key", jwt.SigningMethodRS512, rsaKey, "rs512", true},
+		{"RS256 on an RS512 key", jwt.SigningMethodRS256, rsaKey, "rs512", false},
+		{"ES384 on a P-256 key", jwt.SigningMethodES384, p384, "p256", false},
+		{"RS256 on an EC key", jwt.SigningMethodRS256, rsaKey, "p256", false},
+		{"ES256 on an RSA key", jwt.SigningMethodES256, p256, "rsa", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := verify(provider, signToken(t, c.method, c.key, c.kid, userClaims()))
+			if (err == nil) != c.valid {
+				t.Fatalf("token accepted = %t, want %t (error: %v)", err == nil, c.valid, err)
+			}
+		})
+	}
+}
+
+func TestParseJWKRefusesWeakOrMismatchedKeys(t *testing.T) {
+	p256 := ecJWK("p256", newTestECKey(t, elliptic.P256()))
+	mismatched := p256
+	mismatched.Alg = "ES384"
+	offCurve := p256
+	offCurve.X, offCurve.Y = offCurve.Y, offCurve.X
+	p521 := ecJWK("p521", newTestECKey(t, elliptic.P521()))
+
+	for name, jwk := range map[string]JWK{
+		"alg of another curve":   mismatched,
+		"point off the curve":    offCurve,
+		"unsupported curve":      p521,
+		"short RSA key":          rsaJWK("short", "", newTestRSAKey(t, 1024)),
+		"unsupported key type":   {Kty: "oct", Kid: "hmac"},
+		"unsupported RSA method": rsaJWK("ps256", "PS256", newTestRSAKey(t, 2048)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseJWK(jwk); err == nil {
+				t.Fatal("parseJWK() accepted the key")
+			}
+		})
+	}
+}
+
+// TestKeyProviderRotation checks that a token signed with a new key reloads the set.
+func TestKeyProviderRotation(t *testing.T) {
+	old, rotated := newTestECKey(t, elliptic.P256()), newTestECKey(t, elliptic.P256())
+	server := newTestJWKSServer(t, ecJWK("old", old))
+	provider := newTestProvider(t, server, 0)
+
+	server.setKeys(ecJWK("old", old), ecJWK("new", rotated))
+	if err := verify(provider, signToken(t, jwt.SigningMethodES256, rotated, "new", userClaims())); err != nil {
+		t.Fatalf("token of the rotated key refused: %v", err)
+	}
+	if got := server.fetches.Load(); got != 2 {
+		t.Fatalf("%d fetches, want 2 (the first load and the rotation)", got)
+	}
+}
+
+// TestKeyProviderRefreshRateLimit checks that unknown kids reload the set at most once every minRefreshInterval.
+func TestKeyProviderRefreshRateLimit(t *testing.T) {
+	key := newTestECKey(t, elliptic.P256())
+	server := newTestJWKSServer(t, ecJWK("known", key))
+	provider := newTestProvider(t, server, time.Hour)
+
+	for i := 0; i < 20; i++ {
+		if err := verify(provider, signToken(t, jwt.SigningMethodES256, key, "forged", userClaims())); err == nil {
+			t.Fatal("token of an unknown kid accepted")
+		}
+	}
+	if got := server.fetches.Load(); got != 1 {
+		t.Fatalf("%d fetches, want 1: unknown kids must not reload the set within the minimum interval", got)
+	}
+
+	// Once the interval is over, the next unknown kid reloads it again
+	provider.mu.Lock()
+	provider.attemptedAt = time.Now().Add(-2 * time.Hour)
+	provider.mu.Unlock()
+	verify(provider, signToken(t, jwt.SigningMethodES256, key, "forged", userClaims()))
+	if got := server.fetches.Load(); got != 2 {
+		t.Fatalf("%d fetches, want 2 once the interval is over", got)
+	}
+
+	// And so does a stale set, with the known keys still used when the reload fails
+	provider.mu.Lock()
+	provider.loadedAt = time.Now().Add(-2 * time.Hour)
+	provider.attemptedAt = provider.loadedAt
+	provider.mu.Unlock()
+	server.setKeys()
+	if err := verify(provider, signToken(t, jwt.SigningMethodES256, key, "known", userClaims())); err != nil {
+		t.Fatalf("known key refused after a failed reload: %v", err)
+	}
+	if got := server.fetches.Load(); got != 3 {
+		t.Fatalf("%d fetches, want 3 once the set is stale", got)
+	}
+}
+
+// TestKeyProviderFetchesWithoutTheLock checks that a slow JWKS endpoint does not hold up the tokens of known kids, and
+// that the lookups waiting for a reload share it.
+func TestKeyProviderFetchesWithoutTheLock(t *testing.T) {
+	key := newTestECKey(t, elliptic.P256())
+	server := newTestJWKSServer(t, ecJWK("known", key))
+	provider := newTestProvider(t, server, time.Hour)
+	provider.mu.Lock()
+	provider.attemptedAt = time.Now().Add(-2 * time.Hour)
+	provider.mu.Unlock()
+
+	block := make(chan struct{})
+	server.mu.Lock()
+	server.block = block
+	server.mu.Unlock()
+
+	// Unknown kids: the first one reloads the set (and blocks on the endpoint), the others wait for that reload
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			verify(provider, signToken(t, jwt.SigningMethodES256, key, "unknown", userClaims()))
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for server.fetches.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- verify(provider, signToken(t, jwt.SigningMethodES256, key, "known", userClaims()))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("known key refused during a reload: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the lookup of a known kid waited for the JWKS fetch")
+	}
+
+	close(block)
+	wg.Wait()
+	if got := server.fetches.Load(); got != 2 {
+		t.Fatalf("%d fetches, want 2: the lookups waiting for a reload must share it", got)
+	}
+}
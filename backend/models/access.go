@@ -0,0 +1,25 @@
+package models
+
+/*
+The definition of models for the access control of the users: whether they may use the backend, and how many servers
+they may still create on each trial.
+*/
+
+// UserAccess is what an access policy knows about a user
+type UserAccess struct {
+	UserID                    string `json:"id" yaml:"id"`
+	Approved                  bool   `json:"approved" yaml:"approved"`                                         // Only approved users get through the auth middleware
+	TestServiceTrialAttempts  int    `json:"test_service_trial_attempts" yaml:"test_service_trial_attempts"`   // Servers left on the "test" trial
+	CustomServerTrialAttempts int    `json:"custom_server_trial_attempts" yaml:"custom_server_trial_attempts"` // Servers left on the "custom" trial
+}
+
+// TrialAttempts() => returns the attempts left on a trial (TrialTest or TrialCustom), and whether the trial exists.
+func (a *UserAccess) TrialAttempts(trial string) (int, bool) {
+	switch trial {
+	case TrialTest:
+		return a.TestServiceTrialAttempts, true
+	case TrialCustom:
+		return a.CustomServerTrialAttempts, true
+	}
+	return 0, false
+}
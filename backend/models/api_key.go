@@ -0,0 +1,60 @@
+package models
+
+/*
+The definition of models for the API keys: the credentials of the clients calling the backend without a Supabase
+session (scripts, bots, CI). Each key acts for an owner and only reaches the endpoints its scopes allow.
+*/
+
+// Scopes of the API keys
+const (
+	ScopeCreate = "create" // Create servers (and upload worlds)
+	ScopeRead   = "read"   // Read servers, jobs, events and backups
+	ScopeStop   = "stop"   // Stop, start, terminate servers and run their commands and backups
+	ScopeAdmin  = "admin"  // Everything, on every server, and the /admin endpoints
+)
+
+// APIKeyScopes lists every scope, e.g. for the API_KEY environment variable, which has them all
+var APIKeyScopes = []string{ScopeCreate, ScopeRead, ScopeStop, ScopeAdmin}
+
+// APIKey is what the backend knows about an API key. The key itself is only stored hashed.
+type APIKey struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`     // What the key is for, e.g. "discord bot"
+	OwnerID    string   `json:"owner_id"` // User the key acts for: it owns the servers the key creates
+	Scopes     []string `json:"scopes"`
+	CreatedBy  string   `json:"created_by"` // Admin who created the key
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at,omitempty"` // Empty: the key does not expire
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	RevokedAt  string   `json:"revoked_at,omitempty"`
+}
+
+// HasScope() => reports whether the key grants a scope. The admin scope grants them all.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, granted := range k.Scopes {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKeyRequest is the body of POST /admin/api-keys
+type CreateAPIKeyRequest struct {
+	Name      string   `json:"name" binding:"required"`
+	OwnerID   string   `json:"owner_id" binding:"required"`
+	Scopes    []string `json:"scopes" binding:"required"`
+	ExpiresIn string   `json:"expires_in"` // Lifetime of the key as a Go duration (e.g. "720h"), empty for no expiry
+}
+
+// CreateAPIKeyResponse is the created key. Key is only ever returned here: the backend keeps its hash.
+type CreateAPIKeyResponse struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"api_key"`
+}
+
+// APIKeyListResponse represents the response of GET /admin/api-keys
+type APIKeyListResponse struct {
+	APIKeys []APIKey `json:"api_keys"`
+	Count   int      `json:"count"`
+}
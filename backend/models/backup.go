@@ -0,0 +1,30 @@
+package models
+
+/*
+The definition of models for the world backups listed and requested through /minecraft/servers/:id/backups.
+*/
+
+// BackupStatus tells where a backup stands.
+type BackupStatus string
+
+const (
+	BackupRequested BackupStatus = "requested" // Requested through the API, the server has not taken it yet
+	BackupCompleted BackupStatus = "completed" // Archived and uploaded to the backup bucket
+	BackupFailed    BackupStatus = "failed"    // The server could not take or upload it (see /var/log/minecraft-backup.log)
+)
+
+// Backup is a snapshot of the world of a Minecraft server (an archive of /opt/minecraft-data)
+type Backup struct {
+	BackupID  string       `json:"backup_id"` // <UTC timestamp>-<kind>-<random suffix>, e.g. 20260115T180000Z-scheduled-3f9a1c2e
+	Kind      string       `json:"kind"`      // "scheduled", "manual" or "shutdown" (taken when the instance stops or is terminated)
+	Status    BackupStatus `json:"status"`
+	SizeBytes int64        `json:"size_bytes,omitempty"`
+	CreatedAt string       `json:"created_at"`
+}
+
+// BackupListResponse represents the response of GET /minecraft/servers/:id/backups
+type BackupListResponse struct {
+	InstanceID string   `json:"instance_id"`
+	Backups    []Backup `json:"backups"` // Newest first
+	Count      int      `json:"count"`
+}
@@ -0,0 +1,23 @@
+package models
+
+/*
+The definition of models for the lifecycle callbacks the servers send to POST /minecraft/callbacks.
+*/
+
+// Events a server reports through a callback
+const (
+	CallbackSetupStarted     = "setup_started"     // ec2-init.sh started
+	CallbackDockerPulled     = "docker_pulled"     // The Minecraft image is pulled
+	CallbackContainerStarted = "container_started" // The Minecraft container is running
+	CallbackReady            = "ready"             // Minecraft finished loading and accepts players
+	CallbackIdleCountdown    = "idle_countdown"    // Nobody plays anymore: the idle action runs unless someone joins
+	CallbackTerminating      = "terminating"       // The idle action (stop, terminate, hibernate) is being applied
+)
+
+// InstanceCallback is the body of a callback, signed by the server with its callback token
+type InstanceCallback struct {
+	InstanceID string `json:"instance_id"`
+	Event      string `json:"event"`             // One of the Callback* events
+	Message    string `json:"message,omitempty"` // Human readable details
+	SentAt     int64  `json:"sent_at"`           // Unix time, same as the X-Callback-Timestamp header
+}
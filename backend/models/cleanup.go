@@ -0,0 +1,39 @@
+package models
+
+/*
+The definition of models for the garbage collector report returned by GET and POST /admin/cleanup.
+*/
+
+// CleanupStatus tells what happened to a resource the garbage collector selected.
+type CleanupStatus string
+
+const (
+	CleanupPlanned CleanupStatus = "planned" // Dry run: the action would be taken
+	CleanupDone    CleanupStatus = "done"    // The action was taken
+	CleanupFailed  CleanupStatus = "failed"  // The action failed, see Error
+)
+
+// CleanupAction is a resource the garbage collector found orphaned, and what it does about it
+type CleanupAction struct {
+	ResourceType string        `json:"resource_type"` // "instance", "volume" or "security_group"
+	ResourceID   string        `json:"resource_id"`
+	Name         string        `json:"name,omitempty"`
+	OwnerID      string        `json:"owner_id,omitempty"`
+	State        string        `json:"state,omitempty"` // EC2 state of an instance or a volume
+	Action       string        `json:"action"`          // "terminate" or "delete"
+	Reason       string        `json:"reason"`
+	Status       CleanupStatus `json:"status"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// CleanupReport is the result of a garbage collection pass
+type CleanupReport struct {
+	DryRun               bool            `json:"dry_run"`
+	MaxServerLifetime    string          `json:"max_server_lifetime,omitempty"`    // Empty when running servers never expire
+	MaxStoppedLifetime   string          `json:"max_stopped_lifetime,omitempty"`   // Empty when stopped servers never expire
+	WorldVolumeRetention string          `json:"world_volume_retention,omitempty"` // Empty when world volumes are kept for good
+	StartedAt            string          `json:"started_at"`
+	FinishedAt           string          `json:"finished_at"`
+	Actions              []CleanupAction `json:"actions"`
+	Count                int             `json:"count"`
+}
@@ -0,0 +1,32 @@
+package models
+
+/*
+The definition of models for the server lifecycle events streamed by GET /minecraft/servers/:id/events (Server-Sent Events).
+*/
+
+// ServerEventType is the kind of a server event. It is also used as the SSE "event:" field.
+type ServerEventType string
+
+const (
+	EventPhase             ServerEventType = "phase"               // The provisioning job moved to a new phase
+	EventEC2State          ServerEventType = "ec2_state"           // The EC2 instance changed state (pending, running, stopped...)
+	EventCloudInit         ServerEventType = "cloud_init"          // ec2-init.sh reached a setup stage
+	EventDockerPull        ServerEventType = "docker_pull"         // The Minecraft Docker image pull started / finished
+	EventMinecraftDone     ServerEventType = "minecraft_done"      // Minecraft printed its "Done" line and accepts players
+	EventFailed            ServerEventType = "failed"              // Provisioning failed, see Message
+	EventUnowned           ServerEventType = "unowned"             // The reconciler found a generator instance nobody owns
+	EventIdleMonitorSilent ServerEventType = "idle_monitor_silent" // The idle monitor of a running instance never reported in: nothing will stop it
+	EventCallback          ServerEventType = "callback"            // The server reported a lifecycle event itself (Stage is the event)
+)
+
+// ServerEvent is a single entry of a server event stream
+type ServerEvent struct {
+	ID         int64           `json:"id"`                  // Increasing sequence number within the stream (SSE "id:")
+	Type       ServerEventType `json:"type"`                // Kind of event
+	Stage      string          `json:"stage,omitempty"`     // Phase or setup stage name, when relevant
+	State      string          `json:"state,omitempty"`     // EC2 state, for ec2_state events
+	PublicIP   string          `json:"public_ip,omitempty"` // Public IP of the instance, for the ec2_state events of a running one
+	Message    string          `json:"message"`             // Human readable description
+	InstanceID string          `json:"instance_id,omitempty"`
+	At         string          `json:"at"`
+}
@@ -33,12 +33,64 @@ type MinecraftServerRequest struct {
 	ModPackURL    string   `json:"modpack_url"`             // URL to modpack zip file
 	PluginURLs    []string `json:"plugin_urls"`             // URLs to plugin JAR files
 	
+	// Persistent World (optional)
+	DataVolume        bool   `json:"data_volume"`          // Keep the world on a dedicated EBS volume that outlives the server
+	DataVolumeID      string `json:"data_volume_id"`       // Launch on the data volume of a previous server, to get its world back (implies data_volume)
+	DataVolumeSizeGiB int    `json:"data_volume_size_gib"` // Size of a new data volume in GiB (default: 10)
+	
+	// Restore (optional)
+	RestoreFromBackup string `json:"restore_from_backup"`  // Start with the world of a backup: a backup ID, or "<server id>/<backup id>"
+	WorldID           string `json:"world_id"`             // Start with a world uploaded through POST /minecraft/worlds
+	
+	// Idle Auto-Shutdown (optional)
+	IdlePolicy        IdlePolicy `json:"idle_policy"`      // What the server does once nobody plays on it (default: stop after 5 minutes)
+	
+	// Trial (optional)
+	Trial             string `json:"trial"`                // Trial attempt the server is charged on: "test" or "custom" (default)
+	
 	// NOTE: Memory (3G), InstanceType (t3.medium), and KeyName (from .env) are set internally and cannot be overridden from frontend
 	
 	// Internal fields (not exposed in JSON, set by backend only)
 	Memory        string `json:"-"`                        // JVM memory allocation (fixed at 3G)
 	InstanceType  string `json:"-"`                        // EC2 instance type (fixed at t3.medium)
 	KeyName       string `json:"-"`                        // SSH key pair name (from .env)
+	RCONPassword  string `json:"-"`                        // Random RCON password generated per server (never returned to clients)
+	OwnerID       string `json:"-"`                        // Supabase user ID of the caller or owner of its API key (from the auth context), empty for API_KEY
+	RestoreURL    string `json:"-"`                        // Pre-signed download URL of the restored backup
+	WorldURL      string `json:"-"`                        // Pre-signed download URL of the uploaded world
+	CallbackToken string `json:"-"`                        // Random token the server signs its lifecycle callbacks with
+	ChargeTrial   bool   `json:"-"`                        // Whether the creation consumes a trial attempt of the owner (not for admins)
+}
+
+// Trial attempts, one counter each in the Supabase profile of the users
+const (
+	TrialTest   = "test"   // The quick test server of the dashboard
+	TrialCustom = "custom" // A server configured by the user
+)
+
+// Actions of an idle policy
+const (
+	IdleActionStop      = "stop"      // Stop the instance, keeping its world (a backup is taken first when backups are enabled)
+	IdleActionTerminate = "terminate" // Terminate the instance: the world is lost unless it is on a data volume or backed up
+	IdleActionHibernate = "hibernate" // Back the world up, then stop the instance; it stays up until the backup succeeds
+)
+
+// IdlePolicy tells what a server does once nobody plays on it
+type IdlePolicy struct {
+	DelaySeconds int    `json:"delay_seconds"` // How long the server must stay empty (default: 300, from 60 to 86400)
+	Action       string `json:"action"`        // "stop" (default), "terminate" or "hibernate"
+	NeverIdle    bool   `json:"never_idle"`    // Never shut the server down when it is empty (admins only)
+}
+
+// SetDefaults fills the unset values of an idle policy. Stopped servers are terminated by the garbage collector after
+// MAX_STOPPED_LIFETIME, so the default stop does not keep an abandoned server billed for good.
+func (p *IdlePolicy) SetDefaults() {
+	if p.DelaySeconds == 0 {
+		p.DelaySeconds = 300
+	}
+	if p.Action == "" {
+		p.Action = IdleActionStop
+	}
 }
 
 // MinecraftServerResponse represents the response after creating a Minecraft server
@@ -61,8 +113,81 @@ type MinecraftServerResponse struct {
 	// Connection Information
 	ServerAddress    string `json:"server_address"`     // IP:Port for Minecraft client
 	
+	// Persistent World
+	DataVolumeID     string `json:"data_volume_id,omitempty"` // EBS volume holding the world, reusable by a new server once this one is terminated
+	
+	// Idle Auto-Shutdown (info endpoint only)
+	IdlePolicy       *IdlePolicy `json:"idle_policy,omitempty"`
+	
+	// Lifecycle Callbacks (info endpoint only, reported by the server itself)
+	ReadyAt          string `json:"ready_at,omitempty"`         // When Minecraft first reported ready
+	LastCallback     string `json:"last_callback,omitempty"`    // Last lifecycle event reported by the server
+	LastCallbackAt   string `json:"last_callback_at,omitempty"`
+	
 	// Status
 	Message          string `json:"message"`
+	MinecraftStatus  *MinecraftServerStatus `json:"minecraft_status,omitempty"` // Live status from a Server List Ping (info endpoint only)
+	
+	// History (info endpoint only, from the server registry)
+	CreatedAt         string             `json:"created_at,omitempty"`
+	TerminatedAt      string             `json:"terminated_at,omitempty"`
+	TerminationReason string             `json:"termination_reason,omitempty"`
+	History           []ServerTransition `json:"history,omitempty"`
+}
+
+// MinecraftServerStatus represents what the Minecraft server itself reports through the Server List Ping protocol
+type MinecraftServerStatus struct {
+	Online        bool   `json:"online"`                   // True when the server answered the ping (it accepts players)
+	MOTD          string `json:"motd,omitempty"`           // Message of the day, without formatting codes
+	Version       string `json:"version,omitempty"`        // Version name reported by the server (e.g. "1.21.4")
+	Protocol      int    `json:"protocol,omitempty"`       // Protocol number of that version
+	PlayersOnline int    `json:"players_online"`
+	PlayersMax    int    `json:"players_max"`
+	LatencyMs     int64  `json:"latency_ms,omitempty"`     // Round trip of the ping, as seen from the backend
+	Error         string `json:"error,omitempty"`          // Why the ping failed, when Online is false
+}
+
+// MinecraftServerSummary represents one of the caller's servers in GET /minecraft/servers (rebuilt from the instance tags)
+type MinecraftServerSummary struct {
+	InstanceID       string `json:"instance_id"`
+	ServerName       string `json:"server_name"`
+	ServerType       string `json:"server_type"`
+	MinecraftVersion string `json:"minecraft_version"`
+	State            string `json:"state"`
+	PublicIP         string `json:"public_ip"`
+	ServerAddress    string `json:"server_address"`    // IP:Port for Minecraft client, empty while the instance has no public IP
+	CreatedAt        string `json:"created_at"`
+	DataVolumeID     string `json:"data_volume_id,omitempty"` // EBS volume holding the world, when the server has one
+	TerminatedAt      string `json:"terminated_at,omitempty"`
+	TerminationReason string `json:"termination_reason,omitempty"`
+}
+
+// MinecraftServerListResponse represents the response of GET /minecraft/servers
+type MinecraftServerListResponse struct {
+	Servers []MinecraftServerSummary `json:"servers"`
+	Count   int                      `json:"count"`
+}
+
+// ServerLifecycleResponse represents the result of stopping, starting or terminating a Minecraft server
+type ServerLifecycleResponse struct {
+	InstanceID    string `json:"instance_id"`
+	Action        string `json:"action"` // "stop", "start" or "terminate"
+	State         string `json:"state"`  // EC2 state once the operation was accepted (stopping, running, shutting-down...)
+	PublicIP      string `json:"public_ip,omitempty"`
+	ServerAddress string `json:"server_address,omitempty"` // Known once running: a started server gets a new public IP, sent on its event stream
+	Message       string `json:"message"`
+}
+
+// ServerCommandRequest represents a console command to run on a Minecraft server through RCON
+type ServerCommandRequest struct {
+	Command string `json:"command" binding:"required"` // Console command without the leading "/" (e.g. "say hello", "weather clear")
+}
+
+// ServerCommandResponse represents the output of a console command
+type ServerCommandResponse struct {
+	InstanceID string `json:"instance_id"`
+	Command    string `json:"command"`
+	Output     string `json:"output"` // What the server answered (may be empty for commands with no feedback)
 }
 
 // MinecraftServerDefaults provides default values
@@ -89,6 +214,16 @@ func (r *MinecraftServerRequest) SetDefaults() {
 	r.Memory = "3G"
 	r.InstanceType = "t3.medium"
 	// KeyName is set from .env in the service layer
+	if r.DataVolumeID != "" {
+		r.DataVolume = true
+	}
+	if r.DataVolume && r.DataVolumeSizeGiB == 0 {
+		r.DataVolumeSizeGiB = 10
+	}
+	r.IdlePolicy.SetDefaults()
+	if r.Trial == "" {
+		r.Trial = TrialCustom
+	}
 	if r.MOTD == "" {
 		r.MOTD = "A server created using The Minecraft Server Generator :D"
 	}
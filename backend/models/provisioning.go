@@ -0,0 +1,48 @@
+package models
+
+/*
+The definition of models for the asynchronous provisioning jobs created by POST /minecraft/create.
+*/
+
+// ProvisioningPhase is the step a provisioning job is currently in.
+type ProvisioningPhase string
+
+// Phases of a provisioning job, in the order they happen. A job ends either in PhaseMinecraftReady or in PhaseFailed.
+const (
+	PhaseQueued         ProvisioningPhase = "queued"          // Waiting for a free worker
+	PhaseSecurityGroup  ProvisioningPhase = "security_group"  // Looking up / creating the Minecraft security group
+	PhaseLaunch         ProvisioningPhase = "launch"          // RunInstances has been called
+	PhaseRunning        ProvisioningPhase = "running"         // The EC2 instance is running and has a public IP
+	PhaseDockerReady    ProvisioningPhase = "docker_ready"    // Docker is installed and the Minecraft container was started
+	PhaseMinecraftReady ProvisioningPhase = "minecraft_ready" // The Minecraft server accepts connections
+	PhaseFailed         ProvisioningPhase = "failed"          // Something went wrong, see the job error
+)
+
+// ProvisioningPhaseEntry records when a job entered a phase.
+type ProvisioningPhaseEntry struct {
+	Phase ProvisioningPhase `json:"phase"`
+	At    string            `json:"at"`
+}
+
+// ProvisioningJob represents the state of an asynchronous server creation
+type ProvisioningJob struct {
+	JobID     string                   `json:"job_id"`
+	Phase     ProvisioningPhase        `json:"phase"`
+	Done      bool                     `json:"done"`            // True once the job reached minecraft_ready or failed
+	Error     string                   `json:"error,omitempty"` // Set only when Phase is "failed"
+	CreatedAt string                   `json:"created_at"`
+	UpdatedAt string                   `json:"updated_at"`
+	History   []ProvisioningPhaseEntry `json:"history"`
+	OwnerID   string                   `json:"-"`               // User who requested the server (checked before showing the job)
+
+	// Server details, available once the instance has been launched
+	Server *MinecraftServerResponse `json:"server,omitempty"`
+}
+
+// ProvisioningJobAccepted is the response returned by POST /minecraft/create (202 Accepted)
+type ProvisioningJobAccepted struct {
+	JobID     string            `json:"job_id"`
+	Phase     ProvisioningPhase `json:"phase"`
+	StatusURL string            `json:"status_url"` // Endpoint to poll for the job progress
+	Message   string            `json:"message"`
+}
@@ -0,0 +1,48 @@
+package models
+
+/*
+The definition of models for the server registry, the backend's own record of every server it created.
+Unlike the EC2 tags, the registry outlives the instances, so the history of a server survives its termination.
+*/
+
+// ServerRecord is everything the registry knows about a server
+type ServerRecord struct {
+	InstanceID        string                  `json:"instance_id"`
+	OwnerID           string                  `json:"owner_id"` // Supabase user ID of the creator or owner of its API key, empty for API_KEY
+	ServerName        string                  `json:"server_name"`
+	ServerType        string                  `json:"server_type"`
+	MinecraftVersion  string                  `json:"minecraft_version"`
+	InstanceType      string                  `json:"instance_type"`
+	AvailabilityZone  string                  `json:"availability_zone"`
+	State             string                  `json:"state"` // Last EC2 state observed (pending, running, stopped, terminated...)
+	PublicIP          string                  `json:"public_ip"`
+	PrivateIP         string                  `json:"private_ip"`
+	Request           *MinecraftServerRequest `json:"request,omitempty"` // The creation request (internal fields such as secrets are never stored)
+	CreatedAt         string                  `json:"created_at"`
+	UpdatedAt         string                  `json:"updated_at"` // Last time something changed
+	SyncedAt          string                  `json:"synced_at"`  // Last time the state was confirmed with EC2
+	LaunchTime        string                  `json:"launch_time,omitempty"`
+	TerminatedAt      string                  `json:"terminated_at,omitempty"`
+	TerminationReason string                  `json:"termination_reason,omitempty"`
+	LaunchError       string                  `json:"launch_error,omitempty"` // Why the launch failed; such instances are garbage collected
+	DataVolumeID      string                  `json:"data_volume_id,omitempty"` // EBS volume holding the world, which outlives the instance
+	ReadyAt           string                  `json:"ready_at,omitempty"`         // First time the server reported it accepts players
+	LastCallback      string                  `json:"last_callback,omitempty"`    // Last lifecycle event the server reported (see models/callback.go)
+	LastCallbackAt    string                  `json:"last_callback_at,omitempty"`
+}
+
+// ServerTransition records a change of the EC2 state of a server
+type ServerTransition struct {
+	FromState string `json:"from_state"`
+	ToState   string `json:"to_state"`
+	Reason    string `json:"reason,omitempty"`
+	At        string `json:"at"`
+}
+
+// ServerStateUpdate describes what was observed about an instance, to be applied to its record
+type ServerStateUpdate struct {
+	State     string
+	PublicIP  string
+	PrivateIP string
+	Reason    string // Why the state changed (e.g. "stopped by owner"), recorded with the transition
+}
@@ -0,0 +1,25 @@
+package models
+
+/*
+The definition of models for the worlds uploaded through POST /minecraft/worlds and downloaded through
+GET /minecraft/servers/:id/world.
+*/
+
+// WorldUploadResponse represents a world archive once it is validated and stored
+type WorldUploadResponse struct {
+	WorldID        string `json:"world_id"` // Use it as world_id when creating a server
+	FileName       string `json:"file_name"`
+	SizeBytes      int64  `json:"size_bytes"`
+	LevelDirectory string `json:"level_directory,omitempty"` // Directory of the archive holding level.dat, empty for the root
+	Message        string `json:"message"`
+}
+
+// WorldDownloadResponse represents the current world of a server, packaged as a zip by GET /minecraft/servers/:id/world
+type WorldDownloadResponse struct {
+	InstanceID  string `json:"instance_id"`
+	DownloadID  string `json:"download_id"` // <UTC timestamp>-download-<random suffix>
+	LevelName   string `json:"level_name"`  // Folder of /opt/minecraft-data that was packaged
+	SizeBytes   int64  `json:"size_bytes"`
+	DownloadURL string `json:"download_url"` // Pre-signed link to the zip
+	ExpiresAt   string `json:"expires_at"`   // When the link stops working
+}
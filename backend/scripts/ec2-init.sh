@@ -1,8 +1,56 @@
 #!/bin/bash
 set -e
+# Rendered by the backend with text/template (services/user_data.go), which fills in the double-brace actions
+
+# Report a setup stage on the serial console, where the backend reads it (EC2 GetConsoleOutput), and in the setup log
+stage() {
+    echo "MCSG-STAGE $1" | tee -a /var/log/minecraft-setup.log > /dev/console
+}
+
+# Callback settings written by the backend (callbacks are disabled when CALLBACK_URL is empty)
+cat > /etc/minecraft-callback.env << 'EOF'
+CALLBACK_URL={{shell .Callbacks.URL}}
+CALLBACK_TOKEN={{shell .Callbacks.Token}}
+EOF
+chmod 600 /etc/minecraft-callback.env
+
+# Create the callback script: reports a lifecycle event to the backend, signed with the callback token of this server
+# (HMAC-SHA256 of "<timestamp>.<body>"). Best effort: the server works the same when the backend cannot be reached.
+cat > /usr/local/bin/minecraft-callback.sh << 'EOF'
+#!/bin/bash
+# Usage: minecraft-callback.sh <event> [message]
+. /etc/minecraft-callback.env
+if [ -z "$CALLBACK_URL" ]; then
+    exit 0
+fi
+EVENT="$1"
+MESSAGE=$(printf '%s' "$2" | tr -d '"\\')
+
+TOKEN=$(curl -X PUT "http://169.254.169.254/latest/api/token" -H "X-aws-ec2-metadata-token-ttl-seconds: 300" -s -m 5)
+INSTANCE_ID=$(curl -H "X-aws-ec2-metadata-token: $TOKEN" -s -m 5 http://169.254.169.254/latest/meta-data/instance-id)
+TIMESTAMP=$(date +%s)
+BODY="{\"instance_id\":\"$INSTANCE_ID\",\"event\":\"$EVENT\",\"message\":\"$MESSAGE\",\"sent_at\":$TIMESTAMP}"
+SIGNATURE=$(printf '%s.%s' "$TIMESTAMP" "$BODY" | openssl dgst -sha256 -hmac "$CALLBACK_TOKEN" | awk '{print $NF}')
+
+curl -sf -m 10 -o /dev/null -X POST "$CALLBACK_URL" \
+    -H "Content-Type: application/json" \
+    -H "X-Callback-Timestamp: $TIMESTAMP" \
+    -H "X-Callback-Signature: sha256=$SIGNATURE" \
+    --data "$BODY" || echo "$(date): Callback $EVENT failed" >> /var/log/minecraft-setup.log
+exit 0
+EOF
+chmod +x /usr/local/bin/minecraft-callback.sh
+
+phone_home() {
+    /usr/local/bin/minecraft-callback.sh "$@" || true
+}
+
+stage setup_started
+phone_home setup_started
 
 # Update system
 yum update -y
+stage packages_updated
 
 # Install Docker
 yum install -y docker
@@ -13,43 +61,341 @@ systemctl enable docker
 
 # Add ec2-user to docker group
 usermod -a -G docker ec2-user
+stage docker_installed
 
 # Pull the Minecraft server Docker image
+stage image_pull_started
 docker pull itzg/minecraft-server:latest
+stage image_pulled
+phone_home docker_pulled
 
 # Create directory for Minecraft data
 mkdir -p /opt/minecraft-data
-chown 1000:1000 /opt/minecraft-data
+
+# Mount the dedicated data volume (when the server has one) on it, so the world outlives the instance.
+# The backend attaches the volume once the instance is running: wait for it (up to 10 minutes).
+DATA_VOLUME_DEVICE={{shell .DataVolumeDevice}}
+if [ -n "$DATA_VOLUME_DEVICE" ]; then
+    for i in $(seq 1 120); do
+        if [ -e "$DATA_VOLUME_DEVICE" ]; then
+            break
+        fi
+        sleep 5
+    done
+    if [ ! -e "$DATA_VOLUME_DEVICE" ]; then
+        echo "ERROR: data volume $DATA_VOLUME_DEVICE was never attached" >> /var/log/minecraft-setup.log
+        exit 1
+    fi
+    # Only a blank volume is formatted: a volume from a previous server already holds its world
+    if ! blkid "$DATA_VOLUME_DEVICE" > /dev/null 2>&1; then
+        mkfs -t xfs "$DATA_VOLUME_DEVICE"
+    fi
+    mount "$DATA_VOLUME_DEVICE" /opt/minecraft-data
+    echo "UUID=$(blkid -s UUID -o value "$DATA_VOLUME_DEVICE") /opt/minecraft-data xfs defaults,nofail 0 2" >> /etc/fstab
+    stage data_volume_mounted
+fi
+
+# Restore the world from a backup (when the server is created from one) before the Minecraft container first starts
+RESTORE_URL={{shell .RestoreURL}}
+if [ -n "$RESTORE_URL" ]; then
+    if ! (set -o pipefail; curl -fsSL --retry 5 "$RESTORE_URL" | tar -xzf - -C /opt/minecraft-data); then
+        echo "ERROR: could not download and unpack the backup to restore" >> /var/log/minecraft-setup.log
+        exit 1
+    fi
+    stage world_restored
+fi
+chown -R 1000:1000 /opt/minecraft-data
 
 # Run Minecraft server container
 docker run -d \
   --name minecraft-server \
   --restart unless-stopped \
   -p 25565:25565 \
+  -p 25575:25575 \
   -v /opt/minecraft-data:/data \
-%s \
+{{range .DockerEnv}} -e {{shell .}}{{end}} \
   itzg/minecraft-server
 
 # Log the container status
 echo "Minecraft server container started" >> /var/log/minecraft-setup.log
 docker logs minecraft-server >> /var/log/minecraft-setup.log 2>&1
+stage docker_ready
+phone_home container_started
+
+# Wait in the background for the Minecraft "Done" log line (up to 15 minutes) and report the server as ready
+(
+    for i in $(seq 1 180); do
+        if docker logs minecraft-server 2>&1 | grep -q "Done ("; then
+            stage minecraft_ready
+            phone_home ready
+            exit 0
+        fi
+        sleep 5
+    done
+) &
 
 # Install AWS CLI v2 for auto-shutdown
-yum install -y unzip
+yum install -y unzip zip
 curl "https://awscli.amazonaws.com/awscli-exe-linux-x86_64.zip" -o "/tmp/awscliv2.zip"
 unzip -q /tmp/awscliv2.zip -d /tmp
 /tmp/aws/install
 rm -rf /tmp/aws /tmp/awscliv2.zip
 
-# Create auto-shutdown monitor script
+# Backup settings written by the backend (backups are disabled when BACKUP_BUCKET is empty)
+cat > /etc/minecraft-backup.env << 'EOF'
+BACKUP_BUCKET={{shell .Backups.Bucket}}
+{{- with .Backups}}{{if .Bucket}}
+BACKUP_PREFIX={{shell .Prefix}}
+BACKUP_S3_ENDPOINT={{shell .Endpoint}}
+BACKUP_S3_REGION={{shell .Region}}
+BACKUP_INTERVAL={{.IntervalSeconds}}
+LEVEL_NAME={{shell .LevelName}}
+MINECRAFT_VERSION={{shell .MinecraftVersion}}
+{{- end}}{{end}}
+EOF
+chmod 600 /etc/minecraft-backup.env
+. /etc/minecraft-backup.env
+
+if [ -n "$BACKUP_BUCKET" ]; then
+mkdir -p /var/lib/minecraft-backup
+
+# Create the backup script: saves the world through RCON, archives /opt/minecraft-data and uploads it to the bucket
+# as <prefix>/<instance id>/<backup id>.tar.gz
+cat > /usr/local/bin/minecraft-backup.sh << 'EOF'
+#!/bin/bash
+# Usage: minecraft-backup.sh <kind> [backup id]    (kind: scheduled, manual or shutdown)
+. /etc/minecraft-backup.env
+LOG_FILE="/var/log/minecraft-backup.log"
+KIND="${1:-manual}"
+BACKUP_ID="${2:-$(date -u +%Y%m%dT%H%M%SZ)-$KIND-$(od -An -N4 -tx1 /dev/urandom | tr -d ' \n')}"
+LAST_BACKUP_FILE="/var/lib/minecraft-backup/last"
+
+# The idle monitor takes a backup right before shutting down: the shutdown hook does not need another one
+if [ "$KIND" = "shutdown" ] && [ -f "$LAST_BACKUP_FILE" ] && [ $(( $(date +%s) - $(cat "$LAST_BACKUP_FILE") )) -lt 600 ]; then
+    echo "$(date): Last backup is less than 10 minutes old, skipping the shutdown backup" >> "$LOG_FILE"
+    exit 0
+fi
+
+# One backup at a time
+exec 9> /var/lock/minecraft-backup.lock
+flock 9
+
+TOKEN=$(curl -X PUT "http://169.254.169.254/latest/api/token" -H "X-aws-ec2-metadata-token-ttl-seconds: 300" -s)
+INSTANCE_ID=$(curl -H "X-aws-ec2-metadata-token: $TOKEN" -s http://169.254.169.254/latest/meta-data/instance-id)
+ENDPOINT_ARGS=""
+if [ -n "$BACKUP_S3_ENDPOINT" ]; then
+    ENDPOINT_ARGS="--endpoint-url $BACKUP_S3_ENDPOINT"
+fi
+TARGET="s3://$BACKUP_BUCKET/$BACKUP_PREFIX/$INSTANCE_ID/$BACKUP_ID.tar.gz"
+
+rcon() {
+    docker exec minecraft-server rcon-cli "$@" >> "$LOG_FILE" 2>&1
+}
+
+echo "$(date): Starting $KIND backup $BACKUP_ID" >> "$LOG_FILE"
+
+# Stop the world writes while it is archived, so the backup is consistent; they are turned back on whatever happens
+running=false
+if docker ps | grep -q minecraft-server; then
+    running=true
+    rcon save-off
+    rcon save-all flush
+    trap 'rcon save-on' EXIT
+fi
+
+# Exact version of the server, to never restore the world on an older one
+VERSION=$(docker logs minecraft-server 2>&1 | grep -o "Starting minecraft server version [^ ]*" | tail -1 | awk '{print $NF}')
+
+tar -czf - --exclude=./logs -C /opt/minecraft-data . | \
+    aws s3 cp - "$TARGET" --region "$BACKUP_S3_REGION" $ENDPOINT_ARGS \
+    --metadata "kind=$KIND,level-name=$LEVEL_NAME,minecraft-version=${VERSION:-$MINECRAFT_VERSION}" >> "$LOG_FILE" 2>&1
+status=("${PIPESTATUS[@]}")
+
+if [ "$running" = true ]; then
+    rcon save-on
+    trap - EXIT
+fi
+
+if [ "${status[0]}" -ne 0 ] || [ "${status[1]}" -ne 0 ]; then
+    echo "$(date): Backup $BACKUP_ID failed (tar: ${status[0]}, upload: ${status[1]})" >> "$LOG_FILE"
+    exit 1
+fi
+date +%s > "$LAST_BACKUP_FILE"
+echo "$(date): Backup $BACKUP_ID uploaded to $TARGET" >> "$LOG_FILE"
+EOF
+
+# Create the world download script: zips the world folder /opt/minecraft-data/<level name> and uploads it to the bucket
+# as <prefix>/<instance id>/downloads/<download id>.zip
+cat > /usr/local/bin/minecraft-world-download.sh << 'EOF'
+#!/bin/bash
+# Usage: minecraft-world-download.sh <download id> <level name>
+. /etc/minecraft-backup.env
+LOG_FILE="/var/log/minecraft-backup.log"
+DOWNLOAD_ID="$1"
+LEVEL="${2:-$LEVEL_NAME}"
+
+case "$LEVEL" in
+    ""|.|..|*/*)
+        echo "$(date): Invalid level name '$LEVEL' for download $DOWNLOAD_ID" >> "$LOG_FILE"
+        exit 1
+        ;;
+esac
+if [ ! -d "/opt/minecraft-data/$LEVEL" ]; then
+    echo "$(date): No world folder /opt/minecraft-data/$LEVEL for download $DOWNLOAD_ID" >> "$LOG_FILE"
+    exit 1
+fi
+
+# Never at the same time as a backup
+exec 9> /var/lock/minecraft-backup.lock
+flock 9
+
+TOKEN=$(curl -X PUT "http://169.254.169.254/latest/api/token" -H "X-aws-ec2-metadata-token-ttl-seconds: 300" -s)
+INSTANCE_ID=$(curl -H "X-aws-ec2-metadata-token: $TOKEN" -s http://169.254.169.254/latest/meta-data/instance-id)
+ENDPOINT_ARGS=""
+if [ -n "$BACKUP_S3_ENDPOINT" ]; then
+    ENDPOINT_ARGS="--endpoint-url $BACKUP_S3_ENDPOINT"
+fi
+TARGET="s3://$BACKUP_BUCKET/$BACKUP_PREFIX/$INSTANCE_ID/downloads/$DOWNLOAD_ID.zip"
+
+rcon() {
+    docker exec minecraft-server rcon-cli "$@" >> "$LOG_FILE" 2>&1
+}
+
+echo "$(date): Packaging world $LEVEL for download $DOWNLOAD_ID" >> "$LOG_FILE"
+
+# Same as the backups: the world is not written while it is zipped
+running=false
+if docker ps | grep -q minecraft-server; then
+    running=true
+    rcon save-off
+    rcon save-all flush
+    trap 'rcon save-on' EXIT
+fi
+
+(cd /opt/minecraft-data && zip -q -r - "$LEVEL") | \
+    aws s3 cp - "$TARGET" --region "$BACKUP_S3_REGION" $ENDPOINT_ARGS >> "$LOG_FILE" 2>&1
+status=("${PIPESTATUS[@]}")
+
+if [ "$running" = true ]; then
+    rcon save-on
+    trap - EXIT
+fi
+
+if [ "${status[0]}" -ne 0 ] || [ "${status[1]}" -ne 0 ]; then
+    echo "$(date): Download $DOWNLOAD_ID failed (zip: ${status[0]}, upload: ${status[1]})" >> "$LOG_FILE"
+    exit 1
+fi
+echo "$(date): World $LEVEL uploaded to $TARGET" >> "$LOG_FILE"
+EOF
+
+# Create the backup agent: takes the scheduled backups, and the backups and world downloads requested through the API
+# (markers the backend drops under <prefix>/<instance id>/requests/ and <prefix>/<instance id>/downloads/requests/)
+cat > /usr/local/bin/minecraft-backup-agent.sh << 'EOF'
+#!/bin/bash
+. /etc/minecraft-backup.env
+LOG_FILE="/var/log/minecraft-backup.log"
+
+TOKEN=$(curl -X PUT "http://169.254.169.254/latest/api/token" -H "X-aws-ec2-metadata-token-ttl-seconds: 300" -s)
+INSTANCE_ID=$(curl -H "X-aws-ec2-metadata-token: $TOKEN" -s http://169.254.169.254/latest/meta-data/instance-id)
+S3_ARGS="--region $BACKUP_S3_REGION"
+if [ -n "$BACKUP_S3_ENDPOINT" ]; then
+    S3_ARGS="$S3_ARGS --endpoint-url $BACKUP_S3_ENDPOINT"
+fi
+REQUESTS="s3://$BACKUP_BUCKET/$BACKUP_PREFIX/$INSTANCE_ID/requests/"
+FAILED="s3://$BACKUP_BUCKET/$BACKUP_PREFIX/$INSTANCE_ID/failed/"
+DOWNLOAD_REQUESTS="s3://$BACKUP_BUCKET/$BACKUP_PREFIX/$INSTANCE_ID/downloads/requests/"
+DOWNLOAD_FAILED="s3://$BACKUP_BUCKET/$BACKUP_PREFIX/$INSTANCE_ID/downloads/failed/"
+
+echo "$(date): Backup agent started (scheduled backups every $BACKUP_INTERVAL seconds)" >> "$LOG_FILE"
+last_scheduled=$(date +%s)
+
+while true; do
+    for backup_id in $(aws s3 ls "$REQUESTS" $S3_ARGS 2>/dev/null | awk '{print $4}'); do
+        if /usr/local/bin/minecraft-backup.sh manual "$backup_id"; then
+            aws s3 rm "$REQUESTS$backup_id" $S3_ARGS >> "$LOG_FILE" 2>&1
+        else
+            aws s3 mv "$REQUESTS$backup_id" "$FAILED$backup_id" $S3_ARGS >> "$LOG_FILE" 2>&1
+        fi
+    done
+
+    # A download request holds the level name of the world to package
+    for download_id in $(aws s3 ls "$DOWNLOAD_REQUESTS" $S3_ARGS 2>/dev/null | awk '{print $4}'); do
+        level=$(aws s3 cp "$DOWNLOAD_REQUESTS$download_id" - $S3_ARGS 2>/dev/null)
+        if /usr/local/bin/minecraft-world-download.sh "$download_id" "$level"; then
+            aws s3 rm "$DOWNLOAD_REQUESTS$download_id" $S3_ARGS >> "$LOG_FILE" 2>&1
+        else
+            aws s3 mv "$DOWNLOAD_REQUESTS$download_id" "$DOWNLOAD_FAILED$download_id" $S3_ARGS >> "$LOG_FILE" 2>&1
+        fi
+    done
+
+    if [ "$BACKUP_INTERVAL" -gt 0 ] && [ $(( $(date +%s) - last_scheduled )) -ge "$BACKUP_INTERVAL" ]; then
+        /usr/local/bin/minecraft-backup.sh scheduled
+        last_scheduled=$(date +%s)
+    fi
+    sleep 10
+done
+EOF
+
+chmod +x /usr/local/bin/minecraft-backup.sh /usr/local/bin/minecraft-world-download.sh /usr/local/bin/minecraft-backup-agent.sh
+
+cat > /etc/systemd/system/minecraft-backup.service << 'EOF'
+[Unit]
+Description=Minecraft World Backup Agent
+After=docker.service network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+ExecStart=/usr/local/bin/minecraft-backup-agent.sh
+Restart=on-failure
+RestartSec=30
+
+[Install]
+WantedBy=multi-user.target
+EOF
+
+# Take a last backup when the instance stops or is terminated: this unit is stopped before Docker and the network
+# on shutdown, while the Minecraft container still runs
+cat > /etc/systemd/system/minecraft-backup-on-shutdown.service << 'EOF'
+[Unit]
+Description=Minecraft World Backup Before Shutdown
+After=docker.service network-online.target
+Wants=network-online.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/true
+ExecStop=/usr/local/bin/minecraft-backup.sh shutdown
+TimeoutStopSec=600
+
+[Install]
+WantedBy=multi-user.target
+EOF
+
+systemctl daemon-reload
+systemctl enable --now minecraft-backup.service minecraft-backup-on-shutdown.service
+echo "$(date): World backups enabled (bucket $BACKUP_BUCKET)" >> /var/log/minecraft-setup.log
+fi
+
+# Create auto-shutdown monitor script: applies the idle policy of the server once nobody has played on it for IDLE_DELAY
+# seconds. The players online are counted through RCON, so it works with every server type and version.
 cat > /usr/local/bin/minecraft-auto-shutdown.sh << 'EOF'
 #!/bin/bash
-SHUTDOWN_DELAY=300
+# Idle policy written by the backend
+IDLE_DELAY={{.IdlePolicy.DelaySeconds}}
+IDLE_ACTION={{shell .IdlePolicy.Action}}
+NEVER_IDLE={{.IdlePolicy.NeverIdle}}
+BACKEND_REGION={{shell .Region}}
 CHECK_INTERVAL=10
 LOG_FILE="/var/log/minecraft-auto-shutdown.log"
-REGION="us-east-1"
 
-echo "$(date): Auto-shutdown monitor started (300 second delay after server empty)" >> "$LOG_FILE"
+if [ "$NEVER_IDLE" = true ]; then
+    echo "$(date): Idle auto-shutdown disabled for this server (never_idle)" >> "$LOG_FILE"
+    exit 0
+fi
+
+echo "$(date): Auto-shutdown monitor started ($IDLE_ACTION after $IDLE_DELAY seconds without players)" >> "$LOG_FILE"
 
 # Get IMDSv2 token
 TOKEN=$(curl -X PUT "http://169.254.169.254/latest/api/token" -H "X-aws-ec2-metadata-token-ttl-seconds: 21600" -s)
@@ -68,56 +414,118 @@ if [ -z "$INSTANCE_ID" ]; then
     exit 1
 fi
 
+# The instance is stopped in its own region, which the backend only knows when it runs in the same one
+REGION=$(curl -H "X-aws-ec2-metadata-token: $TOKEN" -s http://169.254.169.254/latest/meta-data/placement/region)
+if [ -z "$REGION" ]; then
+    REGION="$BACKEND_REGION"
+    echo "$(date): Region not found in instance metadata, using the region of the backend" >> "$LOG_FILE"
+fi
+if [ -z "$REGION" ]; then
+    echo "$(date): ERROR - Could not determine the region of the instance!" >> "$LOG_FILE"
+    exit 1
+fi
+
 echo "$(date): Instance $INSTANCE_ID in region $REGION" >> "$LOG_FILE"
 
-# Wait for Minecraft server to fully start (reduced from 180 to 60 seconds)
-sleep 60
+# Report in by tagging the instance, through the same API that stops it: the backend flags the servers whose monitor
+# never did, since nothing would stop them
+if aws ec2 create-tags --resources "$INSTANCE_ID" --region "$REGION" \
+    --tags "Key=IdleMonitor,Value=$(date -u +%Y-%m-%dT%H:%M:%SZ)" >> "$LOG_FILE" 2>&1; then
+    echo "$(date): Reported in to EC2" >> "$LOG_FILE"
+else
+    echo "$(date): ERROR - Could not tag the instance, it will probably not be able to stop itself either!" >> "$LOG_FILE"
+fi
+
+# Number of players online, from the "list" command ("There are 0 of a max of 10 players online"); empty when the
+# server does not answer (still starting, or busy)
+players_online() {
+    docker exec minecraft-server rcon-cli list 2>/dev/null | grep -o "There are [0-9]*" | awk '{print $3}'
+}
+
+stop_instance() {
+    if aws ec2 stop-instances --instance-ids "$INSTANCE_ID" --region "$REGION" >> "$LOG_FILE" 2>&1; then
+        echo "$(date): Stop command sent" >> "$LOG_FILE"
+    else
+        echo "$(date): Stop failed, terminating instead..." >> "$LOG_FILE"
+        terminate_instance
+    fi
+}
 
-echo "$(date): Monitoring for 'Server empty for 60 seconds, pausing' message..." >> "$LOG_FILE"
+terminate_instance() {
+    if aws ec2 terminate-instances --instance-ids "$INSTANCE_ID" --region "$REGION" >> "$LOG_FILE" 2>&1; then
+        echo "$(date): Terminate command sent" >> "$LOG_FILE"
+    else
+        echo "$(date): ERROR - Terminate failed, the instance keeps running!" >> "$LOG_FILE"
+    fi
+}
 
-server_empty_time=0
+# Apply the idle action. A backup is taken first when backups are enabled; with "hibernate" the instance is only stopped
+# once the backup succeeded (it returns 1 otherwise, and the monitor tries again).
+shutdown_instance() {
+    backed_up=false
+    if [ -x /usr/local/bin/minecraft-backup.sh ] && /usr/local/bin/minecraft-backup.sh shutdown; then
+        backed_up=true
+    fi
+    case "$IDLE_ACTION" in
+        terminate)
+            terminate_instance
+            ;;
+        hibernate)
+            if [ "$backed_up" != true ]; then
+                echo "$(date): Backup failed, the instance stays up until one succeeds" >> "$LOG_FILE"
+                sleep 60
+                return 1
+            fi
+            stop_instance
+            ;;
+        *)
+            stop_instance
+            ;;
+    esac
+}
+
+# Wait for Minecraft server to fully start
+sleep 60
+
+empty_since=0
 
 while true; do
     if ! docker ps | grep -q minecraft-server; then
-        echo "$(date): Container stopped, terminating..." >> "$LOG_FILE"
-        aws ec2 terminate-instances --instance-ids "$INSTANCE_ID" --region "$REGION" >> "$LOG_FILE" 2>&1
-        exit 0
-    fi
-    
-    # Get recent logs (last 30 seconds to catch the message)
-    recent_logs=$(docker logs --since 30s minecraft-server 2>&1)
-    
-    # Check if server empty message appeared
-    if echo "$recent_logs" | grep -q "Server empty for 60 seconds, pausing"; then
-        if [ $server_empty_time -eq 0 ]; then
-            # First time seeing this message - start countdown
-            server_empty_time=$(date +%%s)
-            echo "$(date): Server empty detected! Will terminate in $SHUTDOWN_DELAY seconds..." >> "$LOG_FILE"
+        echo "$(date): Container stopped, applying the idle action ($IDLE_ACTION)..." >> "$LOG_FILE"
+        /usr/local/bin/minecraft-callback.sh terminating "Minecraft container stopped: $IDLE_ACTION"
+        if shutdown_instance; then
+            exit 0
         fi
+        sleep $CHECK_INTERVAL
+        continue
     fi
-    
-    # If countdown is active, check if we should terminate or reset
-    if [ $server_empty_time -ne 0 ]; then
-        # Check if someone joined (only way to reset the countdown)
-        if echo "$recent_logs" | grep -q "joined the game"; then
-            echo "$(date): Player joined! Timer reset" >> "$LOG_FILE"
-            server_empty_time=0
-        else
-            # No one joined - continue countdown
-            current_time=$(date +%%s)
-            elapsed=$((current_time - server_empty_time))
-            
-            if [ $elapsed -ge $SHUTDOWN_DELAY ]; then
-                echo "$(date): Shutdown delay reached ($elapsed seconds)! Terminating instance..." >> "$LOG_FILE"
-                aws ec2 terminate-instances --instance-ids "$INSTANCE_ID" --region "$REGION" >> "$LOG_FILE" 2>&1
-                echo "$(date): Terminate command sent" >> "$LOG_FILE"
+
+    players=$(players_online)
+    if [ -z "$players" ]; then
+        # Unknown: neither start nor reset the countdown
+        :
+    elif [ "$players" -gt 0 ]; then
+        if [ $empty_since -ne 0 ]; then
+            echo "$(date): $players player(s) online, countdown reset" >> "$LOG_FILE"
+        fi
+        empty_since=0
+    elif [ $empty_since -eq 0 ]; then
+        empty_since=$(date +%s)
+        echo "$(date): Server empty! Will $IDLE_ACTION in $IDLE_DELAY seconds..." >> "$LOG_FILE"
+        /usr/local/bin/minecraft-callback.sh idle_countdown "Server is empty, it will $IDLE_ACTION in $IDLE_DELAY seconds unless someone joins"
+    else
+        elapsed=$(( $(date +%s) - empty_since ))
+        if [ $elapsed -ge $IDLE_DELAY ]; then
+            echo "$(date): Idle delay reached ($elapsed seconds)! Applying the idle action ($IDLE_ACTION)..." >> "$LOG_FILE"
+            /usr/local/bin/minecraft-callback.sh terminating "Server was empty for $elapsed seconds: $IDLE_ACTION"
+            if shutdown_instance; then
                 exit 0
-            else
-                echo "$(date): Server still empty... $elapsed/$SHUTDOWN_DELAY seconds" >> "$LOG_FILE"
             fi
+        elif [ $(( elapsed % 60 )) -lt $CHECK_INTERVAL ]; then
+            echo "$(date): Server still empty... $elapsed/$IDLE_DELAY seconds" >> "$LOG_FILE"
         fi
     fi
-    
+
     sleep $CHECK_INTERVAL
 done
 EOF
@@ -146,4 +554,5 @@ systemctl enable minecraft-auto-shutdown.service
 systemctl start minecraft-auto-shutdown.service
 
 echo "$(date): Auto-shutdown monitor enabled" >> /var/log/minecraft-setup.log
+stage auto_shutdown_enabled
 echo "Minecraft server setup complete. Server is starting..." >> /var/log/minecraft-setup.log
@@ -0,0 +1,13 @@
+/*
+scripts.go
+In this file you will find the scripts run on the instances, embedded in the backend binary so that a deployment
+cannot lose them. ec2-init.sh is the user data template of the Minecraft servers (see services/user_data.go).
+*/
+package scripts
+
+import "embed"
+
+// FS holds the scripts run on the instances.
+//
+//go:embed ec2-init.sh
+var FS embed.FS
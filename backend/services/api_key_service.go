@@ -0,0 +1,166 @@
+/*
+api_key_service.go
+In this file you will find the API keys of the clients: admins create them with an owner, scopes and an optional
+expiry, list and revoke them (see handlers/admin_handler.go), and the auth middleware authenticates the X-API-Key
+header with them.
+
+A key looks like mcsg_<id>_<secret>. The backend only stores the SHA-256 hash of the key: the ID finds its record,
+then the hash of the presented key is compared with the stored one in constant time. The key is shown once, when it
+is created. As the secret is 32 random bytes, a fast hash is enough; there is nothing to brute-force.
+*/
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/access"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/storage"
+)
+
+var (
+	// ErrInvalidAPIKey is returned (wrapped) when a presented key is unknown, wrong, revoked or expired.
+	ErrInvalidAPIKey = access.ErrInvalidAPIKey
+	// ErrAPIKeyNotFound is returned (wrapped) when revoking a key that does not exist.
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+const (
+	apiKeyPrefix        = "mcsg_"
+	apiKeySecretBytes   = 32
+	apiKeyTouchInterval = time.Minute // last_used_at is only written once per interval, not on every request
+	apiKeyIDBytes       = 6
+	maxAPIKeyNameLength = 100
+	minAPIKeyLifetime   = time.Minute
+)
+
+// APIKeyService creates, lists, revokes and authenticates the API keys.
+type APIKeyService struct {
+	repository storage.APIKeyRepository
+}
+
+// NewAPIKeyService() => creates the API key service, with the keys stored in repository.
+func NewAPIKeyService(repository storage.APIKeyRepository) *APIKeyService {
+	return &APIKeyService{repository: repository}
+}
+
+// Create() => creates a key, returning it once along with its record. createdBy identifies the admin creating it.
+func (s *APIKeyService) Create(ctx context.Context, req models.CreateAPIKeyRequest, createdBy string) (*models.CreateAPIKeyResponse, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	req.OwnerID = strings.TrimSpace(req.OwnerID)
+	if req.Name == "" || len(req.Name) > maxAPIKeyNameLength {
+		return nil, fmt.Errorf("name must be between 1 and %d characters", maxAPIKeyNameLength)
+	}
+	if req.OwnerID == "" || strings.ContainsAny(req.OwnerID, ", \t\n") {
+		return nil, errors.New("owner_id must be a user ID")
+	}
+	if len(req.Scopes) == 0 {
+		return nil, fmt.Errorf("scopes must list at least one of %s", strings.Join(models.APIKeyScopes, ", "))
+	}
+	var scopes []string
+	for _, scope := range req.Scopes {
+		if !slices.Contains(models.APIKeyScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q (%s)", scope, strings.Join(models.APIKeyScopes, ", "))
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	createdAt := time.Now().UTC()
+	key := &models.APIKey{
+		Name:      req.Name,
+		OwnerID:   req.OwnerID,
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		CreatedAt: createdAt.Format(time.RFC3339),
+	}
+	if req.ExpiresIn != "" {
+		lifetime, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || lifetime < minAPIKeyLifetime {
+			return nil, fmt.Errorf("invalid expires_in %q, expected a duration of at least %v such as \"720h\"", req.ExpiresIn, minAPIKeyLifetime)
+		}
+		key.ExpiresAt = createdAt.Add(lifetime).Format(time.RFC3339)
+	}
+
+	id := make([]byte, apiKeyIDBytes)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %v", err)
+	}
+	key.ID = hex.EncodeToString(id)
+	secret, err := generateSecret(apiKeySecretBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %v", err)
+	}
+	plaintext := apiKeyPrefix + key.ID + "_" + secret
+
+	if err := s.repository.CreateAPIKey(ctx, key, hashAPIKey(plaintext)); err != nil {
+		return nil, err
+	}
+	return &models.CreateAPIKeyResponse{Key: plaintext, APIKey: *key}, nil
+}
+
+// List() => returns every key, revoked ones included, newest first. The keys themselves are never returned.
+func (s *APIKeyService) List(ctx context.Context) ([]models.APIKey, error) {
+	return s.repository.ListAPIKeys(ctx)
+}
+
+// Revoke() => revokes a key: it is refused from now on.
+func (s *APIKeyService) Revoke(ctx context.Context, id string) error {
+	err := s.repository.RevokeAPIKey(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrAPIKeyNotFound, id)
+	}
+	return err
+}
+
+// Authenticate() => returns the record of a presented key, or ErrInvalidAPIKey. It records when the key was used.
+func (s *APIKeyService) Authenticate(ctx context.Context, presented string) (*models.APIKey, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(presented, apiKeyPrefix), "_")
+	if !strings.HasPrefix(presented, apiKeyPrefix) || !ok || id == "" {
+		return nil, fmt.Errorf("%w: malformed key", ErrInvalidAPIKey)
+	}
+	key, hash, err := s.repository.APIKey(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w: unknown key %s", ErrInvalidAPIKey, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(presented)), []byte(hash)) != 1 {
+		return nil, fmt.Errorf("%w: wrong secret for key %s", ErrInvalidAPIKey, id)
+	}
+	if key.RevokedAt != "" {
+		return nil, fmt.Errorf("%w: key %s was revoked at %s", ErrInvalidAPIKey, id, key.RevokedAt)
+	}
+	now := time.Now()
+	if key.ExpiresAt != "" {
+		if expiresAt, err := time.Parse(time.RFC3339, key.ExpiresAt); err != nil || !now.Before(expiresAt) {
+			return nil, fmt.Errorf("%w: key %s expired at %s", ErrInvalidAPIKey, id, key.ExpiresAt)
+		}
+	}
+
+	if lastUsed, err := time.Parse(time.RFC3339, key.LastUsedAt); err != nil || now.Sub(lastUsed) >= apiKeyTouchInterval {
+		if err := s.repository.TouchAPIKey(ctx, id); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		key.LastUsedAt = now.UTC().Format(time.RFC3339)
+	}
+	return key, nil
+}
+
+// hashAPIKey() => returns the hex SHA-256 hash of a key, as stored in the registry.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
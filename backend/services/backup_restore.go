@@ -0,0 +1,172 @@
+/*
+backup_restore.go
+In this file you will find how a new server is created from a world backup (restore_from_backup): the backup is
+looked up among the servers of the requester, checked against the requested Minecraft version so a world is never
+downgraded, and handed to ec2-init.sh as a pre-signed URL it downloads and unpacks into /opt/minecraft-data before
+the Minecraft container first starts.
+*/
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/storage"
+)
+
+// How long the pre-signed URL of a restored backup is valid: the server downloads it a few minutes after its launch.
+const restoreURLExpiry = time.Hour
+
+// restoreSource is a backup a new server is restored from.
+type restoreSource struct {
+	InstanceID       string // Server the backup was taken on
+	BackupID         string
+	LevelName        string // Level name of the world, from the backup metadata
+	MinecraftVersion string // Version of Minecraft that saved the world, from the backup metadata
+	URL              string // Pre-signed download URL
+}
+
+/*
+prepareRestore() => finds the backup a request restores from and checks it can be restored with the requested
+version. The reference is a backup ID, looked up among the servers of the owner (every server for admins, whose
+ownerID is empty), or "<server id>/<backup id>" when several servers have a backup with that ID.
+*/
+func (s *BackupService) prepareRestore(ctx context.Context, reference, ownerID, version string) (*restoreSource, error) {
+	if !s.Enabled() {
+		return nil, fmt.Errorf("%w: restore_from_backup: %v", ErrInvalidServerRequest, ErrBackupsDisabled)
+	}
+
+	instanceID, backupID, qualified := strings.Cut(reference, "/")
+	if !qualified {
+		instanceID, backupID = "", reference
+	}
+	if backupID == "" || strings.Contains(backupID, "/") {
+		return nil, fmt.Errorf("%w: restore_from_backup must be a backup ID or <server id>/<backup id>", ErrInvalidServerRequest)
+	}
+
+	var candidates []string
+	if instanceID != "" {
+		record, err := s.minecraftService.getServerRecord(ctx, instanceID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: restore_from_backup: %v", ErrInvalidServerRequest, err)
+		}
+		if ownerID != "" && record.OwnerID != ownerID {
+			return nil, fmt.Errorf("%w: backup %s", ErrServerAccessDenied, reference)
+		}
+		candidates = append(candidates, instanceID)
+	} else {
+		records, err := s.minecraftService.registry.ListServers(ctx, storage.ServerFilter{OwnerID: ownerID, IncludeTerminated: true})
+		if err != nil {
+			return nil, fmt.Errorf("failed to look for backup %s: %v", backupID, err)
+		}
+		for _, record := range records {
+			candidates = append(candidates, record.InstanceID)
+		}
+	}
+
+	var found []*restoreSource
+	for _, candidate := range candidates {
+		key := s.serverPrefix(candidate) + backupID + ".tar.gz"
+		head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(s.config.Bucket),
+			Key:    aws.String(key),
+		})
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look for backup %s: %v", backupID, err)
+		}
+		found = append(found, &restoreSource{
+			InstanceID:       candidate,
+			BackupID:         backupID,
+			LevelName:        head.Metadata["level-name"],
+			MinecraftVersion: head.Metadata["minecraft-version"],
+		})
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("%w: backup %s not found", ErrInvalidServerRequest, reference)
+	case 1:
+	default:
+		return nil, fmt.Errorf("%w: several of your servers have a backup %s, use <server id>/<backup id>", ErrInvalidServerRequest, backupID)
+	}
+	source := found[0]
+
+	if err := checkRestoreVersion(source.MinecraftVersion, version); err != nil {
+		return nil, fmt.Errorf("%w: backup %s: %v", ErrInvalidServerRequest, reference, err)
+	}
+
+	presigned, err := s.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(s.serverPrefix(source.InstanceID) + backupID + ".tar.gz"),
+	}, s3.WithPresignExpires(restoreURLExpiry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign the download of backup %s: %v", backupID, err)
+	}
+	source.URL = presigned.URL
+	log.Printf("Restoring backup %s of %s (Minecraft %s) on a new server", backupID, source.InstanceID, source.MinecraftVersion)
+	return source, nil
+}
+
+/*
+checkRestoreVersion() => refuses to restore a world saved by Minecraft backupVersion on an older requested version,
+which would corrupt it. LATEST is never older than a backup. When the version of the backup is not known, only LATEST
+is allowed.
+*/
+func checkRestoreVersion(backupVersion, requested string) error {
+	if strings.EqualFold(requested, "LATEST") {
+		return nil
+	}
+	backupRelease, ok := parseReleaseVersion(backupVersion)
+	if !ok {
+		return fmt.Errorf("the Minecraft version it was saved with is unknown (%q), restore it with version LATEST", backupVersion)
+	}
+	requestedRelease, ok := parseReleaseVersion(requested)
+	if !ok {
+		return fmt.Errorf("it was saved with Minecraft %s and version %q cannot be compared with it, use a release version or LATEST",
+			backupVersion, requested)
+	}
+	for i := 0; i < len(backupRelease) || i < len(requestedRelease); i++ {
+		var b, r int
+		if i < len(backupRelease) {
+			b = backupRelease[i]
+		}
+		if i < len(requestedRelease) {
+			r = requestedRelease[i]
+		}
+		if r > b {
+			return nil
+		}
+		if r < b {
+			return fmt.Errorf("it was saved with Minecraft %s, restoring it on %s would downgrade the world", backupVersion, requested)
+		}
+	}
+	return nil
+}
+
+// parseReleaseVersion() => splits a release version ("1.21.4") into its numbers. Snapshots and pre-releases are not releases.
+func parseReleaseVersion(version string) ([]int, bool) {
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 {
+		return nil, false
+	}
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return nil, false
+		}
+		numbers[i] = number
+	}
+	return numbers, true
+}
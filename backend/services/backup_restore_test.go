@@ -0,0 +1,53 @@
+package services
+
+import "testing"
+
+func TestCheckRestoreVersion(t *testing.T) {
+	cases := []struct {
+		name      string
+		backup    string
+		requested string
+		allowed   bool
+	}{
+		{"same version", "1.21.4", "1.21.4", true},
+		{"newer patch", "1.21.4", "1.21.5", true},
+		{"newer minor", "1.20.6", "1.21", true},
+		{"older patch", "1.21.4", "1.21.3", false},
+		{"older minor", "1.21.4", "1.20.6", false},
+		{"older major", "1.21.4", "0.30", false},
+		{"1.21 and 1.21.0", "1.21", "1.21.0", true},
+		{"1.21.0 and 1.21", "1.21.0", "1.21", true},
+		{"1.21.1 and 1.21", "1.21.1", "1.21", false},
+		{"multi-digit numbers", "1.9.4", "1.10", true},
+		{"LATEST on a release backup", "1.21.4", "LATEST", true},
+		{"latest in lower case", "1.21.4", "latest", true},
+		{"LATEST on both sides", "LATEST", "LATEST", true},
+		{"release on a LATEST backup", "LATEST", "1.21.4", false},
+		{"snapshot backup, LATEST", "24w14a", "LATEST", true},
+		{"snapshot backup, release", "24w14a", "1.21.4", false},
+		{"pre-release backup", "1.21-pre1", "1.21.4", false},
+		{"snapshot requested", "1.21.4", "24w14a", false},
+		{"release candidate requested", "1.21.4", "1.21.5-rc1", false},
+		{"unknown backup version", "", "1.21.4", false},
+		{"unknown backup version, LATEST", "", "LATEST", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := checkRestoreVersion(c.backup, c.requested)
+			if (err == nil) != c.allowed {
+				t.Fatalf("checkRestoreVersion(%q, %q) = %v, want allowed: %t", c.backup, c.requested, err, c.allowed)
+			}
+		})
+	}
+}
+
+func TestParseReleaseVersion(t *testing.T) {
+	for version, release := range map[string]bool{
+		"1.21.4": true, "1.21": true, "1.8.9": true,
+		"1": false, "": false, "LATEST": false, "24w14a": false, "1.21-pre1": false, "1.21.5-rc1": false, "1.-1": false,
+	} {
+		if _, ok := parseReleaseVersion(version); ok != release {
+			t.Errorf("parseReleaseVersion(%q) release = %t, want %t", version, ok, release)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+/*
+backup_service.go
+In this file you will find the world backups. The servers take them themselves: ec2-init.sh installs a backup agent
+which saves the world through RCON (save-off, save-all flush), archives /opt/minecraft-data and uploads it to an
+S3-compatible bucket on a schedule, when the instance stops or is terminated, and when a backup is requested through
+the API. The backend only lists the backups and requests new ones, by dropping a marker the agent picks up.
+
+Layout of the bucket, under BACKUP_PREFIX:
+
+	<instance id>/<backup id>.tar.gz       completed backups
+	<instance id>/requests/<backup id>     backups requested through the API, not taken yet
+	<instance id>/failed/<backup id>       requested backups the server could not take
+*/
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+// ErrBackupsDisabled is returned when backups are used while no backup bucket is configured.
+var ErrBackupsDisabled = errors.New("backups are not configured on this backend (BACKUP_BUCKET)")
+
+/*
+A backup ID is "<UTC timestamp>-<kind>-<random suffix>", as the backup agent writes it too: the timestamp (date -u
++%Y%m%dT%H%M%SZ) sorts the backups, the suffix keeps apart the backups (and downloads) requested in the same second.
+*/
+const (
+	backupIDTimeFormat  = "20060102T150405Z"
+	backupIDSuffixBytes = 4
+)
+
+// BackupConfig describes the bucket the backups go to, for the backend and for the backup agent of the servers.
+type BackupConfig struct {
+	Bucket          string // Backups are disabled when empty
+	Prefix          string // Key prefix of the backups in the bucket (default: "backups")
+	Endpoint        string // S3-compatible endpoint (e.g. http://minio:9000); empty for AWS S3
+	Region          string // Region of the bucket
+	AccessKeyID     string // Static credentials of the backend only, never given to the servers; empty to use the default AWS credentials
+	SecretAccessKey string
+	Interval        time.Duration // Time between scheduled backups on a running server (0 disables them)
+}
+
+/*
+NewS3Client() => creates the S3 client of a backup configuration. With a custom endpoint (MinIO and other
+S3-compatible storages) path-style addressing is used, since those rarely support bucket subdomains.
+*/
+func NewS3Client(ctx context.Context, backupConfig BackupConfig) (*s3.Client, error) {
+	options := []func(*config.LoadOptions) error{config.WithRegion(backupConfig.Region)}
+	if backupConfig.AccessKeyID != "" {
+		options = append(options, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(backupConfig.AccessKeyID, backupConfig.SecretAccessKey, "")))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the S3 config: %v", err)
+	}
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if backupConfig.Endpoint != "" {
+			o.BaseEndpoint = aws.String(backupConfig.Endpoint)
+			o.UsePathStyle = true
+		}
+	}), nil
+}
+
+// BackupService lists and requests the world backups of the Minecraft servers.
+type BackupService struct {
+	client           S3API       // Real *s3.Client in production, FakeS3 with the fake cloud; nil when backups are disabled
+	presigner        S3Presigner // Pre-signed URLs of the backups restored on new servers
+	config           BackupConfig
+	minecraftService *MinecraftService
+}
+
+/*
+NewBackupService() => creates the backup service. When backups are enabled (client not nil and a bucket configured),
+the servers created from now on by minecraftService run the backup agent and may be restored from a backup.
+*/
+func NewBackupService(client S3API, presigner S3Presigner, backupConfig BackupConfig, minecraftService *MinecraftService) *BackupService {
+	if backupConfig.Prefix == "" {
+		backupConfig.Prefix = "backups"
+	}
+	service := &BackupService{
+		client:           client,
+		presigner:        presigner,
+		config:           backupConfig,
+		minecraftService: minecraftService,
+	}
+	if service.Enabled() {
+		minecraftService.backups = service
+	}
+	return service
+}
+
+// Enabled() => reports whether a backup bucket is configured (a nil service is disabled).
+func (s *BackupService) Enabled() bool {
+	return s != nil && s.client != nil && s.config.Bucket != ""
+}
+
+// serverPrefix() => returns the key prefix of the backups of a server.
+func (s *BackupService) serverPrefix(instanceID string) string {
+	return path.Join(s.config.Prefix, instanceID) + "/"
+}
+
+/*
+ListBackups() => returns the backups of a server, newest first: the completed ones, and those requested through the
+API that are still waiting or that failed. Backups outlive their server, so terminated servers are listed too.
+*/
+func (s *BackupService) ListBackups(ctx context.Context, instanceID string) ([]models.Backup, error) {
+	if !s.Enabled() {
+		return nil, ErrBackupsDisabled
+	}
+	if _, err := s.minecraftService.getServerRecord(ctx, instanceID); err != nil {
+		return nil, err
+	}
+
+	prefix := s.serverPrefix(instanceID)
+	found := make(map[string]*models.Backup)
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.config.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the backups of %s: %v", instanceID, err)
+		}
+		for _, object := range page.Contents {
+			backup, ok := parseBackupKey(strings.TrimPrefix(aws.ToString(object.Key), prefix))
+			if !ok {
+				continue
+			}
+			// A completed backup wins over the marker of its request, in case the marker is still there
+			if known, seen := found[backup.BackupID]; seen && known.Status == models.BackupCompleted {
+				continue
+			}
+			if backup.Status == models.BackupCompleted {
+				backup.SizeBytes = aws.ToInt64(object.Size)
+			}
+			if backup.CreatedAt == "" && object.LastModified != nil {
+				backup.CreatedAt = object.LastModified.UTC().Format(time.RFC3339)
+			}
+			found[backup.BackupID] = &backup
+		}
+	}
+
+	backups := make([]models.Backup, 0, len(found))
+	for _, backup := range found {
+		backups = append(backups, *backup)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].BackupID > backups[j].BackupID
+	})
+	return backups, nil
+}
+
+// parseBackupKey() => reads a backup from its key, relative to the prefix of its server.
+func parseBackupKey(key string) (models.Backup, bool) {
+	backup := models.Backup{Status: models.BackupCompleted}
+	switch {
+	case strings.HasPrefix(key, "requests/"):
+		backup.Status = models.BackupRequested
+		key = strings.TrimPrefix(key, "requests/")
+	case strings.HasPrefix(key, "failed/"):
+		backup.Status = models.BackupFailed
+		key = strings.TrimPrefix(key, "failed/")
+	case strings.HasSuffix(key, ".tar.gz"):
+		key = strings.TrimSuffix(key, ".tar.gz")
+	default:
+		return backup, false
+	}
+	if key == "" || strings.Contains(key, "/") {
+		return backup, false
+	}
+
+	backup.BackupID = key
+	timestamp, rest, _ := strings.Cut(key, "-")
+	backup.Kind, _, _ = strings.Cut(rest, "-")
+	if createdAt, err := time.Parse(backupIDTimeFormat, timestamp); err == nil {
+		backup.CreatedAt = createdAt.Format(time.RFC3339)
+	}
+	return backup, true
+}
+
+/*
+RequestBackup() => asks a running server for a backup. The backup agent of the server picks the request up within a
+minute and uploads the backup under the returned ID; until then it is listed as "requested".
+*/
+func (s *BackupService) RequestBackup(ctx context.Context, instanceID string) (*models.Backup, error) {
+	if !s.Enabled() {
+		return nil, ErrBackupsDisabled
+	}
+	server, err := s.minecraftService.GetServer(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if server.State != "running" {
+		return nil, fmt.Errorf("%w: instance is %s", ErrServerNotRunning, server.State)
+	}
+
+	createdAt := time.Now().UTC()
+	backupID, err := newBackupID(createdAt, "manual")
+	if err != nil {
+		return nil, err
+	}
+	backup := &models.Backup{
+		BackupID:  backupID,
+		Kind:      "manual",
+		Status:    models.BackupRequested,
+		CreatedAt: createdAt.Format(time.RFC3339),
+	}
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(s.serverPrefix(instanceID) + "requests/" + backup.BackupID),
+		Body:   strings.NewReader(""),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to request a backup of %s: %v", instanceID, err)
+	}
+	return backup, nil
+}
+
+// newBackupID() => returns the ID of a new backup (or world download) of the given kind.
+func newBackupID(createdAt time.Time, kind string) (string, error) {
+	suffix := make([]byte, backupIDSuffixBytes)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate a backup ID: %v", err)
+	}
+	return createdAt.UTC().Format(backupIDTimeFormat) + "-" + kind + "-" + hex.EncodeToString(suffix), nil
+}
+
+/*
+instanceSettings() => returns the settings of the backup agent of a server, which ec2-init.sh writes to
+/etc/minecraft-backup.env. The agent is disabled when backups are. The agent always uploads with the instance profile
+of the server (MinecraftServerAutoShutdown): the credentials of the backend reach the whole bucket, they never leave it.
+*/
+func (s *BackupService) instanceSettings(req models.MinecraftServerRequest) backupSettings {
+	if !s.Enabled() {
+		return backupSettings{}
+	}
+	c := s.config
+	return backupSettings{
+		Bucket:           c.Bucket,
+		Prefix:           c.Prefix,
+		Endpoint:         c.Endpoint,
+		Region:           c.Region,
+		IntervalSeconds:  int64(c.Interval / time.Second),
+		LevelName:        req.LevelName,
+		MinecraftVersion: req.Version,
+	}
+}
+
+// shellQuote() => quotes a value for a POSIX shell.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewBackupIDIsUniqueWithinASecond(t *testing.T) {
+	createdAt := time.Date(2026, 1, 15, 18, 0, 0, 0, time.UTC)
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id, err := newBackupID(createdAt, "manual")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[id] {
+			t.Fatalf("backup ID %s generated twice", id)
+		}
+		seen[id] = true
+
+		backup, ok := parseBackupKey(id + ".tar.gz")
+		if !ok || backup.BackupID != id || backup.Kind != "manual" || backup.CreatedAt != "2026-01-15T18:00:00Z" {
+			t.Fatalf("parseBackupKey(%q) = %+v, %t", id, backup, ok)
+		}
+	}
+}
+
+func TestParseBackupKey(t *testing.T) {
+	cases := []struct {
+		key    string
+		ok     bool
+		id     string
+		kind   string
+		status string
+	}{
+		{"20260115T180000Z-scheduled-3f9a1c2e.tar.gz", true, "20260115T180000Z-scheduled-3f9a1c2e", "scheduled", "completed"},
+		{"20260115T180000Z-shutdown.tar.gz", true, "20260115T180000Z-shutdown", "shutdown", "completed"},
+		{"requests/20260115T180000Z-manual-3f9a1c2e", true, "20260115T180000Z-manual-3f9a1c2e", "manual", "requested"},
+		{"failed/20260115T180000Z-manual-3f9a1c2e", true, "20260115T180000Z-manual-3f9a1c2e", "manual", "failed"},
+		{"downloads/20260115T180000Z-download-3f9a1c2e.zip", false, "", "", ""},
+		{"requests/", false, "", "", ""},
+	}
+	for _, c := range cases {
+		backup, ok := parseBackupKey(c.key)
+		if ok != c.ok {
+			t.Errorf("parseBackupKey(%q) ok = %t, want %t", c.key, ok, c.ok)
+			continue
+		}
+		if ok && (backup.BackupID != c.id || backup.Kind != c.kind || string(backup.Status) != c.status) {
+			t.Errorf("parseBackupKey(%q) = %+v", c.key, backup)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+/*
+callback_service.go
+In this file you will find the lifecycle callbacks: the channel from the servers back to the backend. Every server gets
+its own random callback token, kept in the registry secrets, and ec2-init.sh POSTs what happens on the instance (setup
+started, Docker image pulled, container started, Minecraft ready, idle countdown, terminating) to /minecraft/callbacks.
+Each callback is signed with the token (HMAC-SHA256 of "<timestamp>.<body>"), so nobody else can speak for a server;
+accepted callbacks are recorded in the registry and published on the event stream of the server.
+*/
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/storage"
+)
+
+var (
+	// ErrCallbacksDisabled is returned when a callback arrives while no callback URL is configured.
+	ErrCallbacksDisabled = errors.New("callbacks are not configured on this backend (CALLBACK_BASE_URL)")
+	// ErrInvalidCallback is returned (wrapped) for callbacks that are malformed, badly signed, stale or replayed.
+	ErrInvalidCallback = errors.New("invalid callback")
+)
+
+const (
+	callbackTokenSecret = "callback_token" // Name of the callback token in the registry secrets
+	callbackPath        = "/minecraft/callbacks"
+	callbackMaxSkew     = 5 * time.Minute // Callbacks whose timestamp is further from now are rejected
+)
+
+// Known events, with the message published when the server sends none
+var callbackEvents = map[string]string{
+	models.CallbackSetupStarted:     "Server setup started",
+	models.CallbackDockerPulled:     "Minecraft image pulled",
+	models.CallbackContainerStarted: "Minecraft container started",
+	models.CallbackReady:            "Minecraft server is ready",
+	models.CallbackIdleCountdown:    "Server is empty, idle countdown started",
+	models.CallbackTerminating:      "Server is shutting down",
+}
+
+// CallbackService verifies the callbacks of the servers and feeds them to the registry and the event streams.
+type CallbackService struct {
+	url              string // Full URL of the callback endpoint, as the servers reach it; callbacks are disabled when empty
+	minecraftService *MinecraftService
+	broker           *EventBroker
+
+	mu   sync.Mutex
+	seen map[string]time.Time // MACs accepted within callbackMaxSkew, to reject replays
+}
+
+/*
+NewCallbackService() => creates the callback service. baseURL is the public URL of the backend, as the servers reach
+it (e.g. https://api.example.com); when it is set, the servers created from now on by minecraftService send callbacks.
+*/
+func NewCallbackService(baseURL string, minecraftService *MinecraftService, broker *EventBroker) *CallbackService {
+	service := &CallbackService{
+		minecraftService: minecraftService,
+		broker:           broker,
+		seen:             make(map[string]time.Time),
+	}
+	if baseURL != "" {
+		service.url = strings.TrimSuffix(baseURL, "/") + callbackPath
+		minecraftService.callbacks = service
+	}
+	return service
+}
+
+// Enabled() => reports whether a callback URL is configured (a nil service is disabled).
+func (s *CallbackService) Enabled() bool {
+	return s != nil && s.url != ""
+}
+
+/*
+instanceSettings() => returns the callback settings of a server, which ec2-init.sh writes to
+/etc/minecraft-callback.env. Callbacks are disabled on the server when they are on the backend.
+*/
+func (s *CallbackService) instanceSettings(token string) callbackSettings {
+	if !s.Enabled() || token == "" {
+		return callbackSettings{}
+	}
+	return callbackSettings{URL: s.url, Token: token}
+}
+
+/*
+HandleCallback() => verifies a callback (its signature, with the token of the server it claims to come from, and its
+timestamp), then records it in the registry and publishes it on the event stream of the server.
+*/
+func (s *CallbackService) HandleCallback(ctx context.Context, timestamp, signature string, body []byte) (*models.InstanceCallback, error) {
+	if !s.Enabled() {
+		return nil, ErrCallbacksDisabled
+	}
+
+	sentAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad timestamp", ErrInvalidCallback)
+	}
+	if skew := time.Since(time.Unix(sentAt, 0)); skew > callbackMaxSkew || skew < -callbackMaxSkew {
+		return nil, fmt.Errorf("%w: timestamp is too far from now", ErrInvalidCallback)
+	}
+	var callback models.InstanceCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCallback, err)
+	}
+	if callback.InstanceID == "" || callback.SentAt != sentAt {
+		return nil, fmt.Errorf("%w: instance_id and sent_at are required", ErrInvalidCallback)
+	}
+	defaultMessage, known := callbackEvents[callback.Event]
+	if !known {
+		return nil, fmt.Errorf("%w: unknown event %q", ErrInvalidCallback, callback.Event)
+	}
+
+	// Unknown servers and bad signatures get the same answer
+	token, err := s.minecraftService.registry.Secret(ctx, callback.InstanceID, callbackTokenSecret)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidCallback)
+	}
+	if err != nil {
+		return nil, err
+	}
+	mac, valid := verifyCallbackSignature(token, timestamp, body, signature)
+	if !valid {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidCallback)
+	}
+	if !s.firstSeen(mac) {
+		return nil, fmt.Errorf("%w: already received", ErrInvalidCallback)
+	}
+
+	if err := s.minecraftService.registry.RecordCallback(ctx, callback.InstanceID, callback.Event); err != nil {
+		log.Printf("Warning: failed to record callback %q of %s: %v", callback.Event, callback.InstanceID, err)
+	}
+	message := callback.Message
+	if message == "" {
+		message = defaultMessage
+	}
+	s.broker.Publish(callback.InstanceID, models.ServerEvent{
+		Type:       models.EventCallback,
+		Stage:      callback.Event,
+		Message:    message,
+		InstanceID: callback.InstanceID,
+	})
+	return &callback, nil
+}
+
+/*
+verifyCallbackSignature() => checks a signature ("sha256=<hex>") of "<timestamp>.<body>", in constant time, and returns
+the MAC it carries. The hex encoding of a signature may vary (upper case digits): its MAC is what identifies a callback.
+*/
+func verifyCallbackSignature(token, timestamp string, body []byte, signature string) ([]byte, bool) {
+	received, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return nil, false
+	}
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return received, hmac.Equal(received, mac.Sum(nil))
+}
+
+// firstSeen() => remembers the MAC of a callback, and reports whether it was not seen before (within callbackMaxSkew).
+func (s *CallbackService) firstSeen(mac []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for seen, at := range s.seen {
+		if now.Sub(at) > 2*callbackMaxSkew {
+			delete(s.seen, seen)
+		}
+	}
+	key := string(mac)
+	if _, replayed := s.seen[key]; replayed {
+		return false
+	}
+	s.seen[key] = now
+	return true
+}
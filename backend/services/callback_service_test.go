@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/storage"
+)
+
+// newTestCallbackService() => returns a callback service knowing the callback token of instanceID.
+func newTestCallbackService(t *testing.T, instanceID, token string) *CallbackService {
+	t.Helper()
+	t.Setenv("AWS_REGION", "us-east-1")
+	registry, err := storage.NewSQLiteRegistry(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { registry.Close() })
+	if err := registry.SaveSecret(context.Background(), instanceID, callbackTokenSecret, token); err != nil {
+		t.Fatal(err)
+	}
+	minecraftService := NewMinecraftService(NewEC2ServiceWithClient(NewFakeEC2("us-east-1")), registry)
+	return NewCallbackService("https://backend.example.com", minecraftService, NewEventBroker())
+}
+
+// signCallback() => returns the timestamp, body and signature of a callback, as ec2-init.sh sends it.
+func signCallback(t *testing.T, token string, callback models.InstanceCallback) (string, []byte, string) {
+	t.Helper()
+	body, err := json.Marshal(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := strconv.FormatInt(callback.SentAt, 10)
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return timestamp, body, "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHandleCallback(t *testing.T) {
+	const instanceID, token = "i-0123456789abcdef0", "callback-token"
+	ctx := context.Background()
+	now := time.Now().Unix()
+	callback := func(event string, sentAt int64) models.InstanceCallback {
+		return models.InstanceCallback{InstanceID: instanceID, Event: event, SentAt: sentAt}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		service := newTestCallbackService(t, instanceID, token)
+		timestamp, body, signature := signCallback(t, token, callback(models.CallbackReady, now))
+		if _, err := service.HandleCallback(ctx, timestamp, signature, body); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	cases := []struct {
+		name   string
+		mangle func(timestamp string, body []byte, signature string) (string, []byte, string)
+	}{
+		{"wrong token", func(ts string, body []byte, _ string) (string, []byte, string) {
+			_, _, signature := signCallback(t, "other-token", callback(models.CallbackReady, now))
+			return ts, body, signature
+		}},
+		{"changed body", func(ts string, body []byte, sig string) (string, []byte, string) {
+			return ts, []byte(strings.Replace(string(body), models.CallbackReady, models.CallbackTerminating, 1)), sig
+		}},
+		{"not hex", func(ts string, body []byte, _ string) (string, []byte, string) { return ts, body, "sha256=zz" }},
+		{"timestamp of another callback", func(_ string, body []byte, sig string) (string, []byte, string) {
+			return strconv.FormatInt(now+1, 10), body, sig
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			service := newTestCallbackService(t, instanceID, token)
+			timestamp, body, signature := c.mangle(signCallback(t, token, callback(models.CallbackReady, now)))
+			if _, err := service.HandleCallback(ctx, timestamp, signature, body); !errors.Is(err, ErrInvalidCallback) {
+				t.Fatalf("HandleCallback() error = %v, want ErrInvalidCallback", err)
+			}
+		})
+	}
+
+	t.Run("stale", func(t *testing.T) {
+		service := newTestCallbackService(t, instanceID, token)
+		timestamp, body, signature := signCallback(t, token, callback(models.CallbackReady, now-int64(2*callbackMaxSkew/time.Second)))
+		if _, err := service.HandleCallback(ctx, timestamp, signature, body); !errors.Is(err, ErrInvalidCallback) {
+			t.Fatalf("HandleCallback() error = %v, want ErrInvalidCallback", err)
+		}
+	})
+}
+
+// TestHandleCallbackReplay checks that a callback is accepted once, whatever the hex encoding of its signature.
+func TestHandleCallbackReplay(t *testing.T) {
+	const instanceID, token = "i-0123456789abcdef0", "callback-token"
+	ctx := context.Background()
+	service := newTestCallbackService(t, instanceID, token)
+
+	timestamp, body, signature := signCallback(t, token, models.InstanceCallback{
+		InstanceID: instanceID, Event: models.CallbackReady, SentAt: time.Now().Unix(),
+	})
+	if _, err := service.HandleCallback(ctx, timestamp, signature, body); err != nil {
+		t.Fatal(err)
+	}
+	for _, replay := range []string{signature, "sha256=" + strings.ToUpper(strings.TrimPrefix(signature, "sha256="))} {
+		if _, err := service.HandleCallback(ctx, timestamp, replay, body); !errors.Is(err, ErrInvalidCallback) {
+			t.Fatalf("replay with signature %s: error = %v, want ErrInvalidCallback", replay, err)
+		}
+	}
+}
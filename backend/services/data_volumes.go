@@ -0,0 +1,140 @@
+/*
+data_volumes.go
+In this file you will find the dedicated EBS data volumes of the Minecraft servers. When a server asks for one, a
+volume tagged Type=MinecraftWorld is created in the zone of the instance and attached to it; ec2-init.sh mounts it at
+/opt/minecraft-data, where the world lives. Unlike the root volume it is not deleted on termination, so a new server
+launched with its data_volume_id (in the same zone) starts with the same world.
+*/
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+const (
+	worldVolumeTagValue  = "MinecraftWorld" // Type tag of the data volumes
+	dataVolumeDevice     = "/dev/sdf"       // Device name of the data volume, as ec2-init.sh expects it
+	maxDataVolumeSizeGiB = 100
+)
+
+/*
+checkDataVolume() => checks that an existing data volume can be given to a new server of ownerID (empty for admins):
+it must be a world volume created by the generator, belong to the same user and not be attached anywhere.
+Returns its availability zone, where the new instance must be launched, and the level name of the world it holds.
+*/
+func (s *MinecraftService) checkDataVolume(ctx context.Context, volumeID, ownerID string) (string, string, error) {
+	result, err := s.ec2Service.client.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		VolumeIds: []string{volumeID},
+	})
+	if err != nil || len(result.Volumes) == 0 {
+		return "", "", fmt.Errorf("%w: data volume %s not found", ErrInvalidServerRequest, volumeID)
+	}
+	volume := result.Volumes[0]
+
+	tags := make(map[string]string, len(volume.Tags))
+	for _, tag := range volume.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	if tags[serverTypeTagKey] != worldVolumeTagValue || tags[createdByTagKey] != createdByTagValue {
+		return "", "", fmt.Errorf("%w: volume %s is not a Minecraft world volume", ErrInvalidServerRequest, volumeID)
+	}
+	if ownerID != "" && tags[ownerTagKey] != ownerID {
+		return "", "", fmt.Errorf("%w: data volume %s", ErrServerAccessDenied, volumeID)
+	}
+	if volume.State != types.VolumeStateAvailable {
+		return "", "", fmt.Errorf("%w: data volume %s is %s, it must be detached from its previous server first (terminate it)",
+			ErrInvalidServerRequest, volumeID, volume.State)
+	}
+	return aws.ToString(volume.AvailabilityZone), tags["LevelName"], nil
+}
+
+/*
+attachDataVolume() => attaches the data volume of a request to a running instance, creating the volume first unless
+the request reuses an existing one. Returns the volume ID. A volume created here is deleted again when it cannot be
+attached (the ID is then empty): nothing else would ever use this new, empty volume. A reused one is left alone.
+*/
+func (s *MinecraftService) attachDataVolume(ctx context.Context, instance types.Instance, req models.MinecraftServerRequest) (string, error) {
+	instanceID := aws.ToString(instance.InstanceId)
+	volumeID := req.DataVolumeID
+
+	if volumeID == "" {
+		tags := []types.Tag{
+			{Key: aws.String("Name"), Value: aws.String(req.ServerName + " world")},
+			{Key: aws.String(serverTypeTagKey), Value: aws.String(worldVolumeTagValue)},
+			{Key: aws.String(createdByTagKey), Value: aws.String(createdByTagValue)},
+			{Key: aws.String("CreatedAt"), Value: aws.String(time.Now().UTC().Format(time.RFC3339))},
+			{Key: aws.String("LevelName"), Value: aws.String(req.LevelName)},
+		}
+		if req.OwnerID != "" {
+			tags = append(tags, types.Tag{Key: aws.String(ownerTagKey), Value: aws.String(req.OwnerID)})
+		}
+
+		result, err := s.ec2Service.client.CreateVolume(ctx, &ec2.CreateVolumeInput{
+			AvailabilityZone: instance.Placement.AvailabilityZone,
+			Size:             aws.Int32(int32(req.DataVolumeSizeGiB)),
+			VolumeType:       types.VolumeTypeGp3,
+			TagSpecifications: []types.TagSpecification{
+				{ResourceType: types.ResourceTypeVolume, Tags: tags},
+			},
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to create data volume: %v", err)
+		}
+		volumeID = aws.ToString(result.VolumeId)
+		log.Printf("Data volume %s created for %s. Waiting for it to be available...", volumeID, instanceID)
+
+		if err := s.ec2Service.waitForVolumeAvailable(ctx, volumeID, 2*time.Minute); err != nil {
+			return s.deleteLaunchVolume(volumeID, fmt.Errorf("data volume %s is not available: %v", volumeID, err))
+		}
+	}
+
+	_, err := s.ec2Service.client.AttachVolume(ctx, &ec2.AttachVolumeInput{
+		Device:     aws.String(dataVolumeDevice),
+		InstanceId: aws.String(instanceID),
+		VolumeId:   aws.String(volumeID),
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to attach data volume %s: %v", volumeID, err)
+		if req.DataVolumeID == "" {
+			return s.deleteLaunchVolume(volumeID, err)
+		}
+		return volumeID, err
+	}
+	log.Printf("Data volume %s attached to %s", volumeID, instanceID)
+	return volumeID, nil
+}
+
+/*
+deleteLaunchVolume() => deletes the volume a failed launch created, and returns cause. The volume ID is returned too
+when the deletion fails, so that it is recorded with the server: the GarbageCollector deletes it after its retention.
+*/
+func (s *MinecraftService) deleteLaunchVolume(volumeID string, cause error) (string, error) {
+	// The request context may be what failed: clean up regardless, as abandonLaunch() does
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := s.ec2Service.client.DeleteVolume(ctx, &ec2.DeleteVolumeInput{VolumeId: aws.String(volumeID)}); err != nil {
+		log.Printf("Warning: failed to delete data volume %s of a failed launch, it is left to the garbage collector: %v", volumeID, err)
+		return volumeID, cause
+	}
+	log.Printf("Deleted data volume %s of a failed launch", volumeID)
+	return "", cause
+}
+
+// dataVolumeOf() => returns the ID of the data volume attached to an instance, if any.
+func dataVolumeOf(instance types.Instance) string {
+	for _, mapping := range instance.BlockDeviceMappings {
+		if aws.ToString(mapping.DeviceName) == dataVolumeDevice && mapping.Ebs != nil {
+			return aws.ToString(mapping.Ebs.VolumeId)
+		}
+	}
+	return ""
+}
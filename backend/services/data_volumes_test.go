@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/storage"
+)
+
+// TestAttachDataVolumeFailure checks that a volume created for a launch is deleted when it cannot be attached, and
+// that a volume the request reuses is kept.
+func TestAttachDataVolumeFailure(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("AWS_REGION", "us-east-1")
+	fake := NewFakeEC2("us-east-1")
+	registry, err := storage.NewSQLiteRegistry(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { registry.Close() })
+	minecraftService := NewMinecraftService(NewEC2ServiceWithClient(fake), registry)
+
+	output, err := fake.RunInstances(ctx, &ec2.RunInstancesInput{ImageId: aws.String("ami-0fa4e00000000000a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	described, err := fake.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{*output.Instances[0].InstanceId}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The volume is created in the zone the instance claims to be in, and AttachVolume refuses another zone
+	instance := described.Reservations[0].Instances[0]
+	instance.Placement = &types.Placement{AvailabilityZone: aws.String("us-east-1b")}
+
+	volumes := func() []types.Volume {
+		result, err := fake.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Volumes
+	}
+
+	req := testServerRequest()
+	req.DataVolume, req.DataVolumeSizeGiB = true, 10
+	volumeID, err := minecraftService.attachDataVolume(ctx, instance, req)
+	if err == nil {
+		t.Fatal("attachDataVolume() attached a volume of another zone")
+	}
+	if volumeID != "" || len(volumes()) != 0 {
+		t.Fatalf("attachDataVolume() = %q, volumes %+v: the new volume must be deleted", volumeID, volumes())
+	}
+
+	// A volume the request reuses holds a world: it is kept
+	created, err := fake.CreateVolume(ctx, &ec2.CreateVolumeInput{AvailabilityZone: aws.String("us-east-1b"), Size: aws.Int32(10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.DataVolumeID = aws.ToString(created.VolumeId)
+	volumeID, err = minecraftService.attachDataVolume(ctx, instance, req)
+	if err == nil {
+		t.Fatal("attachDataVolume() attached a volume of another zone")
+	}
+	if volumeID != req.DataVolumeID || len(volumes()) != 1 {
+		t.Fatalf("attachDataVolume() = %q, volumes %+v: the reused volume must be kept", volumeID, volumes())
+	}
+}
@@ -7,6 +7,7 @@ package services
 //Importing all the necessary libraries.
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"log"
 	"os"
@@ -21,9 +22,10 @@ import (
 
 //Defining the EC2Service struct.
 type EC2Service struct {
-	client     *ec2.Client
-	cfg        aws.Config
-	defaultAMI string // Cached latest AMI ID
+	client       EC2API        // Real *ec2.Client in production, FakeEC2 when running offline
+	cfg          aws.Config
+	defaultAMI   string        // Cached latest AMI ID
+	pollInterval time.Duration // Delay between waiter polls (0 = AWS SDK defaults)
 }
 
 // NewEC2Service() => Creates a new EC2 service instance
@@ -46,9 +48,22 @@ func NewEC2Service() (*EC2Service, error) {
 
 	//Creating an instance of struct EC2Service and setting the previously obtained info as 
 	//values in the struct.
+	service := NewEC2ServiceWithClient(client)
+	service.cfg = cfg
+
+	//Return either the service (the initialized struct EC2Service) or an error if the instance could not be created successfully.
+	return service, nil
+}
+
+/*
+NewEC2ServiceWithClient() => Creates a new EC2 service on top of any EC2API implementation. It is used with the
+real AWS client by NewEC2Service() and with FakeEC2 when the backend runs against the in-memory fake cloud.
+*/
+func NewEC2ServiceWithClient(client EC2API) *EC2Service {
+	ctx := context.TODO()
+
 	service := &EC2Service{
 		client: client,
-		cfg:    cfg,
 	}
 
 	// Fetch the latest AMI on startup (unless overridden by environment) (Amazon Linux)
@@ -59,8 +74,38 @@ func NewEC2Service() (*EC2Service, error) {
 	//Printing a log to the terminal confirming that the EC2 instance was initialized with the specified AMI (Amazon Linux).
 	log.Printf("EC2 Service initialized with AMI: %s", service.defaultAMI)
 
-	//Return either the service (the initialized struct EC2Service) or an error if the instance could not be created successfully.
-	return service, nil
+	return service
+}
+
+// SetPollInterval() => overrides the delay between DescribeInstances calls made while waiting for an instance.
+// Useful with FakeEC2, where waiting the AWS default of 15 seconds between polls is pointless.
+func (s *EC2Service) SetPollInterval(interval time.Duration) {
+	s.pollInterval = interval
+}
+
+// waitForInstanceRunning() => blocks until the instance reaches the "running" state or maxWait is exceeded.
+func (s *EC2Service) waitForInstanceRunning(ctx context.Context, instanceID string, maxWait time.Duration) error {
+	waiter := ec2.NewInstanceRunningWaiter(s.client, func(o *ec2.InstanceRunningWaiterOptions) {
+		if s.pollInterval > 0 {
+			o.MinDelay = s.pollInterval
+			o.MaxDelay = s.pollInterval
+		}
+	})
+	waitInput := &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	}
+	return waiter.Wait(ctx, waitInput, maxWait)
+}
+
+// waitForVolumeAvailable() => blocks until the EBS volume reaches the "available" state or maxWait is exceeded.
+func (s *EC2Service) waitForVolumeAvailable(ctx context.Context, volumeID string, maxWait time.Duration) error {
+	waiter := ec2.NewVolumeAvailableWaiter(s.client, func(o *ec2.VolumeAvailableWaiterOptions) {
+		if s.pollInterval > 0 {
+			o.MinDelay = s.pollInterval
+			o.MaxDelay = s.pollInterval
+		}
+	})
+	return waiter.Wait(ctx, &ec2.DescribeVolumesInput{VolumeIds: []string{volumeID}}, maxWait)
 }
 
 // CreateAndStartInstance() =>  creates a new EC2 instance and starts it
@@ -138,14 +183,8 @@ func (s *EC2Service) CreateAndStartInstance(req models.EC2InstanceRequest) (*mod
 	//Log to the terminal if an EC2 instance was created successfully.
 	log.Printf("Instance created with ID: %s. Waiting for it to start...", instanceID)
 
-	// Wait for the instance to be running
-	waiter := ec2.NewInstanceRunningWaiter(s.client)
-	waitInput := &ec2.DescribeInstancesInput{
-		InstanceIds: []string{instanceID},
-	}
-
 	// Wait up to 5 minutes for instance to be running
-	err = waiter.Wait(ctx, waitInput, 5*time.Minute)
+	err = s.waitForInstanceRunning(ctx, instanceID, 5*time.Minute)
 	if err != nil {
 		return nil, fmt.Errorf("instance failed to start: %v", err)
 	}
@@ -187,6 +226,14 @@ func (s *EC2Service) CreateAndStartInstance(req models.EC2InstanceRequest) (*mod
 	return response, nil
 }
 
+// Region() => returns the region the EC2 client works in (the fake cloud reports AWS_REGION), empty when unknown.
+func (s *EC2Service) Region() string {
+	if s.cfg.Region != "" {
+		return s.cfg.Region
+	}
+	return getAWSRegion()
+}
+
 // getAWSRegion() => returns the AWS region from environment or defaults to us-east-1
 func getAWSRegion() string {
 	region := os.Getenv("AWS_REGION")
@@ -310,32 +357,84 @@ func (s *EC2Service) GetInstanceInfo(instanceID string) (*models.EC2InstanceResp
 func (s *EC2Service) ListAllInstances() ([]models.EC2InstanceResponse, error) {
 	ctx := context.TODO()
 
-	describeInput := &ec2.DescribeInstancesInput{}
-
-	result, err := s.client.DescribeInstances(ctx, describeInput)
+	allInstances, err := s.describeInstancesPages(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list instances: %v", err)
 	}
 
 	var instances []models.EC2InstanceResponse
 
-	for _, reservation := range result.Reservations {
-		for _, instance := range reservation.Instances {
-			instances = append(instances, models.EC2InstanceResponse{
-				InstanceID:       aws.ToString(instance.InstanceId),
-				PublicIP:         aws.ToString(instance.PublicIpAddress),
-				PrivateIP:        aws.ToString(instance.PrivateIpAddress),
-				State:            string(instance.State.Name),
-				InstanceType:     string(instance.InstanceType),
-				LaunchTime:       instance.LaunchTime.Format(time.RFC3339),
-				AvailabilityZone: aws.ToString(instance.Placement.AvailabilityZone),
-			})
-		}
+	for _, instance := range allInstances {
+		instances = append(instances, models.EC2InstanceResponse{
+			InstanceID:       aws.ToString(instance.InstanceId),
+			PublicIP:         aws.ToString(instance.PublicIpAddress),
+			PrivateIP:        aws.ToString(instance.PrivateIpAddress),
+			State:            string(instance.State.Name),
+			InstanceType:     string(instance.InstanceType),
+			LaunchTime:       instance.LaunchTime.Format(time.RFC3339),
+			AvailabilityZone: aws.ToString(instance.Placement.AvailabilityZone),
+		})
 	}
 
 	return instances, nil
 }
 
+// describeInstancesPages() => returns every instance matching the filters, following NextToken across all the pages.
+func (s *EC2Service) describeInstancesPages(ctx context.Context, filters []types.Filter) ([]types.Instance, error) {
+	paginator := ec2.NewDescribeInstancesPaginator(s.client, &ec2.DescribeInstancesInput{
+		Filters:    filters,
+		MaxResults: aws.Int32(100),
+	})
+
+	var instances []types.Instance
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, reservation := range page.Reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+	}
+	return instances, nil
+}
+
+// describeVolumesPages() => returns every volume matching the filters, following NextToken across all the pages.
+func (s *EC2Service) describeVolumesPages(ctx context.Context, filters []types.Filter) ([]types.Volume, error) {
+	paginator := ec2.NewDescribeVolumesPaginator(s.client, &ec2.DescribeVolumesInput{
+		Filters:    filters,
+		MaxResults: aws.Int32(100),
+	})
+
+	var volumes []types.Volume
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, page.Volumes...)
+	}
+	return volumes, nil
+}
+
+// getConsoleOutput() => returns the (decoded) serial console output of an instance. It is empty until the
+// instance has printed something and AWS has captured it.
+func (s *EC2Service) getConsoleOutput(ctx context.Context, instanceID string) (string, error) {
+	result, err := s.client.GetConsoleOutput(ctx, &ec2.GetConsoleOutputInput{
+		InstanceId: aws.String(instanceID),
+		Latest:     aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get console output: %v", err)
+	}
+
+	output, err := base64.StdEncoding.DecodeString(aws.ToString(result.Output))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode console output: %v", err)
+	}
+	return string(output), nil
+}
+
 // StopInstance stops a running EC2 instance
 func (s *EC2Service) StopInstance(instanceID string) error {
 	ctx := context.TODO()
@@ -0,0 +1,186 @@
+/*
+event_broker.go
+In this file you will find the EventBroker, a small in-memory publish/subscribe hub for server events.
+Every stream (topic) keeps a bounded history, so a client that subscribes late (or reconnects with the SSE
+Last-Event-ID header) still receives what happened before. A provisioning job publishes under its job ID;
+once the instance exists, its instance ID becomes an alias of the same stream.
+*/
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+const (
+	eventHistorySize     = 200            // Events kept per stream for late subscribers
+	eventSubscriberQueue = 64             // Events buffered per subscriber before it is considered too slow
+	eventTopicRetention  = 24 * time.Hour // Idle streams without subscribers are forgotten after this long
+)
+
+// EventBroker fans out server events to the subscribers of each stream.
+type EventBroker struct {
+	mu      sync.Mutex
+	topics  map[string]*eventTopic
+	aliases map[string]string // alias (instance ID) -> topic (job ID)
+}
+
+// eventTopic is a single event stream with its history and current subscribers.
+type eventTopic struct {
+	nextID      int64
+	history     []models.ServerEvent
+	subscribers map[chan models.ServerEvent]struct{}
+	lastActive  time.Time
+}
+
+// NewEventBroker() => creates an empty broker.
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		topics:  make(map[string]*eventTopic),
+		aliases: make(map[string]string),
+	}
+}
+
+// resolve() => follows an alias to the stream it points to. Must be called with b.mu held.
+func (b *EventBroker) resolve(topic string) string {
+	if target, ok := b.aliases[topic]; ok {
+		return target
+	}
+	return topic
+}
+
+// topic() => returns (creating it if needed) the stream with the given name. Must be called with b.mu held.
+func (b *EventBroker) topic(name string) *eventTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &eventTopic{subscribers: make(map[chan models.ServerEvent]struct{})}
+		b.topics[name] = t
+	}
+	t.lastActive = time.Now()
+	return t
+}
+
+// Alias() => makes alias (e.g. an instance ID) refer to the existing stream topic (e.g. a job ID).
+func (b *EventBroker) Alias(alias, topic string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if alias == "" || alias == topic {
+		return
+	}
+	b.aliases[alias] = b.resolve(topic)
+}
+
+// Publish() => records an event in a stream and delivers it to every subscriber.
+// Subscribers that cannot keep up are disconnected; they can reconnect and resume with Last-Event-ID.
+func (b *EventBroker) Publish(topic string, event models.ServerEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pruneIdleTopics()
+	t := b.topic(b.resolve(topic))
+	t.nextID++
+	event.ID = t.nextID
+	if event.At == "" {
+		event.At = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	t.history = append(t.history, event)
+	if len(t.history) > eventHistorySize {
+		t.history = t.history[len(t.history)-eventHistorySize:]
+	}
+
+	for ch := range t.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(t.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+/*
+Subscribe() => returns a channel with every event of the stream published after lastEventID (0 = the whole
+history), followed by the live events. The returned function must be called to unsubscribe.
+*/
+func (b *EventBroker) Subscribe(topic string, lastEventID int64) (<-chan models.ServerEvent, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name := b.resolve(topic)
+	t := b.topic(name)
+
+	ch := make(chan models.ServerEvent, eventSubscriberQueue+eventHistorySize)
+	for _, event := range t.history {
+		if event.ID > lastEventID {
+			ch <- event
+		}
+	}
+	t.subscribers[ch] = struct{}{}
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := t.subscribers[ch]; ok {
+			delete(t.subscribers, ch)
+			close(ch)
+		}
+		t.lastActive = time.Now()
+	}
+	return ch, unsubscribe
+}
+
+// InstanceOf() => returns the instance ID behind a stream name: the name itself for an instance alias, the aliased
+// instance for a job stream, or "" if the stream has no instance yet.
+func (b *EventBroker) InstanceOf(topic string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.aliases[topic]; ok {
+		return topic
+	}
+	for alias, target := range b.aliases {
+		if target == topic {
+			return alias
+		}
+	}
+	return ""
+}
+
+// Exists() => reports whether a stream (or an alias to one) has ever received events.
+func (b *EventBroker) Exists(topic string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t, ok := b.topics[b.resolve(topic)]
+	return ok && len(t.history) > 0
+}
+
+// Subscribers() => returns how many clients are currently listening to a stream.
+func (b *EventBroker) Subscribers(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t, ok := b.topics[b.resolve(topic)]
+	if !ok {
+		return 0
+	}
+	return len(t.subscribers)
+}
+
+// pruneIdleTopics() => forgets streams nobody listened to or published on for a while. Must be called with b.mu held.
+func (b *EventBroker) pruneIdleTopics() {
+	for name, t := range b.topics {
+		if len(t.subscribers) == 0 && time.Since(t.lastActive) > eventTopicRetention {
+			delete(b.topics, name)
+			for alias, target := range b.aliases {
+				if target == name {
+					delete(b.aliases, alias)
+				}
+			}
+		}
+	}
+}
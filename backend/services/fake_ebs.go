@@ -0,0 +1,256 @@
+/*
+fake_ebs.go
+In this file you will find the EBS part of FakeEC2: volumes are created in an availability zone ("creating", then
+"available" after BootDelay), attached to an instance of the same zone ("in-use") and released when that instance is
+terminated, like a real volume attached with DeleteOnTermination=false. This is what lets the data volumes holding
+Minecraft worlds be exercised offline.
+*/
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// fakeVolume wraps the SDK volume with the time it entered its current state.
+type fakeVolume struct {
+	volume     types.Volume
+	stateSince time.Time
+}
+
+// advanceVolume moves a volume from "creating" to "available" once BootDelay has elapsed, and advances the instance it
+// is attached to (which releases it once terminated). It must be called with f.mu held.
+func (f *FakeEC2) advanceVolume(fv *fakeVolume) {
+	if len(fv.volume.Attachments) > 0 {
+		if fi, ok := f.instances[aws.ToString(fv.volume.Attachments[0].InstanceId)]; ok {
+			f.advance(fi)
+		}
+	}
+	if fv.volume.State == types.VolumeStateCreating && f.Now().Sub(fv.stateSince) >= f.BootDelay {
+		fv.volume.State = types.VolumeStateAvailable
+		fv.stateSince = f.Now()
+	}
+}
+
+// releaseVolumes detaches the volumes of a terminated instance, which become "available" again. It must be called with f.mu held.
+func (f *FakeEC2) releaseVolumes(fi *fakeInstance) {
+	instanceID := aws.ToString(fi.instance.InstanceId)
+	for _, fv := range f.volumes {
+		if len(fv.volume.Attachments) > 0 && aws.ToString(fv.volume.Attachments[0].InstanceId) == instanceID {
+			fv.volume.Attachments = nil
+			fv.volume.State = types.VolumeStateAvailable
+			fv.stateSince = f.Now()
+		}
+	}
+	fi.instance.BlockDeviceMappings = nil
+}
+
+// CreateVolume creates a volume in the given availability zone, with the tags of its "volume" tag specifications.
+func (f *FakeEC2) CreateVolume(ctx context.Context, params *ec2.CreateVolumeInput, optFns ...func(*ec2.Options)) (*ec2.CreateVolumeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	zone := aws.ToString(params.AvailabilityZone)
+	if !strings.HasPrefix(zone, f.region) {
+		return nil, fakeAPIError("InvalidParameterValue", "Invalid availability zone: [%s]", zone)
+	}
+	size := aws.ToInt32(params.Size)
+	if size < 1 || size > 16384 {
+		return nil, fakeAPIError("InvalidParameterValue", "Volume size must be between 1 and 16384 GiB")
+	}
+
+	var tags []types.Tag
+	for _, spec := range params.TagSpecifications {
+		if spec.ResourceType == types.ResourceTypeVolume {
+			tags = append(tags, spec.Tags...)
+		}
+	}
+	volumeType := params.VolumeType
+	if volumeType == "" {
+		volumeType = types.VolumeTypeGp2
+	}
+
+	volumeID := f.nextID("vol")
+	fv := &fakeVolume{
+		volume: types.Volume{
+			VolumeId:         aws.String(volumeID),
+			AvailabilityZone: aws.String(zone),
+			Size:             aws.Int32(size),
+			VolumeType:       volumeType,
+			State:            types.VolumeStateCreating,
+			CreateTime:       aws.Time(f.Now()),
+			Tags:             append([]types.Tag(nil), tags...),
+		},
+		stateSince: f.Now(),
+	}
+	f.volumes[volumeID] = fv
+	f.volumeOrder = append(f.volumeOrder, volumeID)
+
+	return &ec2.CreateVolumeOutput{
+		VolumeId:         fv.volume.VolumeId,
+		AvailabilityZone: fv.volume.AvailabilityZone,
+		Size:             fv.volume.Size,
+		VolumeType:       fv.volume.VolumeType,
+		State:            fv.volume.State,
+		CreateTime:       fv.volume.CreateTime,
+		Tags:             fv.volume.Tags,
+	}, nil
+}
+
+// DescribeVolumes returns the volumes matching the given IDs and filters.
+// Supported filters: volume-id, status, availability-zone, attachment.instance-id and tag:<key>.
+func (f *FakeEC2) DescribeVolumes(ctx context.Context, params *ec2.DescribeVolumesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVolumesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids := f.volumeOrder
+	if len(params.VolumeIds) > 0 {
+		for _, id := range params.VolumeIds {
+			if _, ok := f.volumes[id]; !ok {
+				return nil, fakeAPIError("InvalidVolume.NotFound", "The volume '%s' does not exist.", id)
+			}
+		}
+		ids = params.VolumeIds
+	}
+
+	output := &ec2.DescribeVolumesOutput{}
+	for _, id := range ids {
+		fv := f.volumes[id]
+		f.advanceVolume(fv)
+		if matchesVolumeFilters(fv.volume, params.Filters) {
+			output.Volumes = append(output.Volumes, fv.volume)
+		}
+	}
+	return output, nil
+}
+
+// matchesVolumeFilters checks a volume against DescribeVolumes filters, with the same semantics as matchesInstanceFilters.
+func matchesVolumeFilters(volume types.Volume, filters []types.Filter) bool {
+	for _, filter := range filters {
+		name := aws.ToString(filter.Name)
+		var actual []string
+		switch {
+		case name == "volume-id":
+			actual = []string{aws.ToString(volume.VolumeId)}
+		case name == "status":
+			actual = []string{string(volume.State)}
+		case name == "availability-zone":
+			actual = []string{aws.ToString(volume.AvailabilityZone)}
+		case name == "attachment.instance-id":
+			for _, attachment := range volume.Attachments {
+				actual = append(actual, aws.ToString(attachment.InstanceId))
+			}
+		case strings.HasPrefix(name, "tag:"):
+			key := strings.TrimPrefix(name, "tag:")
+			for _, tag := range volume.Tags {
+				if aws.ToString(tag.Key) == key {
+					actual = append(actual, aws.ToString(tag.Value))
+				}
+			}
+		default:
+			return false
+		}
+		if !containsAny(actual, filter.Values) {
+			return false
+		}
+	}
+	return true
+}
+
+// AttachVolume attaches an available volume to an instance of the same availability zone under the given device name.
+func (f *FakeEC2) AttachVolume(ctx context.Context, params *ec2.AttachVolumeInput, optFns ...func(*ec2.Options)) (*ec2.AttachVolumeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	volumeID := aws.ToString(params.VolumeId)
+	fv, ok := f.volumes[volumeID]
+	if !ok {
+		return nil, fakeAPIError("InvalidVolume.NotFound", "The volume '%s' does not exist.", volumeID)
+	}
+	instanceID := aws.ToString(params.InstanceId)
+	fi, ok := f.instances[instanceID]
+	if !ok {
+		return nil, fakeAPIError("InvalidInstanceID.NotFound", "The instance ID '%s' does not exist", instanceID)
+	}
+	f.advanceVolume(fv)
+	f.advance(fi)
+
+	if fv.volume.State != types.VolumeStateAvailable {
+		return nil, fakeAPIError("VolumeInUse", "vol '%s' is not 'available'. It is in the '%s' state.", volumeID, fv.volume.State)
+	}
+	switch fi.instance.State.Name {
+	case types.InstanceStateNameRunning, types.InstanceStateNameStopped:
+	default:
+		return nil, fakeAPIError("IncorrectState", "Instance '%s' is not 'running' or 'stopped'.", instanceID)
+	}
+	if aws.ToString(fv.volume.AvailabilityZone) != aws.ToString(fi.instance.Placement.AvailabilityZone) {
+		return nil, fakeAPIError("InvalidVolume.ZoneMismatch", "The volume '%s' is not in the same availability zone as instance '%s'", volumeID, instanceID)
+	}
+	device := aws.ToString(params.Device)
+	for _, mapping := range fi.instance.BlockDeviceMappings {
+		if aws.ToString(mapping.DeviceName) == device {
+			return nil, fakeAPIError("InvalidParameterValue", "Invalid value '%s' for unixDevice. Attachment point %s is already in use", device, device)
+		}
+	}
+
+	attachTime := aws.Time(f.Now())
+	attachment := types.VolumeAttachment{
+		VolumeId:            aws.String(volumeID),
+		InstanceId:          aws.String(instanceID),
+		Device:              aws.String(device),
+		State:               types.VolumeAttachmentStateAttached,
+		AttachTime:          attachTime,
+		DeleteOnTermination: aws.Bool(false),
+	}
+	fv.volume.Attachments = []types.VolumeAttachment{attachment}
+	fv.volume.State = types.VolumeStateInUse
+	fv.stateSince = f.Now()
+	fi.instance.BlockDeviceMappings = append(fi.instance.BlockDeviceMappings, types.InstanceBlockDeviceMapping{
+		DeviceName: aws.String(device),
+		Ebs: &types.EbsInstanceBlockDevice{
+			VolumeId:            aws.String(volumeID),
+			Status:              types.AttachmentStatusAttached,
+			AttachTime:          attachTime,
+			DeleteOnTermination: aws.Bool(false),
+		},
+	})
+
+	return &ec2.AttachVolumeOutput{
+		VolumeId:   attachment.VolumeId,
+		InstanceId: attachment.InstanceId,
+		Device:     attachment.Device,
+		State:      attachment.State,
+		AttachTime: attachment.AttachTime,
+	}, nil
+}
+
+// DeleteVolume deletes a volume, unless it is attached to an instance.
+func (f *FakeEC2) DeleteVolume(ctx context.Context, params *ec2.DeleteVolumeInput, optFns ...func(*ec2.Options)) (*ec2.DeleteVolumeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	volumeID := aws.ToString(params.VolumeId)
+	fv, ok := f.volumes[volumeID]
+	if !ok {
+		return nil, fakeAPIError("InvalidVolume.NotFound", "The volume '%s' does not exist.", volumeID)
+	}
+	f.advanceVolume(fv)
+	if fv.volume.State != types.VolumeStateAvailable {
+		return nil, fakeAPIError("VolumeInUse", "Volume %s is currently attached to %s", volumeID,
+			aws.ToString(fv.volume.Attachments[0].InstanceId))
+	}
+
+	delete(f.volumes, volumeID)
+	for i, id := range f.volumeOrder {
+		if id == volumeID {
+			f.volumeOrder = append(f.volumeOrder[:i], f.volumeOrder[i+1:]...)
+			break
+		}
+	}
+	return &ec2.DeleteVolumeOutput{}, nil
+}
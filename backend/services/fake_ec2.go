@@ -0,0 +1,621 @@
+/*
+fake_ec2.go
+In this file you will find FakeEC2, a stateful in-memory implementation of the EC2API interface.
+It simulates what the services rely on from AWS: instances moving through their lifecycle states
+(pending -> running -> stopping -> stopped -> pending, shutting-down -> terminated), public IPs being assigned
+once an instance is running, tags, filters, security groups with their ingress rules and EBS volumes (fake_ebs.go).
+It is used to run the backend on a laptop (EC2_BACKEND=fake) and to exercise the provisioning logic without real
+AWS credentials.
+*/
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// FakeEC2 is an in-memory EC2 "cloud". All methods are safe for concurrent use.
+type FakeEC2 struct {
+	mu sync.Mutex
+
+	region         string
+	instances      map[string]*fakeInstance
+	instanceOrder  []string // Keeps DescribeInstances output in launch order
+	securityGroups map[string]*types.SecurityGroup
+	volumes        map[string]*fakeVolume // EBS volumes, see fake_ebs.go
+	volumeOrder    []string
+	images         []types.Image
+	counter        int
+
+	// BootDelay is how long an instance stays "pending" before becoming "running".
+	BootDelay time.Duration
+	// StopDelay is how long an instance stays "stopping" before becoming "stopped".
+	StopDelay time.Duration
+	// BootConsole is what every instance "prints" on its serial console once it is running.
+	BootConsole []FakeConsoleLine
+	// Now returns the current time. It can be replaced to control state transitions.
+	Now func() time.Time
+}
+
+// FakeConsoleLine is a line printed on the serial console After the instance has been running for a while.
+type FakeConsoleLine struct {
+	After time.Duration
+	Line  string
+}
+
+// fakeInstance wraps the SDK instance with the bookkeeping needed to simulate transitions.
+type fakeInstance struct {
+	instance        types.Instance
+	userData        string
+	stateSince      time.Time
+	wantPublicIP    bool
+	publicIPIndex   int
+	console         strings.Builder
+	consoleLines    int  // Number of BootConsole lines already printed during the current boot
+	monitorReported bool // Whether the idle monitor reported in during the current boot
+}
+
+// SimulatedBootConsole() => returns the console stage markers printed by ec2-init.sh, one every step,
+// so the fake cloud goes through the same provisioning phases as a real instance.
+func SimulatedBootConsole(step time.Duration) []FakeConsoleLine {
+	lines := make([]FakeConsoleLine, len(consoleStages))
+	for i, known := range consoleStages {
+		lines[i] = FakeConsoleLine{After: time.Duration(i+1) * step, Line: consoleStagePrefix + known.stage}
+	}
+	return lines
+}
+
+// NewFakeEC2() => creates an empty fake cloud for the given region with one Amazon Linux 2023 image.
+func NewFakeEC2(region string) *FakeEC2 {
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &FakeEC2{
+		region:         region,
+		instances:      make(map[string]*fakeInstance),
+		securityGroups: make(map[string]*types.SecurityGroup),
+		volumes:        make(map[string]*fakeVolume),
+		images: []types.Image{
+			{
+				ImageId:      aws.String("ami-0fa4e00000000000a"),
+				Name:         aws.String("al2023-ami-2023.0.20260101.0-kernel-6.1-x86_64"),
+				CreationDate: aws.String("2026-01-01T00:00:00Z"),
+				Architecture: types.ArchitectureValuesX8664,
+				State:        types.ImageStateAvailable,
+			},
+		},
+		BootConsole: SimulatedBootConsole(0),
+		Now:         time.Now,
+	}
+}
+
+// nextID returns a new resource ID with the given prefix (i-, sg-, ...) in the AWS format.
+func (f *FakeEC2) nextID(prefix string) string {
+	f.counter++
+	return fmt.Sprintf("%s-%017x", prefix, f.counter)
+}
+
+// fakeAPIError builds an error shaped like the ones returned by the AWS SDK, so callers
+// (and the SDK waiters) can inspect the error code as they would in production.
+func fakeAPIError(code, format string, args ...interface{}) error {
+	return &smithy.GenericAPIError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// advance moves an instance to its next state once the configured delay has elapsed.
+// It must be called with f.mu held.
+func (f *FakeEC2) advance(fi *fakeInstance) {
+	now := f.Now()
+	switch fi.instance.State.Name {
+	case types.InstanceStateNamePending:
+		if now.Sub(fi.stateSince) >= f.BootDelay {
+			f.setState(fi, types.InstanceStateNameRunning)
+			if fi.wantPublicIP {
+				fi.instance.PublicIpAddress = aws.String(fmt.Sprintf("203.0.113.%d", fi.publicIPIndex%254+1))
+			}
+			fi.consoleLines = 0
+			fi.monitorReported = false
+		}
+	case types.InstanceStateNameRunning:
+		// Print the boot console lines whose time has come.
+		for fi.consoleLines < len(f.BootConsole) && now.Sub(fi.stateSince) >= f.BootConsole[fi.consoleLines].After {
+			fi.console.WriteString(f.BootConsole[fi.consoleLines].Line + "\n")
+			fi.consoleLines++
+		}
+		// The idle monitor starts once the setup is done, and reports in by tagging the instance
+		if fi.consoleLines == len(f.BootConsole) && !fi.monitorReported {
+			f.setTag(fi, idleMonitorTagKey, now.UTC().Format(time.RFC3339))
+			fi.monitorReported = true
+		}
+	case types.InstanceStateNameStopping:
+		if now.Sub(fi.stateSince) >= f.StopDelay {
+			f.setState(fi, types.InstanceStateNameStopped)
+			fi.instance.PublicIpAddress = nil
+		}
+	case types.InstanceStateNameShuttingDown:
+		if now.Sub(fi.stateSince) >= f.StopDelay {
+			f.setState(fi, types.InstanceStateNameTerminated)
+			fi.instance.PublicIpAddress = nil
+			f.releaseVolumes(fi)
+		}
+	}
+}
+
+// setTag sets a tag of an instance. The tag slice is replaced, not modified, since described instances share it.
+// It must be called with f.mu held.
+func (f *FakeEC2) setTag(fi *fakeInstance, key, value string) {
+	tags := make([]types.Tag, 0, len(fi.instance.Tags)+1)
+	for _, tag := range fi.instance.Tags {
+		if aws.ToString(tag.Key) != key {
+			tags = append(tags, tag)
+		}
+	}
+	fi.instance.Tags = append(tags, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+}
+
+// userInitiatedReason returns the StateTransitionReason EC2 gives to an instance stopped or terminated at t.
+func userInitiatedReason(t time.Time) string {
+	return "User initiated (" + t.UTC().Format(ec2TransitionTimeLayout) + ")"
+}
+
+// setState changes the state of an instance, keeping the numeric code consistent with AWS.
+func (f *FakeEC2) setState(fi *fakeInstance, name types.InstanceStateName) {
+	codes := map[types.InstanceStateName]int32{
+		types.InstanceStateNamePending:      0,
+		types.InstanceStateNameRunning:      16,
+		types.InstanceStateNameShuttingDown: 32,
+		types.InstanceStateNameTerminated:   48,
+		types.InstanceStateNameStopping:     64,
+		types.InstanceStateNameStopped:      80,
+	}
+	fi.instance.State = &types.InstanceState{Name: name, Code: aws.Int32(codes[name])}
+	fi.stateSince = f.Now()
+}
+
+// RunInstances launches MinCount instances in the "pending" state.
+func (f *FakeEC2) RunInstances(ctx context.Context, params *ec2.RunInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if aws.ToString(params.ImageId) == "" {
+		return nil, fakeAPIError("MissingParameter", "The request must contain the parameter ImageId")
+	}
+	count := int(aws.ToInt32(params.MinCount))
+	if count < 1 {
+		count = 1
+	}
+
+	// Resolve security groups attached through the network interface specification.
+	var groups []types.GroupIdentifier
+	wantPublicIP := false
+	for _, nic := range params.NetworkInterfaces {
+		wantPublicIP = wantPublicIP || aws.ToBool(nic.AssociatePublicIpAddress)
+		for _, groupID := range nic.Groups {
+			sg, ok := f.securityGroups[groupID]
+			if !ok {
+				return nil, fakeAPIError("InvalidGroup.NotFound", "The security group '%s' does not exist", groupID)
+			}
+			groups = append(groups, types.GroupIdentifier{GroupId: sg.GroupId, GroupName: sg.GroupName})
+		}
+	}
+
+	// Copy the instance tags from the tag specifications.
+	var tags []types.Tag
+	for _, spec := range params.TagSpecifications {
+		if spec.ResourceType == types.ResourceTypeInstance {
+			tags = append(tags, spec.Tags...)
+		}
+	}
+
+	// Instances go to the requested availability zone, or to the first one of the region.
+	zone := f.region + "a"
+	if params.Placement != nil && aws.ToString(params.Placement.AvailabilityZone) != "" {
+		zone = aws.ToString(params.Placement.AvailabilityZone)
+		if !strings.HasPrefix(zone, f.region) {
+			return nil, fakeAPIError("InvalidParameterValue", "Invalid availability zone: [%s]", zone)
+		}
+	}
+
+	output := &ec2.RunInstancesOutput{ReservationId: aws.String(f.nextID("r"))}
+	for i := 0; i < count; i++ {
+		instanceID := f.nextID("i")
+		launchTime := f.Now()
+		fi := &fakeInstance{
+			instance: types.Instance{
+				InstanceId:       aws.String(instanceID),
+				ImageId:          params.ImageId,
+				InstanceType:     params.InstanceType,
+				KeyName:          params.KeyName,
+				LaunchTime:       aws.Time(launchTime),
+				PrivateIpAddress: aws.String(fmt.Sprintf("172.31.%d.%d", (f.counter/254)%254, f.counter%254+1)),
+				Placement:        &types.Placement{AvailabilityZone: aws.String(zone)},
+				SecurityGroups:   groups,
+				Tags:             append([]types.Tag(nil), tags...),
+			},
+			userData:      aws.ToString(params.UserData),
+			wantPublicIP:  wantPublicIP,
+			publicIPIndex: f.counter,
+		}
+		if params.IamInstanceProfile != nil {
+			fi.instance.IamInstanceProfile = &types.IamInstanceProfile{
+				Arn: aws.String("arn:aws:iam::000000000000:instance-profile/" + aws.ToString(params.IamInstanceProfile.Name)),
+			}
+		}
+		f.setState(fi, types.InstanceStateNamePending)
+
+		f.instances[instanceID] = fi
+		f.instanceOrder = append(f.instanceOrder, instanceID)
+		output.Instances = append(output.Instances, fi.instance)
+	}
+
+	return output, nil
+}
+
+// DescribeInstances returns the instances matching the given IDs and filters.
+// Supported filters: instance-id, instance-state-name, tag:<key> and tag-key.
+func (f *FakeEC2) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids := f.instanceOrder
+	if len(params.InstanceIds) > 0 {
+		for _, id := range params.InstanceIds {
+			if _, ok := f.instances[id]; !ok {
+				return nil, fakeAPIError("InvalidInstanceID.NotFound", "The instance ID '%s' does not exist", id)
+			}
+		}
+		ids = params.InstanceIds
+	}
+
+	// Pages are numbered by offset in the matching instances: NextToken is "page-<offset>".
+	offset := 0
+	if token := aws.ToString(params.NextToken); token != "" {
+		if _, err := fmt.Sscanf(token, "page-%d", &offset); err != nil || offset < 0 {
+			return nil, fakeAPIError("InvalidParameterValue", "Invalid NextToken '%s'", token)
+		}
+	}
+
+	output := &ec2.DescribeInstancesOutput{}
+	matched := 0
+	for _, id := range ids {
+		fi := f.instances[id]
+		f.advance(fi)
+		if !matchesInstanceFilters(fi.instance, params.Filters) {
+			continue
+		}
+		matched++
+		if matched <= offset {
+			continue
+		}
+		if maxResults := aws.ToInt32(params.MaxResults); maxResults > 0 && len(output.Reservations) == int(maxResults) {
+			output.NextToken = aws.String(fmt.Sprintf("page-%d", offset+int(maxResults)))
+			break
+		}
+		// Each instance gets its own reservation, as when launched one at a time.
+		output.Reservations = append(output.Reservations, types.Reservation{
+			Instances: []types.Instance{fi.instance},
+		})
+	}
+
+	return output, nil
+}
+
+// matchesInstanceFilters checks an instance against DescribeInstances filters (values of a filter are OR'ed,
+// filters are AND'ed, exactly as in AWS).
+func matchesInstanceFilters(instance types.Instance, filters []types.Filter) bool {
+	for _, filter := range filters {
+		name := aws.ToString(filter.Name)
+		var actual []string
+		switch {
+		case name == "instance-id":
+			actual = []string{aws.ToString(instance.InstanceId)}
+		case name == "instance-state-name":
+			actual = []string{string(instance.State.Name)}
+		case name == "tag-key":
+			for _, tag := range instance.Tags {
+				actual = append(actual, aws.ToString(tag.Key))
+			}
+		case strings.HasPrefix(name, "tag:"):
+			key := strings.TrimPrefix(name, "tag:")
+			for _, tag := range instance.Tags {
+				if aws.ToString(tag.Key) == key {
+					actual = append(actual, aws.ToString(tag.Value))
+				}
+			}
+		default:
+			// Unknown filters never match, so a typo in a filter name is noticed right away.
+			return false
+		}
+		if !containsAny(actual, filter.Values) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsAny reports whether any of the wanted values is present in actual.
+func containsAny(actual, wanted []string) bool {
+	for _, w := range wanted {
+		for _, a := range actual {
+			if a == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesAnyPattern reports whether value matches one of the patterns, where "*" and "?" are wildcards as in AWS filters.
+func matchesAnyPattern(value string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, value); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// StartInstances moves stopped instances to "pending"; like in AWS, they get a new public IP once running.
+func (f *FakeEC2) StartInstances(ctx context.Context, params *ec2.StartInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StartInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	output := &ec2.StartInstancesOutput{}
+	for _, id := range params.InstanceIds {
+		fi, ok := f.instances[id]
+		if !ok {
+			return nil, fakeAPIError("InvalidInstanceID.NotFound", "The instance ID '%s' does not exist", id)
+		}
+		f.advance(fi)
+		previous := *fi.instance.State
+		switch previous.Name {
+		case types.InstanceStateNameStopped:
+			f.counter++
+			fi.publicIPIndex = f.counter
+			f.setState(fi, types.InstanceStateNamePending)
+			fi.instance.StateTransitionReason = aws.String("")
+		case types.InstanceStateNamePending, types.InstanceStateNameRunning:
+			// Starting a running instance is a no-op in AWS.
+		default:
+			return nil, fakeAPIError("IncorrectInstanceState", "The instance '%s' is not in a state from which it can be started", id)
+		}
+		output.StartingInstances = append(output.StartingInstances, types.InstanceStateChange{
+			InstanceId:    aws.String(id),
+			PreviousState: &previous,
+			CurrentState:  fi.instance.State,
+		})
+	}
+
+	return output, nil
+}
+
+// StopInstances moves running (or pending) instances to "stopping".
+func (f *FakeEC2) StopInstances(ctx context.Context, params *ec2.StopInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StopInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	output := &ec2.StopInstancesOutput{}
+	for _, id := range params.InstanceIds {
+		fi, ok := f.instances[id]
+		if !ok {
+			return nil, fakeAPIError("InvalidInstanceID.NotFound", "The instance ID '%s' does not exist", id)
+		}
+		f.advance(fi)
+		previous := *fi.instance.State
+		switch previous.Name {
+		case types.InstanceStateNameRunning, types.InstanceStateNamePending:
+			f.setState(fi, types.InstanceStateNameStopping)
+			fi.instance.StateTransitionReason = aws.String(userInitiatedReason(f.Now()))
+		case types.InstanceStateNameStopping, types.InstanceStateNameStopped:
+			// Stopping an already stopped instance is a no-op in AWS.
+		default:
+			return nil, fakeAPIError("IncorrectInstanceState", "The instance '%s' is not in a state from which it can be stopped", id)
+		}
+		output.StoppingInstances = append(output.StoppingInstances, types.InstanceStateChange{
+			InstanceId:    aws.String(id),
+			PreviousState: &previous,
+			CurrentState:  fi.instance.State,
+		})
+	}
+
+	return output, nil
+}
+
+// TerminateInstances moves instances to "shutting-down"; they become "terminated" after StopDelay.
+func (f *FakeEC2) TerminateInstances(ctx context.Context, params *ec2.TerminateInstancesInput, optFns ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	output := &ec2.TerminateInstancesOutput{}
+	for _, id := range params.InstanceIds {
+		fi, ok := f.instances[id]
+		if !ok {
+			return nil, fakeAPIError("InvalidInstanceID.NotFound", "The instance ID '%s' does not exist", id)
+		}
+		f.advance(fi)
+		previous := *fi.instance.State
+		switch previous.Name {
+		case types.InstanceStateNameShuttingDown, types.InstanceStateNameTerminated:
+			// Terminating an instance twice is a no-op in AWS.
+		default:
+			f.setState(fi, types.InstanceStateNameShuttingDown)
+			fi.instance.StateTransitionReason = aws.String(userInitiatedReason(f.Now()))
+		}
+		output.TerminatingInstances = append(output.TerminatingInstances, types.InstanceStateChange{
+			InstanceId:    aws.String(id),
+			PreviousState: &previous,
+			CurrentState:  fi.instance.State,
+		})
+	}
+
+	return output, nil
+}
+
+// GetConsoleOutput returns the base64 encoded console output printed so far by an instance.
+func (f *FakeEC2) GetConsoleOutput(ctx context.Context, params *ec2.GetConsoleOutputInput, optFns ...func(*ec2.Options)) (*ec2.GetConsoleOutputOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	instanceID := aws.ToString(params.InstanceId)
+	fi, ok := f.instances[instanceID]
+	if !ok {
+		return nil, fakeAPIError("InvalidInstanceID.NotFound", "The instance ID '%s' does not exist", instanceID)
+	}
+	f.advance(fi)
+
+	return &ec2.GetConsoleOutputOutput{
+		InstanceId: aws.String(instanceID),
+		Output:     aws.String(base64.StdEncoding.EncodeToString([]byte(fi.console.String()))),
+		Timestamp:  aws.Time(f.Now()),
+	}, nil
+}
+
+// AppendConsoleOutput adds text to the console output of an instance, as if the instance printed it.
+func (f *FakeEC2) AppendConsoleOutput(instanceID, text string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fi, ok := f.instances[instanceID]
+	if !ok {
+		return false
+	}
+	fi.console.WriteString(text)
+	return true
+}
+
+// DescribeImages returns the images known by the fake cloud. Filters are ignored.
+func (f *FakeEC2) DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &ec2.DescribeImagesOutput{Images: append([]types.Image(nil), f.images...)}, nil
+}
+
+// DescribeSecurityGroups returns security groups by ID or by the group-name (wildcards allowed) and group-id filters.
+func (f *FakeEC2) DescribeSecurityGroups(ctx context.Context, params *ec2.DescribeSecurityGroupsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	output := &ec2.DescribeSecurityGroupsOutput{}
+	for _, id := range params.GroupIds {
+		if _, ok := f.securityGroups[id]; !ok {
+			return nil, fakeAPIError("InvalidGroup.NotFound", "The security group '%s' does not exist", id)
+		}
+	}
+
+	for _, sg := range f.securityGroups {
+		if len(params.GroupIds) > 0 && !containsAny([]string{aws.ToString(sg.GroupId)}, params.GroupIds) {
+			continue
+		}
+		matches := true
+		for _, filter := range params.Filters {
+			switch aws.ToString(filter.Name) {
+			case "group-name":
+				matches = matches && matchesAnyPattern(aws.ToString(sg.GroupName), filter.Values)
+			case "group-id":
+				matches = matches && containsAny([]string{aws.ToString(sg.GroupId)}, filter.Values)
+			default:
+				matches = false
+			}
+		}
+		if matches {
+			output.SecurityGroups = append(output.SecurityGroups, *sg)
+		}
+	}
+
+	return output, nil
+}
+
+// CreateSecurityGroup creates an empty security group. Group names are unique, as in a VPC.
+func (f *FakeEC2) CreateSecurityGroup(ctx context.Context, params *ec2.CreateSecurityGroupInput, optFns ...func(*ec2.Options)) (*ec2.CreateSecurityGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := aws.ToString(params.GroupName)
+	for _, sg := range f.securityGroups {
+		if aws.ToString(sg.GroupName) == name {
+			return nil, fakeAPIError("InvalidGroup.Duplicate", "The security group '%s' already exists", name)
+		}
+	}
+
+	groupID := f.nextID("sg")
+	f.securityGroups[groupID] = &types.SecurityGroup{
+		GroupId:     aws.String(groupID),
+		GroupName:   aws.String(name),
+		Description: params.Description,
+		VpcId:       aws.String("vpc-00000000000000001"),
+	}
+
+	return &ec2.CreateSecurityGroupOutput{GroupId: aws.String(groupID)}, nil
+}
+
+// AuthorizeSecurityGroupIngress adds ingress rules to a security group, rejecting exact duplicates.
+func (f *FakeEC2) AuthorizeSecurityGroupIngress(ctx context.Context, params *ec2.AuthorizeSecurityGroupIngressInput, optFns ...func(*ec2.Options)) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sg, ok := f.securityGroups[aws.ToString(params.GroupId)]
+	if !ok {
+		return nil, fakeAPIError("InvalidGroup.NotFound", "The security group '%s' does not exist", aws.ToString(params.GroupId))
+	}
+
+	for _, permission := range params.IpPermissions {
+		for _, existing := range sg.IpPermissions {
+			if aws.ToString(existing.IpProtocol) == aws.ToString(permission.IpProtocol) &&
+				aws.ToInt32(existing.FromPort) == aws.ToInt32(permission.FromPort) &&
+				aws.ToInt32(existing.ToPort) == aws.ToInt32(permission.ToPort) {
+				return nil, fakeAPIError("InvalidPermission.Duplicate", "the specified rule already exists")
+			}
+		}
+	}
+	sg.IpPermissions = append(sg.IpPermissions, params.IpPermissions...)
+
+	return &ec2.AuthorizeSecurityGroupIngressOutput{Return: aws.Bool(true)}, nil
+}
+
+// DeleteSecurityGroup deletes a security group, unless an instance that is not terminated still uses it.
+func (f *FakeEC2) DeleteSecurityGroup(ctx context.Context, params *ec2.DeleteSecurityGroupInput, optFns ...func(*ec2.Options)) (*ec2.DeleteSecurityGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	groupID := aws.ToString(params.GroupId)
+	if _, ok := f.securityGroups[groupID]; !ok {
+		return nil, fakeAPIError("InvalidGroup.NotFound", "The security group '%s' does not exist", groupID)
+	}
+	for _, id := range f.instanceOrder {
+		fi := f.instances[id]
+		f.advance(fi)
+		if fi.instance.State.Name == types.InstanceStateNameTerminated {
+			continue
+		}
+		for _, group := range fi.instance.SecurityGroups {
+			if aws.ToString(group.GroupId) == groupID {
+				return nil, fakeAPIError("DependencyViolation", "resource %s has a dependent object", groupID)
+			}
+		}
+	}
+
+	delete(f.securityGroups, groupID)
+	return &ec2.DeleteSecurityGroupOutput{}, nil
+}
+
+// UserData returns the (base64 encoded) user data an instance was launched with.
+func (f *FakeEC2) UserData(instanceID string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fi, ok := f.instances[instanceID]
+	if !ok {
+		return "", false
+	}
+	return fi.userData, true
+}
@@ -0,0 +1,163 @@
+/*
+fake_s3.go
+In this file you will find FakeS3, an in-memory implementation of the S3API interface. Buckets are created on their
+first write and objects are listed in key order, with pagination, like S3 does. Its pre-signed URLs point to a host
+that does not exist. It is used with the fake cloud (EC2_BACKEND=fake) so the backup endpoints can be tried without
+a bucket.
+*/
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// FakeS3 is an in-memory object storage. All methods are safe for concurrent use.
+type FakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]*fakeObject // bucket => key => object
+
+	// Now returns the current time, used as the last modification time of the objects.
+	Now func() time.Time
+}
+
+// fakeObject is a stored object with what S3 returns about it.
+type fakeObject struct {
+	data         []byte
+	metadata     map[string]string
+	lastModified time.Time
+}
+
+// NewFakeS3() => creates an empty fake object storage.
+func NewFakeS3() *FakeS3 {
+	return &FakeS3{
+		buckets: make(map[string]map[string]*fakeObject),
+		Now:     time.Now,
+	}
+}
+
+// PutObject stores an object, replacing the previous object with the same key.
+func (f *FakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	var data []byte
+	if params.Body != nil {
+		var err error
+		if data, err = io.ReadAll(params.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket := aws.ToString(params.Bucket)
+	if f.buckets[bucket] == nil {
+		f.buckets[bucket] = make(map[string]*fakeObject)
+	}
+	metadata := make(map[string]string, len(params.Metadata))
+	for key, value := range params.Metadata {
+		metadata[key] = value
+	}
+	f.buckets[bucket][aws.ToString(params.Key)] = &fakeObject{
+		data:         bytes.Clone(data),
+		metadata:     metadata,
+		lastModified: f.Now().UTC(),
+	}
+	return &s3.PutObjectOutput{}, nil
+}
+
+// HeadObject returns the size and metadata of an object, or a *types.NotFound error like S3.
+func (f *FakeS3) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	object, ok := f.buckets[aws.ToString(params.Bucket)][aws.ToString(params.Key)]
+	if !ok {
+		return nil, &types.NotFound{Message: aws.String("Not Found")}
+	}
+	metadata := make(map[string]string, len(object.metadata))
+	for key, value := range object.metadata {
+		metadata[key] = value
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(object.data))),
+		LastModified:  aws.Time(object.lastModified),
+		Metadata:      metadata,
+	}, nil
+}
+
+// PresignGetObject returns a URL shaped like a pre-signed S3 URL, on the fake-s3.invalid host.
+func (f *FakeS3) PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	options := s3.PresignOptions{Expires: 15 * time.Minute}
+	for _, fn := range optFns {
+		fn(&options)
+	}
+	objectURL := url.URL{
+		Scheme:   "https",
+		Host:     "fake-s3.invalid",
+		Path:     "/" + aws.ToString(params.Bucket) + "/" + aws.ToString(params.Key),
+		RawQuery: fmt.Sprintf("X-Amz-Expires=%d&X-Amz-Signature=fake", int64(options.Expires/time.Second)),
+	}
+	return &v4.PresignedHTTPRequest{
+		URL:          objectURL.String(),
+		Method:       http.MethodGet,
+		SignedHeader: http.Header{},
+	}, nil
+}
+
+// ListObjectsV2 lists the objects whose key starts with Prefix, in key order, MaxKeys (default 1000) at a time.
+func (f *FakeS3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prefix := aws.ToString(params.Prefix)
+	after := aws.ToString(params.StartAfter)
+	if token := aws.ToString(params.ContinuationToken); token != "" {
+		after = token // The token is the last key of the previous page
+	}
+	maxKeys := aws.ToInt32(params.MaxKeys)
+	if maxKeys <= 0 || maxKeys > 1000 {
+		maxKeys = 1000
+	}
+
+	objects := f.buckets[aws.ToString(params.Bucket)]
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	output := &s3.ListObjectsV2Output{
+		Name:   params.Bucket,
+		Prefix: params.Prefix,
+	}
+	for _, key := range keys {
+		if int32(len(output.Contents)) == maxKeys {
+			output.IsTruncated = aws.Bool(true)
+			output.NextContinuationToken = output.Contents[len(output.Contents)-1].Key
+			break
+		}
+		object := objects[key]
+		output.Contents = append(output.Contents, types.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(object.data))),
+			LastModified: aws.Time(object.lastModified),
+		})
+	}
+	output.KeyCount = aws.Int32(int32(len(output.Contents)))
+	return output, nil
+}
@@ -0,0 +1,404 @@
+/*
+garbage_collector.go
+In this file you will find the GarbageCollector, which finds the AWS resources the generator left behind and cleans
+them up: instances whose launch failed, servers running for longer than MAX_SERVER_LIFETIME since their last start
+(their idle monitor should have shut them down long before; servers that never go idle are exempt), servers stopped
+for longer than MAX_STOPPED_LIFETIME, world volumes unused for longer than WORLD_VOLUME_RETENTION and
+minecraft-server-sg security groups no instance uses anymore.
+A dry run only reports what would be done, so an admin can review it before anything is deleted.
+*/
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/storage"
+)
+
+// GarbageCollectorConfig sets how long the garbage collector lets the resources of the servers live.
+type GarbageCollectorConfig struct {
+	MaxServerLifetime    time.Duration // Running servers older than this are terminated; 0 means never
+	MaxStoppedLifetime   time.Duration // Servers stopped for longer than this are terminated; 0 means never
+	WorldVolumeRetention time.Duration // Unused world volumes are deleted after this long; 0 means never
+}
+
+// ec2TransitionTimeLayout is the layout of the time EC2 gives in the StateTransitionReason of a stopped instance, as in
+// "User initiated (2025-01-21 12:00:00 GMT)".
+const ec2TransitionTimeLayout = "2006-01-02 15:04:05 GMT" // Always in UTC
+
+var transitionTimePattern = regexp.MustCompile(`\((\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2} GMT)\)`)
+
+// GarbageCollector terminates orphaned instances and deletes unused world volumes and security groups.
+type GarbageCollector struct {
+	minecraftService *MinecraftService
+	broker           *EventBroker
+	config           GarbageCollectorConfig
+
+	mu sync.Mutex // Serializes passes, so a scheduled run and an admin request do not act twice on a resource
+}
+
+// NewGarbageCollector() => creates a garbage collector. The durations of config set to 0 disable what they limit.
+func NewGarbageCollector(minecraftService *MinecraftService, broker *EventBroker, config GarbageCollectorConfig) *GarbageCollector {
+	return &GarbageCollector{
+		minecraftService: minecraftService,
+		broker:           broker,
+		config:           config,
+	}
+}
+
+// Run() => collects every interval, until ctx is cancelled. The first pass happens after one interval.
+func (g *GarbageCollector) Run(ctx context.Context, interval time.Duration) {
+	log.Printf("Garbage collector started (every %s)", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Garbage collector stopped")
+			return
+		case <-ticker.C:
+		}
+
+		report, err := g.Collect(ctx, false)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Warning: garbage collection failed: %v", err)
+		} else if report != nil && report.Count > 0 {
+			log.Printf("Garbage collection cleaned up %d resources", report.Count)
+		}
+	}
+}
+
+/*
+Collect() => finds the orphaned resources and, unless dryRun is set, cleans them up. Every resource found is in the
+report, with the outcome of its action; failures are reported there instead of stopping the pass.
+*/
+func (g *GarbageCollector) Collect(ctx context.Context, dryRun bool) (*models.CleanupReport, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	report := &models.CleanupReport{
+		DryRun:    dryRun,
+		StartedAt: time.Now().UTC().Format(time.RFC3339),
+		Actions:   []models.CleanupAction{},
+	}
+	if g.config.MaxServerLifetime > 0 {
+		report.MaxServerLifetime = g.config.MaxServerLifetime.String()
+	}
+	if g.config.MaxStoppedLifetime > 0 {
+		report.MaxStoppedLifetime = g.config.MaxStoppedLifetime.String()
+	}
+	if g.config.WorldVolumeRetention > 0 {
+		report.WorldVolumeRetention = g.config.WorldVolumeRetention.String()
+	}
+
+	if err := g.collectInstances(ctx, report); err != nil {
+		return nil, err
+	}
+	if err := g.collectVolumes(ctx, report); err != nil {
+		return nil, err
+	}
+	if err := g.collectSecurityGroups(ctx, report); err != nil {
+		return nil, err
+	}
+
+	report.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	report.Count = len(report.Actions)
+	return report, nil
+}
+
+// collectInstances() => terminates the generator instances from failed launches and those past their lifetime, running
+// or stopped.
+func (g *GarbageCollector) collectInstances(ctx context.Context, report *models.CleanupReport) error {
+	ms := g.minecraftService
+	instances, err := ms.ec2Service.describeInstancesPages(ctx, []types.Filter{
+		{Name: aws.String("tag:" + createdByTagKey), Values: []string{createdByTagValue}},
+		{Name: aws.String("instance-state-name"), Values: []string{"pending", "running", "stopping", "stopped"}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe generator instances: %v", err)
+	}
+
+	for _, instance := range instances {
+		instanceID := aws.ToString(instance.InstanceId)
+		tags := instanceTags(instance)
+
+		now := time.Now()
+		reason := ""
+		record, err := ms.registry.GetServer(ctx, instanceID)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Warning: failed to read server %s: %v", instanceID, err)
+		}
+		if record != nil && record.LaunchError != "" {
+			reason = "launch failed: " + record.LaunchError
+		} else if age, expired := g.lifetimeExceeded(instance, record, now); expired {
+			reason = fmt.Sprintf("running for %s, more than the maximum lifetime of %s", age.Round(time.Second), g.config.MaxServerLifetime)
+		} else if stopped, expired := g.stoppedLifetimeExceeded(ctx, instance, now); expired {
+			reason = fmt.Sprintf("stopped for %s, more than the maximum stopped lifetime of %s", stopped.Round(time.Second), g.config.MaxStoppedLifetime)
+		}
+		if reason == "" {
+			continue
+		}
+
+		action := models.CleanupAction{
+			ResourceType: "instance",
+			ResourceID:   instanceID,
+			Name:         tags["Name"],
+			OwnerID:      tags[ownerTagKey],
+			State:        string(instance.State.Name),
+			Action:       "terminate",
+			Reason:       reason,
+			Status:       models.CleanupPlanned,
+		}
+		if !report.DryRun {
+			if err := ms.TerminateInstance(ctx, instanceID, "garbage collected: "+reason); err != nil {
+				action.Status, action.Error = models.CleanupFailed, err.Error()
+			} else {
+				action.Status = models.CleanupDone
+				log.Printf("Garbage collector terminated %s: %s", instanceID, reason)
+				g.broker.Publish(instanceID, models.ServerEvent{
+					Type:       models.EventEC2State,
+					State:      "shutting-down",
+					Message:    "Instance is being terminated by the garbage collector (" + reason + ")",
+					InstanceID: instanceID,
+				})
+			}
+		}
+		report.Actions = append(report.Actions, action)
+	}
+	return nil
+}
+
+/*
+lifetimeExceeded() => reports whether a server has been running for longer than the maximum lifetime, and for how
+long. The age is measured from the launch of its current boot, so a server stopped and started again later (by its
+owner or its idle monitor) gets a full lifetime again. Servers that never go idle are meant to run for good: they are
+exempt.
+*/
+func (g *GarbageCollector) lifetimeExceeded(instance types.Instance, record *models.ServerRecord, now time.Time) (time.Duration, bool) {
+	if g.config.MaxServerLifetime <= 0 || instance.State == nil ||
+		(instance.State.Name != types.InstanceStateNamePending && instance.State.Name != types.InstanceStateNameRunning) {
+		return 0, false
+	}
+	if record != nil && record.Request != nil && record.Request.IdlePolicy.NeverIdle {
+		return 0, false
+	}
+	age, ok := serverUptime(instance, now)
+	return age, ok && age > g.config.MaxServerLifetime
+}
+
+/*
+stoppedLifetimeExceeded() => reports whether a server has been stopped for longer than the maximum stopped lifetime, and
+for how long. Its idle monitor stops an abandoned server rather than terminating it by default, so without this limit
+the instance and its volumes would be kept (and billed) for good. Servers that never go idle are not exempt: once
+stopped, they are abandoned like any other.
+*/
+func (g *GarbageCollector) stoppedLifetimeExceeded(ctx context.Context, instance types.Instance, now time.Time) (time.Duration, bool) {
+	if g.config.MaxStoppedLifetime <= 0 || instance.State == nil || instance.State.Name != types.InstanceStateNameStopped {
+		return 0, false
+	}
+	stoppedAt, ok := g.stoppedSince(ctx, instance)
+	if !ok {
+		return 0, false
+	}
+	stopped := now.Sub(stoppedAt)
+	return stopped, stopped > g.config.MaxStoppedLifetime
+}
+
+// stoppedSince() => when a stopped server was stopped: the time EC2 gives in its StateTransitionReason, or else the last
+// transition to "stopped" the registry recorded.
+func (g *GarbageCollector) stoppedSince(ctx context.Context, instance types.Instance) (time.Time, bool) {
+	if match := transitionTimePattern.FindStringSubmatch(aws.ToString(instance.StateTransitionReason)); match != nil {
+		if stoppedAt, err := time.Parse(ec2TransitionTimeLayout, match[1]); err == nil {
+			return stoppedAt, true
+		}
+	}
+	transitions, err := g.minecraftService.registry.ListTransitions(ctx, aws.ToString(instance.InstanceId))
+	if err != nil {
+		log.Printf("Warning: failed to read the history of server %s: %v", aws.ToString(instance.InstanceId), err)
+		return time.Time{}, false
+	}
+	for i := len(transitions) - 1; i >= 0; i-- {
+		if transitions[i].ToState == string(types.InstanceStateNameStopped) {
+			stoppedAt, err := time.Parse(time.RFC3339, transitions[i].At)
+			return stoppedAt, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+// serverUptime() => how long ago the current boot of a server was launched (its CreatedAt tag when EC2 does not say).
+func serverUptime(instance types.Instance, now time.Time) (time.Duration, bool) {
+	if instance.LaunchTime != nil {
+		return now.Sub(*instance.LaunchTime), true
+	}
+	if createdAt, err := time.Parse(time.RFC3339, instanceTags(instance)["CreatedAt"]); err == nil {
+		return now.Sub(createdAt), true
+	}
+	return 0, false
+}
+
+/*
+collectVolumes() => deletes the world volumes nobody uses anymore: not attached, with no server left to their owner
+(who could still relaunch one on the volume with data_volume_id), for longer than the retention since the last server
+of the volume was terminated (since the volume was created when no recorded server used it). Launches are held back
+meanwhile, so the volume of a server being created is not deleted before the server is recorded.
+*/
+func (g *GarbageCollector) collectVolumes(ctx context.Context, report *models.CleanupReport) error {
+	retention := g.config.WorldVolumeRetention
+	if retention <= 0 {
+		return nil
+	}
+	ms := g.minecraftService
+	if !report.DryRun {
+		ms.launchMu.Lock()
+		defer ms.launchMu.Unlock()
+	}
+
+	volumes, err := ms.ec2Service.describeVolumesPages(ctx, []types.Filter{
+		{Name: aws.String("tag:" + createdByTagKey), Values: []string{createdByTagValue}},
+		{Name: aws.String("tag:" + serverTypeTagKey), Values: []string{worldVolumeTagValue}},
+		{Name: aws.String("status"), Values: []string{string(types.VolumeStateAvailable)}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe world volumes: %v", err)
+	}
+	if len(volumes) == 0 {
+		return nil
+	}
+
+	records, err := ms.registry.ListServers(ctx, storage.ServerFilter{IncludeTerminated: true})
+	if err != nil {
+		return fmt.Errorf("failed to list servers: %v", err)
+	}
+	hasServer := make(map[string]bool)     // Owners with a server not terminated
+	lastUsed := make(map[string]time.Time) // Volume ID => termination of its last server
+	for _, record := range records {
+		if record.State != "terminated" {
+			hasServer[record.OwnerID] = true
+			continue
+		}
+		terminatedAt, err := time.Parse(time.RFC3339, record.TerminatedAt)
+		if record.DataVolumeID != "" && err == nil && terminatedAt.After(lastUsed[record.DataVolumeID]) {
+			lastUsed[record.DataVolumeID] = terminatedAt
+		}
+	}
+
+	now := time.Now()
+	for _, volume := range volumes {
+		volumeID := aws.ToString(volume.VolumeId)
+		tags := make(map[string]string, len(volume.Tags))
+		for _, tag := range volume.Tags {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+		if hasServer[tags[ownerTagKey]] {
+			continue
+		}
+		unusedSince, ok := lastUsed[volumeID]
+		if !ok && volume.CreateTime != nil {
+			unusedSince = *volume.CreateTime
+		}
+		if unusedSince.IsZero() || now.Sub(unusedSince) <= retention {
+			continue
+		}
+
+		action := models.CleanupAction{
+			ResourceType: "volume",
+			ResourceID:   volumeID,
+			Name:         tags["Name"],
+			OwnerID:      tags[ownerTagKey],
+			State:        string(volume.State),
+			Action:       "delete",
+			Reason: fmt.Sprintf("unused for %s, more than the world volume retention of %s, and its owner has no server",
+				now.Sub(unusedSince).Round(time.Second), retention),
+			Status: models.CleanupPlanned,
+		}
+		if !report.DryRun {
+			_, err := ms.ec2Service.client.DeleteVolume(ctx, &ec2.DeleteVolumeInput{VolumeId: aws.String(volumeID)})
+			if err != nil {
+				action.Status, action.Error = models.CleanupFailed, err.Error()
+			} else {
+				action.Status = models.CleanupDone
+				log.Printf("Garbage collector deleted world volume %s (%s): %s", volumeID, action.Name, action.Reason)
+			}
+		}
+		report.Actions = append(report.Actions, action)
+	}
+	return nil
+}
+
+/*
+collectSecurityGroups() => deletes the minecraft-server-sg groups that no instance (terminated ones aside) uses. Launches
+are held back meanwhile, so a group is not deleted between the moment a launch picks it and RunInstances.
+*/
+func (g *GarbageCollector) collectSecurityGroups(ctx context.Context, report *models.CleanupReport) error {
+	ms := g.minecraftService
+	if !report.DryRun {
+		ms.launchMu.Lock()
+		defer ms.launchMu.Unlock()
+	}
+
+	groups, err := ms.ec2Service.client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: []types.Filter{
+			{Name: aws.String("group-name"), Values: []string{minecraftSecurityGroupName + "*"}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe security groups: %v", err)
+	}
+	if len(groups.SecurityGroups) == 0 {
+		return nil
+	}
+
+	// Any instance may use the groups, not only the generator's: look at all of them
+	instances, err := ms.ec2Service.describeInstancesPages(ctx, []types.Filter{
+		{Name: aws.String("instance-state-name"), Values: []string{"pending", "running", "shutting-down", "stopping", "stopped"}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe instances: %v", err)
+	}
+	inUse := make(map[string]bool)
+	for _, instance := range instances {
+		for _, group := range instance.SecurityGroups {
+			inUse[aws.ToString(group.GroupId)] = true
+		}
+	}
+
+	for _, group := range groups.SecurityGroups {
+		groupID := aws.ToString(group.GroupId)
+		if inUse[groupID] || !strings.HasPrefix(aws.ToString(group.GroupName), minecraftSecurityGroupName) {
+			continue
+		}
+
+		action := models.CleanupAction{
+			ResourceType: "security_group",
+			ResourceID:   groupID,
+			Name:         aws.ToString(group.GroupName),
+			Action:       "delete",
+			Reason:       "no instance uses this security group",
+			Status:       models.CleanupPlanned,
+		}
+		if !report.DryRun {
+			_, err := ms.ec2Service.client.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{GroupId: aws.String(groupID)})
+			if err != nil {
+				action.Status, action.Error = models.CleanupFailed, err.Error()
+			} else {
+				action.Status = models.CleanupDone
+				log.Printf("Garbage collector deleted security group %s (%s)", groupID, action.Name)
+			}
+		}
+		report.Actions = append(report.Actions, action)
+	}
+	return nil
+}
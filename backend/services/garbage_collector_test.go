@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/storage"
+)
+
+func TestLifetimeExceeded(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	collector := &GarbageCollector{config: GarbageCollectorConfig{MaxServerLifetime: 24 * time.Hour}}
+
+	instance := func(state types.InstanceStateName, launchedAgo, createdAgo time.Duration) types.Instance {
+		return types.Instance{
+			State:      &types.InstanceState{Name: state},
+			LaunchTime: aws.Time(now.Add(-launchedAgo)),
+			Tags:       []types.Tag{{Key: aws.String("CreatedAt"), Value: aws.String(now.Add(-createdAgo).Format(time.RFC3339))}},
+		}
+	}
+	record := func(neverIdle bool) *models.ServerRecord {
+		return &models.ServerRecord{Request: &models.MinecraftServerRequest{IdlePolicy: models.IdlePolicy{NeverIdle: neverIdle}}}
+	}
+
+	cases := []struct {
+		name     string
+		instance types.Instance
+		record   *models.ServerRecord
+		expired  bool
+	}{
+		{"young server", instance(types.InstanceStateNameRunning, time.Hour, time.Hour), record(false), false},
+		{"running past its lifetime", instance(types.InstanceStateNameRunning, 25*time.Hour, 25*time.Hour), record(false), true},
+		{"restarted after a stop", instance(types.InstanceStateNameRunning, time.Hour, 72*time.Hour), record(false), false},
+		{"old boot of an old server", instance(types.InstanceStateNameRunning, 25*time.Hour, 72*time.Hour), record(false), true},
+		{"stopped", instance(types.InstanceStateNameStopped, 25*time.Hour, 72*time.Hour), record(false), false},
+		{"never idle", instance(types.InstanceStateNameRunning, 25*time.Hour, 25*time.Hour), record(true), false},
+		{"not in the registry", instance(types.InstanceStateNamePending, 25*time.Hour, 25*time.Hour), nil, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, expired := collector.lifetimeExceeded(c.instance, c.record, now); expired != c.expired {
+				t.Errorf("expired = %t, want %t", expired, c.expired)
+			}
+		})
+	}
+
+	disabled := &GarbageCollector{}
+	if _, expired := disabled.lifetimeExceeded(instance(types.InstanceStateNameRunning, 1000*time.Hour, 0), nil, now); expired {
+		t.Error("a maximum lifetime of 0 must never expire servers")
+	}
+}
+
+// testCollector is a garbage collector on a fake cloud, with the fake cloud and the registry to set the scene.
+type testCollector struct {
+	*GarbageCollector
+	fake     *FakeEC2
+	registry *storage.SQLiteRegistry
+}
+
+// newTestCollector() => returns a garbage collector on an empty fake cloud.
+func newTestCollector(t *testing.T, config GarbageCollectorConfig) *testCollector {
+	t.Helper()
+	t.Setenv("AWS_REGION", "us-east-1")
+	fake := NewFakeEC2("us-east-1")
+	registry, err := storage.NewSQLiteRegistry(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { registry.Close() })
+	minecraftService := NewMinecraftService(NewEC2ServiceWithClient(fake), registry)
+	return &testCollector{NewGarbageCollector(minecraftService, NewEventBroker(), config), fake, registry}
+}
+
+// at() => runs create with the clock of the fake cloud set ago in the past, so that what it creates is that old.
+func (c *testCollector) at(ago time.Duration, create func() error) error {
+	c.fake.Now = func() time.Time { return time.Now().Add(-ago) }
+	defer func() { c.fake.Now = time.Now }()
+	return create()
+}
+
+// launch() => launches an instance ago in the past, tagged as the generator's when generator is set.
+func (c *testCollector) launch(t *testing.T, ago time.Duration, generator bool, groupIDs ...string) string {
+	t.Helper()
+	var tags []types.Tag
+	if generator {
+		tags = append(tags, types.Tag{Key: aws.String(createdByTagKey), Value: aws.String(createdByTagValue)})
+	}
+	var output *ec2.RunInstancesOutput
+	err := c.at(ago, func() (err error) {
+		output, err = c.fake.RunInstances(context.Background(), &ec2.RunInstancesInput{
+			ImageId:           aws.String("ami-0fa4e00000000000a"),
+			MinCount:          aws.Int32(1),
+			MaxCount:          aws.Int32(1),
+			NetworkInterfaces: []types.InstanceNetworkInterfaceSpecification{{DeviceIndex: aws.Int32(0), Groups: groupIDs}},
+			TagSpecifications: []types.TagSpecification{{ResourceType: types.ResourceTypeInstance, Tags: tags}},
+		})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aws.ToString(output.Instances[0].InstanceId)
+}
+
+// stop() => stops an instance ago in the past.
+func (c *testCollector) stop(t *testing.T, instanceID string, ago time.Duration) {
+	t.Helper()
+	err := c.at(ago, func() error {
+		_, err := c.fake.StopInstances(context.Background(), &ec2.StopInstancesInput{InstanceIds: []string{instanceID}})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createVolume() => creates a volume of ownerID ago in the past, tagged as a world volume when world is set.
+func (c *testCollector) createVolume(t *testing.T, ago time.Duration, ownerID string, world bool) string {
+	t.Helper()
+	tags := []types.Tag{
+		{Key: aws.String(createdByTagKey), Value: aws.String(createdByTagValue)},
+		{Key: aws.String(ownerTagKey), Value: aws.String(ownerID)},
+	}
+	if world {
+		tags = append(tags, types.Tag{Key: aws.String(serverTypeTagKey), Value: aws.String(worldVolumeTagValue)})
+	}
+	var output *ec2.CreateVolumeOutput
+	err := c.at(ago, func() (err error) {
+		output, err = c.fake.CreateVolume(context.Background(), &ec2.CreateVolumeInput{
+			AvailabilityZone:  aws.String("us-east-1a"),
+			Size:              aws.Int32(10),
+			TagSpecifications: []types.TagSpecification{{ResourceType: types.ResourceTypeVolume, Tags: tags}},
+		})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aws.ToString(output.VolumeId)
+}
+
+// createGroup() => creates a security group named name.
+func (c *testCollector) createGroup(t *testing.T, name string) string {
+	t.Helper()
+	output, err := c.fake.CreateSecurityGroup(context.Background(), &ec2.CreateSecurityGroupInput{
+		GroupName:   aws.String(name),
+		Description: aws.String("test"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aws.ToString(output.GroupId)
+}
+
+// record() => records a server in the registry.
+func (c *testCollector) record(t *testing.T, record models.ServerRecord) {
+	t.Helper()
+	if err := c.registry.CreateServer(context.Background(), &record); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// instanceState() => returns the EC2 state of an instance.
+func (c *testCollector) instanceState(t *testing.T, instanceID string) types.InstanceStateName {
+	t.Helper()
+	output, err := c.fake.DescribeInstances(context.Background(), &ec2.DescribeInstancesInput{InstanceIds: []string{instanceID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output.Reservations[0].Instances[0].State.Name
+}
+
+// checkActions() => checks that the report holds one action per resource of want, with the status of the mode.
+func checkActions(t *testing.T, report *models.CleanupReport, want ...string) {
+	t.Helper()
+	wantStatus := models.CleanupDone
+	if report.DryRun {
+		wantStatus = models.CleanupPlanned
+	}
+	got := make(map[string]models.CleanupStatus)
+	for _, action := range report.Actions {
+		got[action.ResourceID] = action.Status
+	}
+	if len(got) != len(want) || len(report.Actions) != len(want) {
+		t.Fatalf("actions = %+v, want one for each of %v", report.Actions, want)
+	}
+	for _, id := range want {
+		if got[id] != wantStatus {
+			t.Fatalf("action on %s: status %q, want %q (actions: %+v)", id, got[id], wantStatus, report.Actions)
+		}
+	}
+}
+
+func TestCollectInstances(t *testing.T) {
+	for _, dryRun := range []bool{true, false} {
+		t.Run(map[bool]string{true: "dry run", false: "cleanup"}[dryRun], func(t *testing.T) {
+			c := newTestCollector(t, GarbageCollectorConfig{MaxServerLifetime: 24 * time.Hour, MaxStoppedLifetime: 7 * 24 * time.Hour})
+			failed := c.launch(t, time.Hour, true)
+			c.record(t, models.ServerRecord{InstanceID: failed, State: "running", LaunchError: "docker did not become ready"})
+			expired := c.launch(t, 48*time.Hour, true)
+			young := c.launch(t, time.Hour, true)
+			neverIdle := c.launch(t, 48*time.Hour, true)
+			c.record(t, models.ServerRecord{InstanceID: neverIdle, State: "running",
+				Request: &models.MinecraftServerRequest{IdlePolicy: models.IdlePolicy{NeverIdle: true}}})
+			foreign := c.launch(t, 48*time.Hour, false)
+			// Stopped by their idle monitor: the lifetime of a stopped server counts from its stop
+			abandoned := c.launch(t, 30*24*time.Hour, true)
+			c.stop(t, abandoned, 8*24*time.Hour)
+			stopped := c.launch(t, 30*24*time.Hour, true)
+			c.stop(t, stopped, 24*time.Hour)
+			neverIdleStopped := c.launch(t, 30*24*time.Hour, true)
+			c.record(t, models.ServerRecord{InstanceID: neverIdleStopped, State: "stopped",
+				Request: &models.MinecraftServerRequest{IdlePolicy: models.IdlePolicy{NeverIdle: true}}})
+			c.stop(t, neverIdleStopped, 8*24*time.Hour)
+
+			report := &models.CleanupReport{DryRun: dryRun}
+			if err := c.collectInstances(context.Background(), report); err != nil {
+				t.Fatal(err)
+			}
+			checkActions(t, report, failed, expired, abandoned, neverIdleStopped)
+
+			for _, id := range []string{failed, expired, young, neverIdle, foreign, abandoned, stopped, neverIdleStopped} {
+				state := c.instanceState(t, id)
+				terminated := state == types.InstanceStateNameShuttingDown || state == types.InstanceStateNameTerminated
+				collected := id == failed || id == expired || id == abandoned || id == neverIdleStopped
+				if wantTerminated := !dryRun && collected; terminated != wantTerminated {
+					t.Errorf("instance %s is %s, want it terminated: %t", id, state, wantTerminated)
+				}
+			}
+		})
+	}
+}
+
+func TestStoppedSince(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCollector(t, GarbageCollectorConfig{MaxStoppedLifetime: time.Hour})
+	c.record(t, models.ServerRecord{InstanceID: "i-recorded", State: "running"})
+	before := time.Now().Truncate(time.Second)
+	if _, err := c.registry.UpdateServerState(ctx, "i-recorded", models.ServerStateUpdate{State: "stopped"}); err != nil {
+		t.Fatal(err)
+	}
+	c.record(t, models.ServerRecord{InstanceID: "i-never-stopped", State: "running"})
+
+	cases := []struct {
+		name       string
+		instanceID string
+		reason     string
+		want       time.Time // Zero when the stop time is unknown
+	}{
+		{"from EC2", "i-recorded", "User initiated (2025-01-21 12:30:00 GMT)", time.Date(2025, 1, 21, 12, 30, 0, 0, time.UTC)},
+		{"from the registry", "i-recorded", "Server.SpotInstanceShutdown", before},
+		{"unknown", "i-never-stopped", "User initiated", time.Time{}},
+		{"not recorded", "i-unknown", "", time.Time{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			instance := types.Instance{InstanceId: aws.String(tc.instanceID), StateTransitionReason: aws.String(tc.reason)}
+			stoppedAt, ok := c.stoppedSince(ctx, instance)
+			switch {
+			case tc.want.IsZero() && ok:
+				t.Fatalf("stoppedSince() = %s, want unknown", stoppedAt)
+			case !tc.want.IsZero() && (!ok || stoppedAt.Before(tc.want) || stoppedAt.Sub(tc.want) > 2*time.Second):
+				t.Fatalf("stoppedSince() = %s, %t, want %s", stoppedAt, ok, tc.want)
+			}
+		})
+	}
+}
+
+func TestCollectSecurityGroups(t *testing.T) {
+	for _, dryRun := range []bool{true, false} {
+		t.Run(map[bool]string{true: "dry run", false: "cleanup"}[dryRun], func(t *testing.T) {
+			ctx := context.Background()
+			c := newTestCollector(t, GarbageCollectorConfig{})
+			used := c.createGroup(t, minecraftSecurityGroupName)
+			unused := c.createGroup(t, minecraftSecurityGroupName+"-old")
+			other := c.createGroup(t, "web-sg")
+			c.launch(t, 0, true, used)
+			// A terminated instance does not use its group anymore
+			gone := c.launch(t, 0, true, unused)
+			if _, err := c.fake.TerminateInstances(ctx, &ec2.TerminateInstancesInput{InstanceIds: []string{gone}}); err != nil {
+				t.Fatal(err)
+			}
+			if state := c.instanceState(t, gone); state != types.InstanceStateNameTerminated {
+				t.Fatalf("instance %s is %s, want terminated", gone, state)
+			}
+
+			report := &models.CleanupReport{DryRun: dryRun}
+			if err := c.collectSecurityGroups(ctx, report); err != nil {
+				t.Fatal(err)
+			}
+			checkActions(t, report, unused)
+
+			for _, id := range []string{used, unused, other} {
+				_, err := c.fake.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{GroupIds: []string{id}})
+				if deleted, wantDeleted := err != nil, !dryRun && id == unused; deleted != wantDeleted {
+					t.Errorf("security group %s deleted: %t, want %t", id, deleted, wantDeleted)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectVolumes(t *testing.T) {
+	for _, dryRun := range []bool{true, false} {
+		t.Run(map[bool]string{true: "dry run", false: "cleanup"}[dryRun], func(t *testing.T) {
+			ctx := context.Background()
+			c := newTestCollector(t, GarbageCollectorConfig{WorldVolumeRetention: 24 * time.Hour})
+			terminatedAgo := func(ago time.Duration) string { return time.Now().Add(-ago).UTC().Format(time.RFC3339) }
+
+			orphan := c.createVolume(t, 48*time.Hour, "alice", true)
+			ownerHasServer := c.createVolume(t, 48*time.Hour, "bob", true)
+			c.record(t, models.ServerRecord{InstanceID: "i-bob", OwnerID: "bob", State: "stopped"})
+			recentlyUsed := c.createVolume(t, 48*time.Hour, "carol", true)
+			c.record(t, models.ServerRecord{InstanceID: "i-carol", OwnerID: "carol", State: "terminated",
+				TerminatedAt: terminatedAgo(time.Hour), DataVolumeID: recentlyUsed})
+			longUnused := c.createVolume(t, 72*time.Hour, "dave", true)
+			c.record(t, models.ServerRecord{InstanceID: "i-dave", OwnerID: "dave", State: "terminated",
+				TerminatedAt: terminatedAgo(48 * time.Hour), DataVolumeID: longUnused})
+			young := c.createVolume(t, time.Hour, "erin", true)
+			notWorld := c.createVolume(t, 48*time.Hour, "frank", false)
+			attached := c.createVolume(t, 48*time.Hour, "gina", true)
+			if _, err := c.fake.AttachVolume(ctx, &ec2.AttachVolumeInput{
+				Device:     aws.String(dataVolumeDevice),
+				InstanceId: aws.String(c.launch(t, 0, false)),
+				VolumeId:   aws.String(attached),
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			report := &models.CleanupReport{DryRun: dryRun}
+			if err := c.collectVolumes(ctx, report); err != nil {
+				t.Fatal(err)
+			}
+			checkActions(t, report, orphan, longUnused)
+
+			for _, id := range []string{orphan, ownerHasServer, recentlyUsed, longUnused, young, notWorld, attached} {
+				_, err := c.fake.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{VolumeIds: []string{id}})
+				if deleted, wantDeleted := err != nil, !dryRun && (id == orphan || id == longUnused); deleted != wantDeleted {
+					t.Errorf("volume %s deleted: %t, want %t", id, deleted, wantDeleted)
+				}
+			}
+
+			// A retention of 0 keeps every volume
+			c.config.WorldVolumeRetention = 0
+			report = &models.CleanupReport{DryRun: dryRun}
+			if err := c.collectVolumes(ctx, report); err != nil || len(report.Actions) != 0 {
+				t.Fatalf("collectVolumes() without retention = %+v, %v, want nothing", report.Actions, err)
+			}
+		})
+	}
+}
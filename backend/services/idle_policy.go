@@ -0,0 +1,66 @@
+/*
+idle_policy.go
+In this file you will find the idle policy of the servers: how long a server may stay without players and what it
+does then (stop, terminate, or back the world up and stop). The policy is chosen when the server is created and
+enforced from inside the instance by minecraft-auto-shutdown.sh, which ec2-init.sh writes it into (see user_data.go).
+When it starts, the monitor reports in by tagging its instance (IdleMonitor=<time>), through the same EC2 API it later
+stops the instance with: the reconciler flags the running servers whose monitor never did, since nothing would stop them.
+*/
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+// Bounds of the idle delay, in seconds
+const (
+	minIdleDelaySeconds = 60
+	maxIdleDelaySeconds = 24 * 60 * 60
+)
+
+/*
+validateIdlePolicy() => checks the idle policy of a request, once its defaults are set. Whether the requester may use
+never_idle is checked by the handler, which knows who the requester is.
+*/
+func (s *MinecraftService) validateIdlePolicy(policy models.IdlePolicy) error {
+	if policy.DelaySeconds < minIdleDelaySeconds || policy.DelaySeconds > maxIdleDelaySeconds {
+		return fmt.Errorf("%w: idle_policy.delay_seconds must be between %d and %d", ErrInvalidServerRequest, minIdleDelaySeconds, maxIdleDelaySeconds)
+	}
+	switch policy.Action {
+	case models.IdleActionStop, models.IdleActionTerminate:
+	case models.IdleActionHibernate:
+		if !s.backups.Enabled() {
+			return fmt.Errorf("%w: idle_policy.action hibernate needs backups: %v", ErrInvalidServerRequest, ErrBackupsDisabled)
+		}
+	default:
+		return fmt.Errorf("%w: idle_policy.action must be %s, %s or %s", ErrInvalidServerRequest,
+			models.IdleActionStop, models.IdleActionTerminate, models.IdleActionHibernate)
+	}
+	return nil
+}
+
+// Running instances whose idle monitor has not reported in this long after their launch are flagged.
+const idleMonitorGracePeriod = 15 * time.Minute
+
+/*
+idleMonitorSilent() => reports whether the idle monitor of an instance should have reported in during its current boot
+and did not. Servers that never go idle have no monitor running.
+*/
+func idleMonitorSilent(instance types.Instance, record *models.ServerRecord, now time.Time) bool {
+	if instance.State == nil || instance.State.Name != types.InstanceStateNameRunning || instance.LaunchTime == nil {
+		return false
+	}
+	if record.Request != nil && record.Request.IdlePolicy.NeverIdle {
+		return false
+	}
+	if now.Sub(*instance.LaunchTime) < idleMonitorGracePeriod {
+		return false
+	}
+	// A report from a previous boot does not count (the clock of the instance may be slightly off)
+	reportedAt, err := time.Parse(time.RFC3339, instanceTags(instance)[idleMonitorTagKey])
+	return err != nil || reportedAt.Before(instance.LaunchTime.Add(-time.Minute))
+}
@@ -8,35 +8,90 @@ package services
 //Libraries that are currently needed for this service.
 import (
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/storage"
 )
 
 //Structure that defines that a MinecraftService, which is in fact a instance of an object of type ec2_sercice as well.
 //Basically, it includes the definition of the different methods that are currently in ec2_service.go
 type MinecraftService struct {
 	ec2Service *EC2Service
+	registry   storage.ServerRepository // Record of every server created, and their secrets (never returned to clients)
+
+	// Read-locked by launches from the moment they pick a security group until RunInstances returns, and write-locked
+	// by the GarbageCollector while it deletes unused groups, so a group is never deleted right before being used.
+	launchMu sync.RWMutex
+
+	backups   *BackupService   // Backups of the servers, set by NewBackupService() (nil: no backups)
+	worlds    *WorldService    // Uploaded worlds, set by NewWorldService() (nil: no uploads)
+	callbacks *CallbackService // Lifecycle callbacks of the servers, set by NewCallbackService() (nil: no callbacks)
+	trials    *TrialService    // Trial attempts of the users, set by NewTrialService() (nil: creations are not charged)
 }
 
 // NewMinecraftService() creates a new Minecraft service instance
-func NewMinecraftService(ec2Service *EC2Service) *MinecraftService {
+func NewMinecraftService(ec2Service *EC2Service, registry storage.ServerRepository) *MinecraftService {
 	return &MinecraftService{
 		ec2Service: ec2Service,
+		registry:   registry,
 	}
 }
 
-// CreateMinecraftServer() => creates an EC2 instance with Docker and Minecraft server
-func (s *MinecraftService) CreateMinecraftServer(req models.MinecraftServerRequest) (*models.MinecraftServerResponse, error) {
+// Port of the Minecraft remote console (RCON), opened next to the game port 25565.
+const rconPort = 25575
+
+// Name of the security group shared by the Minecraft servers (the GarbageCollector deletes it when unused).
+const minecraftSecurityGroupName = "minecraft-server-sg"
+
+// Tags identifying the instances created by this backend, and who they belong to.
+const (
+	serverTypeTagKey   = "Type"
+	serverTypeTagValue = "MinecraftServer"
+	createdByTagKey    = "CreatedBy"
+	createdByTagValue  = "MinecraftServerGenerator"
+	ownerTagKey        = "OwnerID"
+	idleMonitorTagKey  = "IdleMonitor" // Set by the idle monitor of the instance when it starts, to the time it did
+)
+
+// Requester identifies who is calling the API, so the service can check who may manage which server.
+type Requester struct {
+	UserID string // Supabase user ID ("sub" claim) or owner of the API key; empty for the API_KEY of the operator
+	Admin  bool   // Admins (API_KEY, admin API keys and ADMIN_USER_IDS) may manage every Minecraft server
+}
+
+var (
+	// ErrInvalidServerRequest is returned (wrapped) when a MinecraftServerRequest fails validation.
+	ErrInvalidServerRequest = errors.New("invalid server request")
+	// ErrServerNotFound is returned (wrapped) when a server ID does not match any known server.
+	ErrServerNotFound = errors.New("server not found")
+	// ErrServerNotRunning is returned (wrapped) when an operation needs a running server.
+	ErrServerNotRunning = errors.New("server is not running")
+	// ErrServerAccessDenied is returned (wrapped) when the requester does not own the server.
+	ErrServerAccessDenied = errors.New("you do not own this server")
+	// ErrCredentialsNotFound is returned when no RCON password is known for a server (it was created before RCON support).
+	ErrCredentialsNotFound = errors.New("no credentials stored for this server")
+)
+
+/*
+PhaseReporter is called by CreateMinecraftServer() every time the creation moves to a new phase
+(security_group, launch, running). server is nil until the instance has been launched.
+*/
+type PhaseReporter func(phase models.ProvisioningPhase, server *models.MinecraftServerResponse)
+
+// PrepareRequest() => fills the defaults of a request and validates it, before any AWS resource is touched.
+func (s *MinecraftService) PrepareRequest(req *models.MinecraftServerRequest) error {
 	// Set defaults
 	req.SetDefaults()
 
@@ -54,31 +109,141 @@ func (s *MinecraftService) CreateMinecraftServer(req models.MinecraftServerReque
 
 	// Validate EULA
 	if !req.EULA {
-		return nil, fmt.Errorf("you must accept the Minecraft EULA by setting 'eula' to true")
+		return fmt.Errorf("%w: you must accept the Minecraft EULA by setting 'eula' to true", ErrInvalidServerRequest)
+	}
+
+	// Validate the data volume
+	if req.DataVolumeID != "" && !strings.HasPrefix(req.DataVolumeID, "vol-") {
+		return fmt.Errorf("%w: data_volume_id must be an EBS volume ID (vol-...)", ErrInvalidServerRequest)
+	}
+	if req.DataVolume && (req.DataVolumeSizeGiB < 1 || req.DataVolumeSizeGiB > maxDataVolumeSizeGiB) {
+		return fmt.Errorf("%w: data_volume_size_gib must be between 1 and %d", ErrInvalidServerRequest, maxDataVolumeSizeGiB)
+	}
+
+	// Validate the level name: it is the folder of the world in /opt/minecraft-data
+	if strings.ContainsAny(req.LevelName, "/\\") || req.LevelName == "." || req.LevelName == ".." {
+		return fmt.Errorf("%w: level_name must be a folder name", ErrInvalidServerRequest)
+	}
+
+	// Validate the idle policy
+	if err := s.validateIdlePolicy(req.IdlePolicy); err != nil {
+		return err
+	}
+
+	// Validate the trial the server is charged on
+	if req.Trial != models.TrialTest && req.Trial != models.TrialCustom {
+		return fmt.Errorf("%w: trial must be %s or %s", ErrInvalidServerRequest, models.TrialTest, models.TrialCustom)
+	}
+
+	// Validate the restore: a reused data volume already has its world
+	req.RestoreFromBackup = strings.TrimSpace(req.RestoreFromBackup)
+	if req.RestoreFromBackup != "" && req.DataVolumeID != "" {
+		return fmt.Errorf("%w: restore_from_backup cannot be used with data_volume_id", ErrInvalidServerRequest)
+	}
+
+	// Validate the uploaded world: a server starts with one world only
+	req.WorldID = strings.TrimSpace(req.WorldID)
+	if req.WorldID != "" && (req.RestoreFromBackup != "" || req.DataVolumeID != "") {
+		return fmt.Errorf("%w: world_id cannot be used with restore_from_backup or data_volume_id", ErrInvalidServerRequest)
+	}
+	if req.WorldID != "" && !worldIDPattern.MatchString(req.WorldID) {
+		return fmt.Errorf("%w: world_id must be the ID returned by POST /minecraft/worlds (world-...)", ErrInvalidServerRequest)
+	}
+	return nil
+}
+
+/*
+CreateMinecraftServer() => creates an EC2 instance with Docker and Minecraft server and waits until it is running.
+The request must have gone through PrepareRequest() first. If report is not nil, it is notified of every phase.
+*/
+func (s *MinecraftService) CreateMinecraftServer(ctx context.Context, req models.MinecraftServerRequest, report PhaseReporter) (*models.MinecraftServerResponse, error) {
+	if report == nil {
+		report = func(models.ProvisioningPhase, *models.MinecraftServerResponse) {}
 	}
+
 	//Log to the terminal.
 	log.Printf("Creating Minecraft server: %s (Type: %s, Version: %s)", req.ServerName, req.MinecraftType, req.Version)
 
 	// Get latest Amazon Linux 2023 AMI
-	ctx := context.TODO()
 	imageID := s.ec2Service.getDefaultAMI()
 
+	// A reused data volume decides where the instance runs (volumes only attach within their zone) and which world loads
+	availabilityZone := ""
+	if req.DataVolumeID != "" {
+		zone, levelName, err := s.checkDataVolume(ctx, req.DataVolumeID, req.OwnerID)
+		if err != nil {
+			return nil, err
+		}
+		availabilityZone = zone
+		if levelName != "" && levelName != req.LevelName {
+			log.Printf("Using level name %q of data volume %s instead of %q", levelName, req.DataVolumeID, req.LevelName)
+			req.LevelName = levelName
+		}
+	}
+
+	// A restored world keeps its level name, and is never loaded by an older version of Minecraft
+	if req.RestoreFromBackup != "" {
+		source, err := s.backups.prepareRestore(ctx, req.RestoreFromBackup, req.OwnerID, req.Version)
+		if err != nil {
+			return nil, err
+		}
+		req.RestoreURL = source.URL
+		if source.LevelName != "" && source.LevelName != req.LevelName {
+			log.Printf("Using level name %q of backup %s instead of %q", source.LevelName, req.RestoreFromBackup, req.LevelName)
+			req.LevelName = source.LevelName
+		}
+	}
+
+	// An uploaded world is downloaded by the itzg image itself on first start
+	if req.WorldID != "" {
+		worldURL, err := s.worlds.worldURL(ctx, req.WorldID, req.OwnerID)
+		if err != nil {
+			return nil, err
+		}
+		req.WorldURL = worldURL
+	}
+
+	// Every server gets its own random RCON password, kept by the backend only
+	rconPassword, err := generateSecret(24)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate RCON password: %v", err)
+	}
+	req.RCONPassword = rconPassword
+
+	// And its own callback token, to sign what it reports back to the backend
+	if s.callbacks.Enabled() {
+		if req.CallbackToken, err = generateSecret(32); err != nil {
+			return nil, fmt.Errorf("failed to generate callback token: %v", err)
+		}
+	}
+
 	// Generate user data script for EC2 instance
-	userData := s.generateUserDataScript(req)
+	script, err := s.generateUserDataScript(req)
+	if err != nil {
+		return nil, err
+	}
+	userData, err := encodeUserData(script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode user data: %v", err)
+	}
 
 	// Create security group for Minecraft
+	report(models.PhaseSecurityGroup, nil)
+	s.launchMu.RLock()
 	securityGroupID, err := s.createMinecraftSecurityGroup(ctx)
 	if err != nil {
+		s.launchMu.RUnlock()
 		return nil, fmt.Errorf("failed to create security group: %v", err)
 	}
 
 	// Launch EC2 instance with user data
+	createdAt := time.Now().UTC().Format(time.RFC3339)
 	runInput := &ec2.RunInstancesInput{
 		ImageId:      aws.String(imageID),
 		InstanceType: types.InstanceType(req.InstanceType),
 		MinCount:     aws.Int32(1),
 		MaxCount:     aws.Int32(1),
-		UserData:     aws.String(base64.StdEncoding.EncodeToString([]byte(userData))),
+		UserData:     aws.String(userData),
 		
 		NetworkInterfaces: []types.InstanceNetworkInterfaceSpecification{
 			{
@@ -98,8 +263,8 @@ func (s *MinecraftService) CreateMinecraftServer(req models.MinecraftServerReque
 						Value: aws.String(req.ServerName),
 					},
 					{
-						Key:   aws.String("Type"),
-						Value: aws.String("MinecraftServer"),
+						Key:   aws.String(serverTypeTagKey),
+						Value: aws.String(serverTypeTagValue),
 					},
 					{
 						Key:   aws.String("MinecraftType"),
@@ -110,18 +275,31 @@ func (s *MinecraftService) CreateMinecraftServer(req models.MinecraftServerReque
 						Value: aws.String(req.Version),
 					},
 					{
-						Key:   aws.String("CreatedBy"),
-						Value: aws.String("MinecraftServerGenerator"),
+						Key:   aws.String(createdByTagKey),
+						Value: aws.String(createdByTagValue),
 					},
 					{
 						Key:   aws.String("CreatedAt"),
-						Value: aws.String(time.Now().Format(time.RFC3339)),
+						Value: aws.String(createdAt),
 					},
 				},
 			},
 		},
 	}
 
+	// Tag the instance with its owner, so only they can manage it afterwards
+	if req.OwnerID != "" {
+		runInput.TagSpecifications[0].Tags = append(runInput.TagSpecifications[0].Tags, types.Tag{
+			Key:   aws.String(ownerTagKey),
+			Value: aws.String(req.OwnerID),
+		})
+	}
+
+	// Launch next to the reused data volume
+	if availabilityZone != "" {
+		runInput.Placement = &types.Placement{AvailabilityZone: aws.String(availabilityZone)}
+	}
+
 	// Add key name if provided
 	if req.KeyName != "" {
 		runInput.KeyName = aws.String(req.KeyName)
@@ -133,7 +311,9 @@ func (s *MinecraftService) CreateMinecraftServer(req models.MinecraftServerReque
 	}
 
 	// Launch the instance
+	report(models.PhaseLaunch, nil)
 	result, err := s.ec2Service.client.RunInstances(ctx, runInput)
+	s.launchMu.RUnlock()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create instance: %v", err)
 	}
@@ -144,68 +324,147 @@ func (s *MinecraftService) CreateMinecraftServer(req models.MinecraftServerReque
 
 	instance := result.Instances[0]
 	instanceID := aws.ToString(instance.InstanceId)
+	// Record the server right away: from now on it exists, whatever happens next
+	record := recordFromInstance(instance)
+	record.OwnerID = req.OwnerID
+	record.ServerName = req.ServerName
+	record.ServerType = req.MinecraftType
+	record.MinecraftVersion = req.Version
+	record.CreatedAt = createdAt
+	record.Request = &req
+	if err := s.registry.CreateServer(ctx, record); err != nil {
+		log.Printf("Warning: failed to record server %s in the registry: %v", instanceID, err)
+	}
+	if err := s.registry.SaveSecret(ctx, instanceID, rconPasswordSecret, rconPassword); err != nil {
+		log.Printf("Warning: failed to store RCON password of %s, remote commands will not be available: %v", instanceID, err)
+	}
+	if req.CallbackToken != "" {
+		if err := s.registry.SaveSecret(ctx, instanceID, callbackTokenSecret, req.CallbackToken); err != nil {
+			log.Printf("Warning: failed to store callback token of %s, its callbacks will be rejected: %v", instanceID, err)
+		}
+	}
+	report(models.PhaseLaunch, buildMinecraftServerResponse(instance, req))
 
 	log.Printf("Minecraft server instance created: %s. Waiting for it to start...", instanceID)
 
 	// Wait for instance to be running
-	waiter := ec2.NewInstanceRunningWaiter(s.ec2Service.client)
-	waitInput := &ec2.DescribeInstancesInput{
-		InstanceIds: []string{instanceID},
-	}
-
-	err = waiter.Wait(ctx, waitInput, 5*time.Minute)
+	// From here on, a failure must not leave the instance running (and billed) with nobody knowing about it
+	err = s.ec2Service.waitForInstanceRunning(ctx, instanceID, 5*time.Minute)
 	if err != nil {
-		return nil, fmt.Errorf("instance failed to start: %v", err)
+		err = fmt.Errorf("instance failed to start: %v", err)
+		s.abandonLaunch(instanceID, err)
+		return nil, err
 	}
 
 	// Fetch instance details
-	describeResult, err := s.ec2Service.client.DescribeInstances(ctx, waitInput)
+	describeResult, err := s.ec2Service.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to describe instance: %v", err)
+		err = fmt.Errorf("failed to describe instance: %v", err)
+		s.abandonLaunch(instanceID, err)
+		return nil, err
 	}
 
 	if len(describeResult.Reservations) == 0 || len(describeResult.Reservations[0].Instances) == 0 {
-		return nil, fmt.Errorf("instance not found after creation")
+		err = fmt.Errorf("instance not found after creation")
+		s.abandonLaunch(instanceID, err)
+		return nil, err
 	}
 
+	// Build response
 	runningInstance := describeResult.Reservations[0].Instances[0]
-	publicIP := aws.ToString(runningInstance.PublicIpAddress)
+	s.recordState(ctx, instanceID, stateUpdateFromInstance(runningInstance))
+
+	// Attach the data volume: ec2-init.sh waits for it before starting Minecraft
+	volumeID := ""
+	if req.DataVolume {
+		volumeID, err = s.attachDataVolume(ctx, runningInstance, req)
+		if volumeID != "" {
+			if err := s.registry.SetDataVolume(ctx, instanceID, volumeID); err != nil {
+				log.Printf("Warning: failed to record data volume %s of %s: %v", volumeID, instanceID, err)
+			}
+		}
+		if err != nil {
+			s.abandonLaunch(instanceID, err)
+			return nil, err
+		}
+	}
+
+	response := buildMinecraftServerResponse(runningInstance, req)
+	response.DataVolumeID = volumeID
+	report(models.PhaseRunning, response)
+
+	log.Printf("Minecraft server successfully created: %s (IP: %s)", instanceID, response.PublicIP)
+
+	return response, nil
+}
+
+/*
+abandonLaunch() => terminates an instance whose launch failed after RunInstances succeeded. The failure is recorded
+first: if the termination fails too, the GarbageCollector finds the instance from its record and retries.
+*/
+func (s *MinecraftService) abandonLaunch(instanceID string, cause error) {
+	// The request context may be what failed (e.g. the backend is shutting down): clean up regardless
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	reason := fmt.Sprintf("launch failed: %v", cause)
+	if err := s.registry.SetLaunchError(ctx, instanceID, cause.Error()); err != nil {
+		log.Printf("Warning: failed to record launch failure of %s: %v", instanceID, err)
+	}
+	if err := s.TerminateInstance(ctx, instanceID, reason); err != nil {
+		log.Printf("Warning: failed to terminate %s after its launch failed, the garbage collector will retry: %v", instanceID, err)
+		return
+	}
+	log.Printf("Terminated %s: %s", instanceID, reason)
+}
+
+// buildMinecraftServerResponse() => builds the API representation of a Minecraft server from its EC2 instance.
+func buildMinecraftServerResponse(instance types.Instance, req models.MinecraftServerRequest) *models.MinecraftServerResponse {
+	publicIP := aws.ToString(instance.PublicIpAddress)
 
-	// Build response
 	response := &models.MinecraftServerResponse{
-		InstanceID:       instanceID,
+		InstanceID:       aws.ToString(instance.InstanceId),
 		PublicIP:         publicIP,
-		PrivateIP:        aws.ToString(runningInstance.PrivateIpAddress),
-		State:            string(runningInstance.State.Name),
-		InstanceType:     string(runningInstance.InstanceType),
-		LaunchTime:       runningInstance.LaunchTime.Format(time.RFC3339),
-		AvailabilityZone: aws.ToString(runningInstance.Placement.AvailabilityZone),
+		PrivateIP:        aws.ToString(instance.PrivateIpAddress),
+		InstanceType:     string(instance.InstanceType),
 		ServerName:       req.ServerName,
 		MinecraftVersion: req.Version,
 		ServerType:       req.MinecraftType,
 		ServerPort:       25565,
-		ServerAddress:    fmt.Sprintf("%s:25565", publicIP),
-		Message:          "Minecraft server is being set up. It may take 2-3 minutes for Docker to install and the server to start. Connect using: " + publicIP,
+		Message:          "Minecraft server is being set up. It may take 2-3 minutes for Docker to install and the server to start.",
+	}
+	if instance.State != nil {
+		response.State = string(instance.State.Name)
+	}
+	if instance.LaunchTime != nil {
+		response.LaunchTime = instance.LaunchTime.Format(time.RFC3339)
+	}
+	if instance.Placement != nil {
+		response.AvailabilityZone = aws.ToString(instance.Placement.AvailabilityZone)
+	}
+	if publicIP != "" {
+		response.ServerAddress = fmt.Sprintf("%s:25565", publicIP)
+		response.Message += " Connect using: " + publicIP
 	}
 
-	log.Printf("Minecraft server successfully created: %s (IP: %s)", instanceID, publicIP)
-
-	return response, nil
+	return response
 }
 
-// generateUserDataScript creates a cloud-init script to install Docker and run Minecraft
-func (s *MinecraftService) generateUserDataScript(req models.MinecraftServerRequest) string {
+// generateUserDataScript creates a cloud-init script to install Docker and run Minecraft (see user_data.go)
+func (s *MinecraftService) generateUserDataScript(req models.MinecraftServerRequest) (string, error) {
 	// Log the OnlineMode value for debugging
 	log.Printf("DEBUG: OnlineMode value received: %t", req.OnlineMode)
 	
-	// Build environment variables for the Docker container
+	// Build environment variables for the Docker container, unquoted: the template quotes them (see user_data.go)
 	envVars := []string{
 		fmt.Sprintf("EULA=%t", req.EULA),
 		fmt.Sprintf("TYPE=%s", req.MinecraftType),
 		fmt.Sprintf("VERSION=%s", req.Version),
 		fmt.Sprintf("MEMORY=%s", req.Memory),
 		fmt.Sprintf("MAX_PLAYERS=%d", req.MaxPlayers),
-		fmt.Sprintf("MOTD=\"%s\"", req.MOTD),  // Quote MOTD to handle spaces
+		fmt.Sprintf("MOTD=%s", req.MOTD),
 		fmt.Sprintf("DIFFICULTY=%s", req.Difficulty),
 		fmt.Sprintf("MODE=%s", req.Gamemode),
 		fmt.Sprintf("PVP=%t", req.PVP),
@@ -222,7 +481,7 @@ func (s *MinecraftService) generateUserDataScript(req models.MinecraftServerRequ
 	// Removing the incorrect implementation.
 
 	if req.LevelSeed != "" {
-		envVars = append(envVars, fmt.Sprintf("SEED=\"%s\"", req.LevelSeed))  // Quote SEED for negative values
+		envVars = append(envVars, fmt.Sprintf("SEED=%s", req.LevelSeed))
 	}
 	if req.LevelName != "" {
 		envVars = append(envVars, fmt.Sprintf("LEVEL=%s", req.LevelName))
@@ -230,39 +489,38 @@ func (s *MinecraftService) generateUserDataScript(req models.MinecraftServerRequ
 
 	// Add modpack URL if provided
 	if req.ModPackURL != "" {
-		envVars = append(envVars, fmt.Sprintf("MODPACK=\"%s\"", req.ModPackURL))  // Quote URL
+		envVars = append(envVars, fmt.Sprintf("MODPACK=%s", req.ModPackURL))
 	}
 
 	// Add plugin URLs if provided
 	if len(req.PluginURLs) > 0 {
-		envVars = append(envVars, fmt.Sprintf("PLUGINS=\"%s\"", strings.Join(req.PluginURLs, ",")))  // Quote plugin URLs
+		envVars = append(envVars, fmt.Sprintf("PLUGINS=%s", strings.Join(req.PluginURLs, ",")))
 	}
 
-	// Build Docker run command environment flags
-	dockerEnvFlags := ""
-	for _, env := range envVars {
-		// Don't add extra quotes around the environment variable
-		// Docker -e flag handles: -e KEY=VALUE
-		dockerEnvFlags += fmt.Sprintf(" -e %s", env)
-	}
-	
-	log.Printf("DEBUG: Docker environment flags: %s", dockerEnvFlags)
+	// Docker -e flag handles: -e KEY=VALUE (the template adds the flags and quotes each KEY=VALUE for the shell)
+	log.Printf("DEBUG: Docker environment flags: -e %s", strings.Join(envVars, " -e "))
 
-	// Read the EC2 initialization script from file
-	scriptPath := filepath.Join("scripts", "ec2-init.sh")
-	scriptContent, err := os.ReadFile(scriptPath)
-	if err != nil {
-		log.Printf("Error reading EC2 init script: %v. Using fallback script.", err)
-		// Fallback to a minimal script if file read fails
-		return `#!/bin/bash
-echo "Error: Could not load EC2 initialization script" >> /var/log/minecraft-setup.log
-exit 1`
-	}
+	// RCON is added after the debug logs, so the password never ends up in them
+	envVars = append(envVars, "ENABLE_RCON=true", fmt.Sprintf("RCON_PORT=%d", rconPort), "RCON_PASSWORD="+req.RCONPassword)
 
-	// Inject the Docker environment flags into the script template
-	userData := fmt.Sprintf(string(scriptContent), dockerEnvFlags)
+	// So is the pre-signed download URL of the uploaded world (the image unpacks it as the level on first start)
+	if req.WorldURL != "" {
+		envVars = append(envVars, fmt.Sprintf("WORLD=%s", req.WorldURL))
+	}
 
-	return userData
+	data := userDataContext{
+		DockerEnv:  envVars,
+		RestoreURL: req.RestoreURL,
+		Backups:    s.backups.instanceSettings(req),
+		IdlePolicy: req.IdlePolicy,
+		Region:     s.ec2Service.Region(),
+		Callbacks:  s.callbacks.instanceSettings(req.CallbackToken),
+	}
+	// The script waits for the data volume and mounts it when the server has one
+	if req.DataVolume {
+		data.DataVolumeDevice = dataVolumeDevice
+	}
+	return renderUserData(data)
 }
 
 // createMinecraftSecurityGroup creates a security group with Minecraft port open
@@ -272,20 +530,22 @@ func (s *MinecraftService) createMinecraftSecurityGroup(ctx context.Context) (st
 		Filters: []types.Filter{
 			{
 				Name:   aws.String("group-name"),
-				Values: []string{"minecraft-server-sg"},
+				Values: []string{minecraftSecurityGroupName},
 			},
 		},
 	}
 
 	describeResult, err := s.ec2Service.client.DescribeSecurityGroups(ctx, describeInput)
 	if err == nil && len(describeResult.SecurityGroups) > 0 {
-		// Security group already exists
-		return aws.ToString(describeResult.SecurityGroups[0].GroupId), nil
+		// Security group already exists, but it may predate some of the rules (e.g. RCON)
+		group := describeResult.SecurityGroups[0]
+		s.authorizeMissingIngressRules(ctx, group)
+		return aws.ToString(group.GroupId), nil
 	}
 
 	// Create new security group
 	createInput := &ec2.CreateSecurityGroupInput{
-		GroupName:   aws.String("minecraft-server-sg"),
+		GroupName:   aws.String(minecraftSecurityGroupName),
 		Description: aws.String("Security group for Minecraft servers - allows port 25565"),
 	}
 
@@ -296,49 +556,223 @@ func (s *MinecraftService) createMinecraftSecurityGroup(ctx context.Context) (st
 
 	groupID := aws.ToString(createResult.GroupId)
 
-	// Add ingress rules for Minecraft port (25565) and SSH (22)
+	// Add ingress rules for Minecraft port (25565), SSH (22) and RCON (25575)
 	authorizeInput := &ec2.AuthorizeSecurityGroupIngressInput{
-		GroupId: aws.String(groupID),
-		IpPermissions: []types.IpPermission{
-			{
-				// Minecraft port
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int32(25565),
-				ToPort:     aws.Int32(25565),
-				IpRanges: []types.IpRange{
-					{
-						CidrIp:      aws.String("0.0.0.0/0"),
-						Description: aws.String("Minecraft server port"),
-					},
+		GroupId:       aws.String(groupID),
+		IpPermissions: minecraftIngressRules(),
+	}
+
+	_, err = s.ec2Service.client.AuthorizeSecurityGroupIngress(ctx, authorizeInput)
+	if err != nil {
+		log.Printf("Warning: Failed to add ingress rules (may already exist): %v", err)
+	}
+
+	log.Printf("Security group created: %s", groupID)
+	return groupID, nil
+}
+
+/*
+minecraftIngressRules() => the ports opened on Minecraft servers. RCON is reachable from RCON_ALLOWED_CIDR only
+(e.g. the backend's egress IP); it defaults to everywhere since the port is protected by a per-server password.
+*/
+func minecraftIngressRules() []types.IpPermission {
+	rconCIDR := os.Getenv("RCON_ALLOWED_CIDR")
+	if rconCIDR == "" {
+		rconCIDR = "0.0.0.0/0"
+	}
+
+	return []types.IpPermission{
+		{
+			// Minecraft port
+			IpProtocol: aws.String("tcp"),
+			FromPort:   aws.Int32(25565),
+			ToPort:     aws.Int32(25565),
+			IpRanges: []types.IpRange{
+				{
+					CidrIp:      aws.String("0.0.0.0/0"),
+					Description: aws.String("Minecraft server port"),
 				},
 			},
-			{
-				// SSH port (for administration)
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int32(22),
-				ToPort:     aws.Int32(22),
-				IpRanges: []types.IpRange{
-					{
-						CidrIp:      aws.String("0.0.0.0/0"),
-						Description: aws.String("SSH access"),
-					},
+		},
+		{
+			// SSH port (for administration)
+			IpProtocol: aws.String("tcp"),
+			FromPort:   aws.Int32(22),
+			ToPort:     aws.Int32(22),
+			IpRanges: []types.IpRange{
+				{
+					CidrIp:      aws.String("0.0.0.0/0"),
+					Description: aws.String("SSH access"),
+				},
+			},
+		},
+		{
+			// RCON port (remote console used by the backend)
+			IpProtocol: aws.String("tcp"),
+			FromPort:   aws.Int32(rconPort),
+			ToPort:     aws.Int32(rconPort),
+			IpRanges: []types.IpRange{
+				{
+					CidrIp:      aws.String(rconCIDR),
+					Description: aws.String("Minecraft RCON"),
 				},
 			},
 		},
 	}
+}
 
-	_, err = s.ec2Service.client.AuthorizeSecurityGroupIngress(ctx, authorizeInput)
+// authorizeMissingIngressRules() => adds to an existing security group the rules it does not have yet.
+func (s *MinecraftService) authorizeMissingIngressRules(ctx context.Context, group types.SecurityGroup) {
+	var missing []types.IpPermission
+	for _, rule := range minecraftIngressRules() {
+		found := false
+		for _, existing := range group.IpPermissions {
+			if aws.ToString(existing.IpProtocol) == aws.ToString(rule.IpProtocol) &&
+				aws.ToInt32(existing.FromPort) == aws.ToInt32(rule.FromPort) &&
+				aws.ToInt32(existing.ToPort) == aws.ToInt32(rule.ToPort) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, rule)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	_, err := s.ec2Service.client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       group.GroupId,
+		IpPermissions: missing,
+	})
 	if err != nil {
-		log.Printf("Warning: Failed to add ingress rules (may already exist): %v", err)
+		log.Printf("Warning: Failed to add missing ingress rules to %s: %v", aws.ToString(group.GroupId), err)
+		return
 	}
+	log.Printf("Added %d missing ingress rule(s) to security group %s", len(missing), aws.ToString(group.GroupId))
+}
 
-	log.Printf("Security group created: %s", groupID)
-	return groupID, nil
+// GetMinecraftStatus() => pings the Minecraft server at address ("ip:port"). It never fails: when the server does not
+// answer, the returned status is offline and carries the reason.
+func (s *MinecraftService) GetMinecraftStatus(address string) *models.MinecraftServerStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	status, err := PingMinecraftServer(ctx, address)
+	if err != nil {
+		return &models.MinecraftServerStatus{Online: false, Error: err.Error()}
+	}
+	return status
 }
 
-// GetInstanceInfo fetches information about a specific EC2 instance
-func (s *MinecraftService) GetInstanceInfo(instanceID string) (*models.EC2InstanceResponse, error) {
-	return s.ec2Service.GetInstanceInfo(instanceID)
+/*
+AuthorizeServerAccess() => checks that instanceID is a Minecraft server created by this backend and that the requester
+owns it. Other instances of the account are reported as not found.
+*/
+func (s *MinecraftService) AuthorizeServerAccess(ctx context.Context, instanceID string, requester Requester) error {
+	record, err := s.getServerRecord(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	if requester.Admin {
+		return nil
+	}
+	if requester.UserID == "" || record.OwnerID != requester.UserID {
+		return fmt.Errorf("%w: %s", ErrServerAccessDenied, instanceID)
+	}
+	return nil
+}
+
+/*
+ListServers() => returns the Minecraft servers of the requester (every server for admins), newest first, from the
+registry. Terminated servers are only included when includeTerminated is set.
+*/
+func (s *MinecraftService) ListServers(ctx context.Context, requester Requester, includeTerminated bool) ([]models.MinecraftServerSummary, error) {
+	if !requester.Admin && requester.UserID == "" {
+		return nil, fmt.Errorf("%w: unknown user", ErrServerAccessDenied)
+	}
+
+	filter := storage.ServerFilter{IncludeTerminated: includeTerminated}
+	if !requester.Admin {
+		filter.OwnerID = requester.UserID
+	}
+	records, err := s.registry.ListServers(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list servers: %v", err)
+	}
+	s.syncServers(ctx, records)
+
+	servers := make([]models.MinecraftServerSummary, 0, len(records))
+	for _, record := range records {
+		if record.State == "terminated" && !includeTerminated {
+			continue // Terminated while syncing
+		}
+		server := models.MinecraftServerSummary{
+			InstanceID:        record.InstanceID,
+			ServerName:        record.ServerName,
+			ServerType:        record.ServerType,
+			MinecraftVersion:  record.MinecraftVersion,
+			State:             record.State,
+			PublicIP:          record.PublicIP,
+			CreatedAt:         record.CreatedAt,
+			DataVolumeID:      record.DataVolumeID,
+			TerminatedAt:      record.TerminatedAt,
+			TerminationReason: record.TerminationReason,
+		}
+		if server.PublicIP != "" {
+			server.ServerAddress = fmt.Sprintf("%s:25565", server.PublicIP)
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// instanceTags() => returns the tags of an instance as a map.
+func instanceTags(instance types.Instance) map[string]string {
+	tags := make(map[string]string, len(instance.Tags))
+	for _, tag := range instance.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags
+}
+
+/*
+ExecuteCommand() => runs a console command (e.g. "say hello", "op Steve", "weather clear") on a running server
+through RCON and returns what the server answered. The RCON password never leaves the backend.
+*/
+func (s *MinecraftService) ExecuteCommand(ctx context.Context, instanceID, command string) (string, error) {
+	command = strings.TrimPrefix(strings.TrimSpace(command), "/")
+	if command == "" || len(command) > rconMaxCommandLength {
+		return "", fmt.Errorf("%w: command must be between 1 and %d characters long", ErrInvalidServerRequest, rconMaxCommandLength)
+	}
+
+	server, err := s.GetServer(ctx, instanceID)
+	if err != nil {
+		return "", err
+	}
+	if server.State != "running" || server.PublicIP == "" {
+		return "", fmt.Errorf("%w: instance is %s", ErrServerNotRunning, server.State)
+	}
+
+	password, err := s.registry.Secret(ctx, instanceID, rconPasswordSecret)
+	if errors.Is(err, storage.ErrNotFound) {
+		return "", ErrCredentialsNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client, err := DialRCON(ctx, net.JoinHostPort(server.PublicIP, strconv.Itoa(rconPort)), password)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	return client.Execute(command)
 }
 
 // ListAllInstances returns information about all EC2 instances
@@ -346,7 +780,23 @@ func (s *MinecraftService) ListAllInstances() ([]models.EC2InstanceResponse, err
 	return s.ec2Service.ListAllInstances()
 }
 
-// StopInstance stops a running EC2 instance
-func (s *MinecraftService) StopInstance(instanceID string) error {
-	return s.ec2Service.StopInstance(instanceID)
+// StopInstance stops a running EC2 instance and records why in the registry
+func (s *MinecraftService) StopInstance(ctx context.Context, instanceID, reason string) error {
+	if err := s.ec2Service.StopInstance(instanceID); err != nil {
+		return err
+	}
+	s.recordState(ctx, instanceID, models.ServerStateUpdate{State: "stopping", Reason: reason})
+	return nil
+}
+
+// TerminateInstance terminates an EC2 instance and records why in the registry
+func (s *MinecraftService) TerminateInstance(ctx context.Context, instanceID, reason string) error {
+	_, err := s.ec2Service.client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to terminate instance: %v", err)
+	}
+	s.recordState(ctx, instanceID, models.ServerStateUpdate{State: "shutting-down", Reason: reason})
+	return nil
 }
@@ -0,0 +1,305 @@
+/*
+provisioning_service.go
+In this file you will find the ProvisioningService, which runs server creations in the background.
+POST /minecraft/create only validates the request and queues a job; a pool of workers then creates the
+security group, launches the instance, waits for it to run and finally waits until Docker and the Minecraft
+server inside it are ready. Every step is recorded in the job, which can be polled with GET /minecraft/jobs/:id.
+Creations charged on a trial take the attempt when queued and give it back when they fail (see trial_service.go).
+*/
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+var (
+	// ErrJobNotFound is returned when a provisioning job ID is unknown (or already expired).
+	ErrJobNotFound = errors.New("provisioning job not found")
+	// ErrProvisioningQueueFull is returned when too many creations are already waiting for a worker.
+	ErrProvisioningQueueFull = errors.New("too many servers are being created right now, please try again in a few minutes")
+)
+
+// ProvisioningService owns the provisioning jobs and the worker pool that runs them.
+type ProvisioningService struct {
+	minecraftService *MinecraftService
+	broker           *EventBroker
+	monitor          *ServerMonitor
+
+	mu   sync.RWMutex
+	jobs map[string]*models.ProvisioningJob
+
+	queue        chan provisioningTask
+	workers      int
+	readyTimeout time.Duration // How long to wait for Docker + Minecraft once the instance is running
+	jobRetention time.Duration // Finished jobs are forgotten after this long
+	startOnce    sync.Once
+}
+
+// provisioningTask is what goes through the queue: the job to update and the (already validated) request.
+type provisioningTask struct {
+	jobID string
+	req   models.MinecraftServerRequest
+}
+
+// NewProvisioningService() => creates the provisioning service. Workers are not started until Start() is called.
+func NewProvisioningService(minecraftService *MinecraftService, broker *EventBroker, monitor *ServerMonitor, workers int) *ProvisioningService {
+	if workers < 1 {
+		workers = 1
+	}
+	return &ProvisioningService{
+		minecraftService: minecraftService,
+		broker:           broker,
+		monitor:          monitor,
+		jobs:             make(map[string]*models.ProvisioningJob),
+		queue:            make(chan provisioningTask, workers*8),
+		workers:          workers,
+		readyTimeout:     15 * time.Minute,
+		jobRetention:     24 * time.Hour,
+	}
+}
+
+// Start() => launches the worker goroutines. Calling it more than once has no effect.
+func (p *ProvisioningService) Start() {
+	p.startOnce.Do(func() {
+		for i := 0; i < p.workers; i++ {
+			go p.worker()
+		}
+		log.Printf("Provisioning service started with %d workers", p.workers)
+	})
+}
+
+/*
+Submit() => validates a creation request, consumes the trial attempt it is charged on, and queues it. The returned job
+is in the "queued" phase. The attempt is given back when the creation fails.
+*/
+func (p *ProvisioningService) Submit(ctx context.Context, req models.MinecraftServerRequest) (*models.ProvisioningJob, error) {
+	if err := p.minecraftService.PrepareRequest(&req); err != nil {
+		return nil, err
+	}
+	trials := p.minecraftService.trials
+	if !trials.Enabled() {
+		req.ChargeTrial = false
+	}
+	if req.ChargeTrial {
+		if err := trials.Consume(ctx, req.OwnerID, req.Trial); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	job := &models.ProvisioningJob{
+		JobID:     newJobID(),
+		Phase:     models.PhaseQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+		History:   []models.ProvisioningPhaseEntry{{Phase: models.PhaseQueued, At: now}},
+		OwnerID:   req.OwnerID,
+	}
+
+	p.mu.Lock()
+	p.pruneExpiredJobs()
+	p.jobs[job.JobID] = job
+	p.mu.Unlock()
+
+	// Never block the HTTP request: if the queue is full, reject the job right away.
+	select {
+	case p.queue <- provisioningTask{jobID: job.JobID, req: req}:
+	default:
+		p.mu.Lock()
+		delete(p.jobs, job.JobID)
+		p.mu.Unlock()
+		trials.refundTrial(req)
+		return nil, ErrProvisioningQueueFull
+	}
+
+	log.Printf("Provisioning job %s queued for server %s", job.JobID, req.ServerName)
+	return p.GetJob(job.JobID)
+}
+
+// GetJob() => returns a snapshot of a job, safe to serialize while the worker keeps updating the original.
+func (p *ProvisioningService) GetJob(jobID string) (*models.ProvisioningJob, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	job, ok := p.jobs[jobID]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	snapshot := *job
+	snapshot.History = append([]models.ProvisioningPhaseEntry(nil), job.History...)
+	if job.Server != nil {
+		server := *job.Server
+		snapshot.Server = &server
+	}
+	return &snapshot, nil
+}
+
+// worker() => runs queued jobs one after another until the process exits.
+func (p *ProvisioningService) worker() {
+	for task := range p.queue {
+		p.run(task)
+	}
+}
+
+// run() => executes a single provisioning job, recording every phase and the final outcome.
+func (p *ProvisioningService) run(task provisioningTask) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute+p.readyTimeout)
+	defer cancel()
+
+	server, err := p.minecraftService.CreateMinecraftServer(ctx, task.req, func(phase models.ProvisioningPhase, server *models.MinecraftServerResponse) {
+		if server != nil {
+			// From now on the instance ID and the job ID name the same event stream.
+			p.broker.Alias(server.InstanceID, task.jobID)
+		}
+		p.setPhase(task.jobID, phase, server)
+	})
+	if err != nil {
+		p.fail(task.jobID, err)
+		p.minecraftService.trials.refundTrial(task.req)
+		return
+	}
+
+	// The instance is running: follow its events until Docker and then Minecraft itself are ready. A server that never
+	// gets ready is terminated before its attempt is given back, so a failed creation never leaves a free server behind.
+	if err := p.waitUntilReady(ctx, task.jobID, server.InstanceID); err != nil {
+		p.fail(task.jobID, err)
+		p.minecraftService.abandonLaunch(server.InstanceID, err)
+		p.minecraftService.trials.refundTrial(task.req)
+		return
+	}
+
+	log.Printf("Provisioning job %s finished: %s is ready at %s", task.jobID, server.InstanceID, server.ServerAddress)
+}
+
+/*
+waitUntilReady() => listens to the job event stream, fed by the ServerMonitor, and moves the job to docker_ready and
+minecraft_ready as the instance reports the corresponding setup stages.
+*/
+func (p *ProvisioningService) waitUntilReady(ctx context.Context, jobID, instanceID string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.readyTimeout)
+	defer cancel()
+
+	var lastEventID int64
+	dockerReady := false
+	for {
+		events, unsubscribe := p.broker.Subscribe(jobID, lastEventID)
+		p.monitor.Watch(instanceID, jobID)
+
+		for open := true; open; {
+			var event models.ServerEvent
+			select {
+			case <-ctx.Done():
+				unsubscribe()
+				if !dockerReady {
+					return fmt.Errorf("docker did not become ready: timed out after %s", p.readyTimeout)
+				}
+				return fmt.Errorf("minecraft server did not become ready: timed out after %s", p.readyTimeout)
+			case event, open = <-events:
+			}
+			if !open {
+				break
+			}
+			lastEventID = event.ID
+
+			switch {
+			case event.Type == models.EventEC2State && event.State != "running" && event.State != "pending":
+				unsubscribe()
+				return fmt.Errorf("instance is %s before the Minecraft server became ready", event.State)
+			case event.Stage == stageDockerReady && !dockerReady:
+				dockerReady = true
+				p.setPhase(jobID, models.PhaseDockerReady, nil)
+			case event.Stage == stageMinecraftReady:
+				unsubscribe()
+				if !dockerReady {
+					p.setPhase(jobID, models.PhaseDockerReady, nil)
+				}
+				p.setPhase(jobID, models.PhaseMinecraftReady, nil)
+				return nil
+			}
+		}
+		// The subscription was dropped for being too slow: resubscribe, resuming after the last event seen.
+		unsubscribe()
+	}
+}
+
+// setPhase() => moves a job to a new phase. Passing a nil server keeps the server details already recorded.
+func (p *ProvisioningService) setPhase(jobID string, phase models.ProvisioningPhase, server *models.MinecraftServerResponse) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.setPhaseLocked(jobID, phase, server, "")
+}
+
+// fail() => marks a job as failed with the given error.
+func (p *ProvisioningService) fail(jobID string, err error) {
+	log.Printf("Provisioning job %s failed: %v", jobID, err)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.setPhaseLocked(jobID, models.PhaseFailed, nil, err.Error())
+}
+
+// setPhaseLocked() => updates the job fields. Must be called with p.mu held.
+func (p *ProvisioningService) setPhaseLocked(jobID string, phase models.ProvisioningPhase, server *models.MinecraftServerResponse, errMessage string) {
+	job, ok := p.jobs[jobID]
+	if !ok {
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	if job.Phase != phase {
+		job.Phase = phase
+		job.History = append(job.History, models.ProvisioningPhaseEntry{Phase: phase, At: now})
+
+		event := models.ServerEvent{Type: models.EventPhase, Stage: string(phase), Message: "Provisioning phase: " + string(phase), At: now}
+		if phase == models.PhaseFailed {
+			event.Type = models.EventFailed
+			event.Message = errMessage
+		}
+		if server != nil {
+			event.InstanceID = server.InstanceID
+		} else if job.Server != nil {
+			event.InstanceID = job.Server.InstanceID
+		}
+		p.broker.Publish(jobID, event)
+	}
+	if server != nil {
+		job.Server = server
+	}
+	if errMessage != "" {
+		job.Error = errMessage
+	}
+	job.Done = phase == models.PhaseMinecraftReady || phase == models.PhaseFailed
+	job.UpdatedAt = now
+}
+
+// pruneExpiredJobs() => forgets finished jobs older than the retention period. Must be called with p.mu held.
+func (p *ProvisioningService) pruneExpiredJobs() {
+	for id, job := range p.jobs {
+		updatedAt, err := time.Parse(time.RFC3339, job.UpdatedAt)
+		if job.Done && err == nil && time.Since(updatedAt) > p.jobRetention {
+			delete(p.jobs, id)
+		}
+	}
+}
+
+// newJobID() => returns a random, URL-safe job identifier.
+func newJobID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		// crypto/rand never fails on the platforms we run on; fall back to the clock just in case.
+		return fmt.Sprintf("job-%d", time.Now().UnixNano())
+	}
+	return "job-" + hex.EncodeToString(buf)
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/access"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/storage"
+)
+
+// testCloud is the backend wired as main.go does with EC2_BACKEND=fake, with delays short enough for the tests.
+type testCloud struct {
+	fake         *FakeEC2
+	minecraft    *MinecraftService
+	provisioning *ProvisioningService
+	monitor      *ServerMonitor
+	policy       *access.StaticPolicy
+}
+
+// newTestCloud() => returns the backend on a fake cloud whose instances boot (and print their setup stages) in a
+// few tens of milliseconds. The owner "player" is approved, with one custom trial attempt.
+func newTestCloud(t *testing.T) *testCloud {
+	t.Helper()
+	t.Setenv("AWS_REGION", "us-east-1")
+	fake := NewFakeEC2("us-east-1")
+	fake.BootDelay = 20 * time.Millisecond
+	fake.StopDelay = 20 * time.Millisecond
+	fake.BootConsole = SimulatedBootConsole(10 * time.Millisecond)
+	ec2Service := NewEC2ServiceWithClient(fake)
+	ec2Service.SetPollInterval(10 * time.Millisecond)
+
+	registry, err := storage.NewSQLiteRegistry(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { registry.Close() })
+
+	minecraftService := NewMinecraftService(ec2Service, registry)
+	broker := NewEventBroker()
+	monitor := NewServerMonitor(ec2Service, broker)
+	provisioning := NewProvisioningService(minecraftService, broker, monitor, 2)
+	provisioning.readyTimeout = 5 * time.Second
+	provisioning.Start()
+
+	policy := access.NewStaticPolicy(map[string]models.UserAccess{
+		"player": {UserID: "player", Approved: true, CustomServerTrialAttempts: 1},
+	})
+	NewTrialService(policy, minecraftService)
+	return &testCloud{fake: fake, minecraft: minecraftService, provisioning: provisioning, monitor: monitor, policy: policy}
+}
+
+// submit() => queues the creation of a server owned by ownerID, as POST /minecraft/create does.
+func (c *testCloud) submit(t *testing.T, ownerID string) *models.ProvisioningJob {
+	t.Helper()
+	req := testServerRequest()
+	req.OwnerID = ownerID
+	req.ChargeTrial = true
+	job, err := c.provisioning.Submit(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+// waitForJob() => polls a job until it is done, as a client of GET /minecraft/jobs/:id does.
+func (c *testCloud) waitForJob(t *testing.T, jobID string) *models.ProvisioningJob {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := c.provisioning.GetJob(jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Done {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s is not done after 10s", jobID)
+	return nil
+}
+
+// attemptsLeft() => returns the custom trial attempts of the owner "player".
+func (c *testCloud) attemptsLeft(t *testing.T) int {
+	t.Helper()
+	access, err := c.policy.UserAccess(context.Background(), "player")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return access.CustomServerTrialAttempts
+}
+
+func TestCreateListTerminate(t *testing.T) {
+	ctx := context.Background()
+	cloud := newTestCloud(t)
+	owner := Requester{UserID: "player"}
+
+	job := cloud.waitForJob(t, cloud.submit(t, "player").JobID)
+	if job.Phase != models.PhaseMinecraftReady || job.Server == nil {
+		t.Fatalf("job ended in phase %s (%s), want %s", job.Phase, job.Error, models.PhaseMinecraftReady)
+	}
+	instanceID := job.Server.InstanceID
+	if got := cloud.attemptsLeft(t); got != 0 {
+		t.Fatalf("custom attempts = %d, want 0: the creation is charged", got)
+	}
+
+	// Listed for its owner and the admins only
+	servers, err := cloud.minecraft.ListServers(ctx, owner, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 || servers[0].InstanceID != instanceID {
+		t.Fatalf("ListServers(owner) = %+v, want %s", servers, instanceID)
+	}
+	if servers, _ := cloud.minecraft.ListServers(ctx, Requester{UserID: "someone else"}, false); len(servers) != 0 {
+		t.Fatalf("ListServers(other user) = %+v, want none", servers)
+	}
+	if servers, _ := cloud.minecraft.ListServers(ctx, Requester{Admin: true}, false); len(servers) != 1 {
+		t.Fatalf("ListServers(admin) = %+v, want the server", servers)
+	}
+	if err := cloud.minecraft.AuthorizeServerAccess(ctx, instanceID, Requester{UserID: "someone else"}); err == nil {
+		t.Fatal("another user may manage the server")
+	}
+	if err := cloud.minecraft.AuthorizeServerAccess(ctx, instanceID, owner); err != nil {
+		t.Fatalf("the owner may not manage the server: %v", err)
+	}
+
+	// A second server needs a second attempt
+	if _, err := cloud.provisioning.Submit(ctx, func() models.MinecraftServerRequest {
+		req := testServerRequest()
+		req.OwnerID, req.ChargeTrial = "player", true
+		return req
+	}()); err == nil {
+		t.Fatal("a second server was queued without any attempt left")
+	}
+
+	// Terminated: gone from the list once EC2 confirms it, kept in the history
+	if _, err := cloud.minecraft.TerminateServer(ctx, instanceID, "test"); err != nil {
+		t.Fatal(err)
+	}
+	record, err := cloud.minecraft.GetServer(ctx, instanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.State != "shutting-down" && record.State != "terminated" {
+		t.Fatalf("state after terminate = %s", record.State)
+	}
+	if servers, _ := cloud.minecraft.ListServers(ctx, owner, false); len(servers) != 1 || servers[0].TerminationReason != "test" {
+		t.Fatalf("ListServers() = %+v while shutting down, want the server with its termination reason", servers)
+	}
+
+	// Once EC2 reports it terminated (recorded by the monitor), it leaves the list
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		output, err := cloud.fake.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instanceID}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		instance := output.Reservations[0].Instances[0]
+		if instance.State.Name == "terminated" {
+			cloud.minecraft.recordState(ctx, instanceID, stateUpdateFromInstance(instance))
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("instance %s is %s 5s after terminate", instanceID, instance.State.Name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if servers, _ := cloud.minecraft.ListServers(ctx, owner, false); len(servers) != 0 {
+		t.Fatalf("ListServers() = %+v after terminate, want none", servers)
+	}
+	if servers, _ := cloud.minecraft.ListServers(ctx, owner, true); len(servers) != 1 {
+		t.Fatalf("ListServers(include terminated) = %+v, want the server", servers)
+	}
+}
+
+// TestCreateNeverReady checks that a server that never gets ready is terminated, and its attempt given back.
+func TestCreateNeverReady(t *testing.T) {
+	cloud := newTestCloud(t)
+	cloud.fake.BootConsole = nil // ec2-init.sh never reports the setup stages
+	cloud.provisioning.readyTimeout = 200 * time.Millisecond
+
+	job := cloud.waitForJob(t, cloud.submit(t, "player").JobID)
+	if job.Phase != models.PhaseFailed || job.Server == nil {
+		t.Fatalf("job ended in phase %s, want %s with its server", job.Phase, models.PhaseFailed)
+	}
+	if got := cloud.attemptsLeft(t); got != 1 {
+		t.Fatalf("custom attempts = %d, want 1: a failed creation is refunded", got)
+	}
+
+	output, err := cloud.fake.DescribeInstances(context.Background(), &ec2.DescribeInstancesInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, reservation := range output.Reservations {
+		for _, instance := range reservation.Instances {
+			if state := instance.State.Name; state != "shutting-down" && state != "terminated" {
+				t.Fatalf("instance %s is %s, want it terminated", *instance.InstanceId, state)
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+/*
+rcon_client.go
+In this file you will find a client for the Source RCON protocol, which Minecraft servers implement to run
+console commands remotely (say, op, weather, whitelist...). Every packet is:
+
+	int32 length | int32 request ID | int32 type | body (ASCII, NUL terminated) | NUL
+
+with all integers in little-endian. The client authenticates first, then sends commands.
+Protocol reference: https://minecraft.wiki/w/RCON
+*/
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	rconTypeAuth         = 3 // SERVERDATA_AUTH
+	rconTypeExecCommand  = 2 // SERVERDATA_EXECCOMMAND (also the type of the auth response)
+	rconTypeResponse     = 0 // SERVERDATA_RESPONSE_VALUE
+	rconMaxCommandLength = 1446
+	rconMaxFragment      = 4096 // Minecraft splits longer responses into several packets of this size
+	rconMaxPacketLength  = rconMaxFragment + 10
+)
+
+// ErrRCONAuthFailed is returned when the server rejects the RCON password.
+var ErrRCONAuthFailed = errors.New("RCON authentication failed")
+
+// RCONClient is an authenticated RCON connection. It is not safe for concurrent use.
+type RCONClient struct {
+	conn      net.Conn
+	requestID int32
+	timeout   time.Duration
+}
+
+// DialRCON() => connects to a Minecraft RCON port ("host:port") and authenticates with password.
+func DialRCON(ctx context.Context, address, password string) (*RCONClient, error) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RCON at %s: %v", address, err)
+	}
+
+	client := &RCONClient{conn: conn, timeout: 10 * time.Second}
+	if deadline, ok := ctx.Deadline(); ok {
+		client.timeout = time.Until(deadline)
+	}
+
+	requestID, err := client.send(rconTypeAuth, password)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send RCON authentication: %v", err)
+	}
+	responseID, _, _, err := client.read()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read RCON authentication response: %v", err)
+	}
+	// The server answers with request ID -1 when the password is wrong.
+	if responseID == -1 || responseID != requestID {
+		conn.Close()
+		return nil, ErrRCONAuthFailed
+	}
+
+	return client, nil
+}
+
+// Execute() => runs a console command (without the leading "/") and returns its output.
+func (c *RCONClient) Execute(command string) (string, error) {
+	if len(command) == 0 || len(command) > rconMaxCommandLength {
+		return "", fmt.Errorf("command must be between 1 and %d bytes long", rconMaxCommandLength)
+	}
+
+	requestID, err := c.send(rconTypeExecCommand, command)
+	if err != nil {
+		return "", fmt.Errorf("failed to send RCON command: %v", err)
+	}
+
+	// Nothing marks the last fragment of a response (it may be exactly rconMaxFragment bytes long). Minecraft
+	// handles the packets of a connection in order, so an empty SERVERDATA_RESPONSE_VALUE sent after the command is
+	// answered (with "Unknown request 0") once the whole output was sent: its ID ends the response.
+	endID, err := c.send(rconTypeResponse, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to send RCON command: %v", err)
+	}
+
+	var output bytes.Buffer
+	for {
+		responseID, packetType, body, err := c.read()
+		if err != nil {
+			return "", fmt.Errorf("failed to read RCON response: %v", err)
+		}
+		if responseID == endID {
+			return output.String(), nil
+		}
+		if responseID != requestID || packetType != rconTypeResponse {
+			return "", fmt.Errorf("unexpected RCON packet (id %d, type %d)", responseID, packetType)
+		}
+		output.Write(body)
+	}
+}
+
+// Close() => closes the connection.
+func (c *RCONClient) Close() error {
+	return c.conn.Close()
+}
+
+// send() => writes a packet and returns the request ID used.
+func (c *RCONClient) send(packetType int32, body string) (int32, error) {
+	c.requestID++
+
+	var packet bytes.Buffer
+	binary.Write(&packet, binary.LittleEndian, int32(4+4+len(body)+2))
+	binary.Write(&packet, binary.LittleEndian, c.requestID)
+	binary.Write(&packet, binary.LittleEndian, packetType)
+	packet.WriteString(body)
+	packet.Write([]byte{0, 0})
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(packet.Bytes())
+	return c.requestID, err
+}
+
+// read() => reads a packet and returns its request ID, type and body.
+func (c *RCONClient) read() (int32, int32, []byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+
+	var length int32
+	if err := binary.Read(c.conn, binary.LittleEndian, &length); err != nil {
+		return 0, 0, nil, err
+	}
+	if length < 10 || length > rconMaxPacketLength {
+		return 0, 0, nil, fmt.Errorf("invalid RCON packet length %d", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.conn, payload); err != nil {
+		return 0, 0, nil, err
+	}
+
+	requestID := int32(binary.LittleEndian.Uint32(payload[0:4]))
+	packetType := int32(binary.LittleEndian.Uint32(payload[4:8]))
+	body := bytes.TrimRight(payload[8:], "\x00")
+	return requestID, packetType, body, nil
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readRCONPacket() => reads a packet as the server sees it.
+func readRCONPacket(r io.Reader) (int32, int32, string, error) {
+	var length int32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return 0, 0, "", err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, "", err
+	}
+	id := int32(binary.LittleEndian.Uint32(payload[0:4]))
+	packetType := int32(binary.LittleEndian.Uint32(payload[4:8]))
+	return id, packetType, string(bytes.TrimRight(payload[8:], "\x00")), nil
+}
+
+// writeRCONPacket() => writes a packet as the server sends it.
+func writeRCONPacket(w io.Writer, id, packetType int32, body string) error {
+	var packet bytes.Buffer
+	binary.Write(&packet, binary.LittleEndian, int32(4+4+len(body)+2))
+	binary.Write(&packet, binary.LittleEndian, id)
+	binary.Write(&packet, binary.LittleEndian, packetType)
+	packet.WriteString(body)
+	packet.Write([]byte{0, 0})
+	_, err := w.Write(packet.Bytes())
+	return err
+}
+
+// startFakeRCONServer() => listens on a local port and runs serve on the first connection. It returns the address.
+func startFakeRCONServer(t *testing.T, serve func(conn net.Conn)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}()
+	return listener.Addr().String()
+}
+
+// serveMinecraftRCON() => answers as a Minecraft server does: the auth response (ID -1 for a wrong password), the
+// output of each command (from output) in fragments of rconMaxFragment bytes, and "Unknown request" to other types.
+func serveMinecraftRCON(conn net.Conn, password string, output func(command string) string) {
+	for {
+		id, packetType, body, err := readRCONPacket(conn)
+		if err != nil {
+			return
+		}
+		switch packetType {
+		case rconTypeAuth:
+			if body != password {
+				id = -1
+			}
+			writeRCONPacket(conn, id, rconTypeExecCommand, "")
+		case rconTypeExecCommand:
+			text := output(body)
+			for { // Like Minecraft, an empty output is sent as one empty fragment
+				fragment := text[:min(len(text), rconMaxFragment)]
+				text = text[len(fragment):]
+				writeRCONPacket(conn, id, rconTypeResponse, fragment)
+				if text == "" {
+					break
+				}
+			}
+		default:
+			writeRCONPacket(conn, id, rconTypeResponse, "Unknown request 0")
+		}
+	}
+}
+
+// dialTestRCON() => connects to address with a deadline short enough to fail the test quickly on a hang.
+func dialTestRCON(t *testing.T, address, password string) (*RCONClient, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return DialRCON(ctx, address, password)
+}
+
+func TestRCONAuthFailure(t *testing.T) {
+	address := startFakeRCONServer(t, func(conn net.Conn) {
+		serveMinecraftRCON(conn, "secret", func(string) string { return "" })
+	})
+	if client, err := dialTestRCON(t, address, "wrong"); !errors.Is(err, ErrRCONAuthFailed) {
+		if client != nil {
+			client.Close()
+		}
+		t.Fatalf("DialRCON() error = %v, want ErrRCONAuthFailed", err)
+	}
+}
+
+func TestRCONExecute(t *testing.T) {
+	cases := map[string]int{
+		"empty output":         0,
+		"short output":         42,
+		"exactly one fragment": rconMaxFragment,
+		"one byte more":        rconMaxFragment + 1,
+		"three full fragments": 3 * rconMaxFragment,
+	}
+	for name, length := range cases {
+		t.Run(name, func(t *testing.T) {
+			want := strings.Repeat("x", length)
+			address := startFakeRCONServer(t, func(conn net.Conn) {
+				serveMinecraftRCON(conn, "secret", func(command string) string {
+					if command != "list" {
+						t.Errorf("fake server: command %q, want list", command)
+					}
+					return want
+				})
+			})
+			client, err := dialTestRCON(t, address, "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			// Twice: the end of the first response must not be mistaken for the second one
+			for i := 0; i < 2; i++ {
+				output, err := client.Execute("list")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if output != want {
+					t.Fatalf("Execute() returned %d bytes, want %d", len(output), len(want))
+				}
+			}
+		})
+	}
+}
+
+func TestRCONExecuteMismatchedID(t *testing.T) {
+	// Authenticates, then answers the command with the ID of another request
+	address := startFakeRCONServer(t, func(conn net.Conn) {
+		id, _, _, _ := readRCONPacket(conn)
+		writeRCONPacket(conn, id, rconTypeExecCommand, "")
+		id, _, _, _ = readRCONPacket(conn)
+		writeRCONPacket(conn, id+100, rconTypeResponse, "output")
+		io.Copy(io.Discard, conn)
+	})
+	client, err := dialTestRCON(t, address, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if output, err := client.Execute("list"); err == nil {
+		t.Fatalf("Execute() = %q, want an error for a response of another request", output)
+	}
+}
+
+func TestRCONCommandLength(t *testing.T) {
+	address := startFakeRCONServer(t, func(conn net.Conn) {
+		serveMinecraftRCON(conn, "secret", func(string) string { return "" })
+	})
+	client, err := dialTestRCON(t, address, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for _, command := range []string{"", strings.Repeat("x", rconMaxCommandLength+1)} {
+		if _, err := client.Execute(command); err == nil {
+			t.Fatalf("Execute() accepted a command of %d bytes", len(command))
+		}
+	}
+}
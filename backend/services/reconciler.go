@@ -0,0 +1,228 @@
+/*
+reconciler.go
+In this file you will find the Reconciler, a background loop that keeps the server registry in line with EC2.
+Instances change state out-of-band (minecraft-auto-shutdown.sh stops or terminates idle servers from inside the instance,
+the AWS console can stop them...), so every interval it describes all the instances tagged
+CreatedBy=MinecraftServerGenerator, records what changed, flags instances nobody owns and instances whose idle monitor
+never reported in (see idle_policy.go), and publishes lifecycle events.
+*/
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/storage"
+)
+
+// Records younger than this are not considered vanished when EC2 does not return them yet.
+const reconcileGracePeriod = 5 * time.Minute
+
+// Reconciler periodically syncs the registry with the instances that exist in EC2.
+type Reconciler struct {
+	minecraftService *MinecraftService
+	broker           *EventBroker
+	interval         time.Duration
+
+	mu              sync.Mutex
+	reportedUnowned map[string]bool // Unowned instances already reported, so they are flagged only once
+	reportedSilent  map[string]bool // Boots (<instance id>@<launch time>) whose silent idle monitor was already reported
+}
+
+// ReconcileReport summarizes a reconciliation pass.
+type ReconcileReport struct {
+	Instances int // Generator instances found in EC2
+	Recorded  int // Instances the registry did not know about
+	Changed   int // Servers whose state changed
+	Vanished  int // Recorded servers that do not exist in EC2 anymore
+	Unowned   int // Instances without a known owner
+	Silent    int // Running instances whose idle monitor never reported in
+}
+
+// NewReconciler() => creates a reconciler running every interval (1 minute when interval is not positive).
+func NewReconciler(minecraftService *MinecraftService, broker *EventBroker, interval time.Duration) *Reconciler {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &Reconciler{
+		minecraftService: minecraftService,
+		broker:           broker,
+		interval:         interval,
+		reportedUnowned:  make(map[string]bool),
+		reportedSilent:   make(map[string]bool),
+	}
+}
+
+// Run() => reconciles right away and then every interval, until ctx is cancelled.
+func (r *Reconciler) Run(ctx context.Context) {
+	log.Printf("Reconciler started (every %s)", r.interval)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		passCtx, cancel := context.WithTimeout(ctx, r.interval)
+		report, err := r.ReconcileOnce(passCtx)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Warning: reconciliation failed: %v", err)
+		} else if report.Recorded+report.Changed+report.Vanished > 0 {
+			log.Printf("Reconciled %d instances: %d recorded, %d changed, %d vanished, %d unowned, %d with a silent idle monitor",
+				report.Instances, report.Recorded, report.Changed, report.Vanished, report.Unowned, report.Silent)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Reconciler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReconcileOnce() => runs a single reconciliation pass.
+func (r *Reconciler) ReconcileOnce(ctx context.Context) (ReconcileReport, error) {
+	var report ReconcileReport
+	ms := r.minecraftService
+
+	instances, err := ms.ec2Service.describeInstancesPages(ctx, []types.Filter{
+		{Name: aws.String("tag:" + createdByTagKey), Values: []string{createdByTagValue}},
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to describe generator instances: %v", err)
+	}
+	report.Instances = len(instances)
+
+	seen := make(map[string]bool, len(instances))
+	for _, instance := range instances {
+		instanceID := aws.ToString(instance.InstanceId)
+		seen[instanceID] = true
+		update := stateUpdateFromInstance(instance)
+
+		record, err := ms.registry.GetServer(ctx, instanceID)
+		if errors.Is(err, storage.ErrNotFound) {
+			record = recordFromInstance(instance)
+			if err := ms.registry.CreateServer(ctx, record); err != nil {
+				log.Printf("Warning: failed to record instance %s: %v", instanceID, err)
+				continue
+			}
+			report.Recorded++
+			r.publish(instanceID, models.ServerEvent{
+				Type:    models.EventEC2State,
+				State:   update.State,
+				Message: "Instance found in EC2 and recorded, it is " + update.State,
+			})
+		} else if err != nil {
+			log.Printf("Warning: failed to read server %s: %v", instanceID, err)
+			continue
+		}
+
+		changed, err := ms.registry.UpdateServerState(ctx, instanceID, update)
+		if err != nil {
+			log.Printf("Warning: failed to record state of %s: %v", instanceID, err)
+			continue
+		}
+		if changed {
+			report.Changed++
+			message := "Instance is " + update.State
+			if update.Reason != "" {
+				message += " (" + update.Reason + ")"
+			}
+			r.publish(instanceID, models.ServerEvent{Type: models.EventEC2State, State: update.State, Message: message})
+		}
+
+		if record.OwnerID == "" && update.State != "terminated" {
+			report.Unowned++
+			r.flagUnowned(instanceID)
+		}
+
+		if idleMonitorSilent(instance, record, time.Now()) {
+			report.Silent++
+			r.flagSilentMonitor(instanceID, instance.LaunchTime.UTC().Format(time.RFC3339))
+		}
+	}
+
+	/*
+		Recorded servers EC2 does not return anymore were terminated long enough ago to be forgotten by EC2. Recent records
+		are left alone: DescribeInstances is eventually consistent and may not return an instance launched moments ago.
+	*/
+	records, err := ms.registry.ListServers(ctx, storage.ServerFilter{})
+	if err != nil {
+		return report, fmt.Errorf("failed to list recorded servers: %v", err)
+	}
+	for _, record := range records {
+		if seen[record.InstanceID] {
+			continue
+		}
+		if createdAt, err := time.Parse(time.RFC3339, record.CreatedAt); err != nil || time.Since(createdAt) < reconcileGracePeriod {
+			continue
+		}
+		update := models.ServerStateUpdate{State: "terminated", Reason: "instance no longer exists in EC2"}
+		changed, err := ms.registry.UpdateServerState(ctx, record.InstanceID, update)
+		if err != nil {
+			log.Printf("Warning: failed to record state of %s: %v", record.InstanceID, err)
+			continue
+		}
+		if changed {
+			report.Vanished++
+			r.publish(record.InstanceID, models.ServerEvent{
+				Type:    models.EventEC2State,
+				State:   update.State,
+				Message: "Instance is terminated (" + update.Reason + ")",
+			})
+		}
+	}
+
+	return report, nil
+}
+
+// flagUnowned() => reports, once, an instance created by the generator that no user owns.
+func (r *Reconciler) flagUnowned(instanceID string) {
+	r.mu.Lock()
+	alreadyReported := r.reportedUnowned[instanceID]
+	r.reportedUnowned[instanceID] = true
+	r.mu.Unlock()
+	if alreadyReported {
+		return
+	}
+
+	log.Printf("Warning: instance %s was created by the generator but has no known owner", instanceID)
+	r.publish(instanceID, models.ServerEvent{
+		Type:    models.EventUnowned,
+		Message: "Instance has no known owner (no OwnerID tag), only admins can manage it",
+	})
+}
+
+/*
+flagSilentMonitor() => reports, once per boot, a running instance whose idle monitor never reported in: it was not able
+to reach EC2 (wrong region, missing permissions...) and would never stop the instance.
+*/
+func (r *Reconciler) flagSilentMonitor(instanceID, launchTime string) {
+	boot := instanceID + "@" + launchTime
+	r.mu.Lock()
+	alreadyReported := r.reportedSilent[boot]
+	r.reportedSilent[boot] = true
+	r.mu.Unlock()
+	if alreadyReported {
+		return
+	}
+
+	log.Printf("Warning: the idle monitor of instance %s (launched %s) never reported in, it will not be stopped when idle "+
+		"(see /var/log/minecraft-auto-shutdown.log on the instance)", instanceID, launchTime)
+	r.publish(instanceID, models.ServerEvent{
+		Type:    models.EventIdleMonitorSilent,
+		Message: fmt.Sprintf("Idle monitor did not report in within %s of the launch: the server will not stop on its own", idleMonitorGracePeriod),
+	})
+}
+
+// publish() => publishes a lifecycle event on the stream of an instance.
+func (r *Reconciler) publish(instanceID string, event models.ServerEvent) {
+	event.InstanceID = instanceID
+	r.broker.Publish(instanceID, event)
+}
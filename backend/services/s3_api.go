@@ -0,0 +1,36 @@
+/*
+s3_api.go
+In this file you will find the definition of the S3API interface, which lists the object storage operations the
+backups need. The real *s3.Client from the AWS SDK satisfies it (with AWS S3 or any S3-compatible endpoint such as
+MinIO), and so does the in-memory FakeS3 defined in fake_s3.go. Pre-signed URLs, which let a server download an
+object without credentials, come from S3Presigner (*s3.PresignClient in production).
+*/
+package services
+
+import (
+	"context"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3API is the narrow object storage interface used by BackupService.
+// Keep it as small as possible: every method added here must also be simulated by FakeS3.
+type S3API interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+// S3Presigner creates the pre-signed URLs given to the servers.
+type S3Presigner interface {
+	PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
+// Compile-time checks: both the AWS clients and the fake object storage must implement S3API and S3Presigner.
+var (
+	_ S3API       = (*s3.Client)(nil)
+	_ S3API       = (*FakeS3)(nil)
+	_ S3Presigner = (*s3.PresignClient)(nil)
+	_ S3Presigner = (*FakeS3)(nil)
+)
@@ -0,0 +1,101 @@
+/*
+server_lifecycle.go
+In this file you will find the lifecycle operations of a Minecraft server once it exists: stop, start and terminate.
+Stopping keeps the instance and its root volume, where /opt/minecraft-data (the world) lives: starting it again brings
+the same world back, on a new public IP. Only terminating deletes the instance, and the world with it (unless the
+server keeps it on a data volume, see data_volumes.go).
+*/
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+var (
+	// ErrServerNotStopped is returned (wrapped) when starting a server that is still stopping.
+	ErrServerNotStopped = errors.New("server is not stopped")
+	// ErrServerTerminated is returned (wrapped) when an operation needs a server that has not been terminated.
+	ErrServerTerminated = errors.New("server is terminated")
+)
+
+/*
+StopServer() => stops a running server, keeping its world. The world is saved through RCON first (best effort: the
+container is also stopped gracefully by the shutdown). Stopping a stopped server does nothing.
+*/
+func (s *MinecraftService) StopServer(ctx context.Context, instanceID, reason string) (*models.ServerRecord, error) {
+	server, err := s.GetServer(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	switch server.State {
+	case "stopping", "stopped":
+		return server, nil
+	case "shutting-down", "terminated":
+		return nil, fmt.Errorf("%w: instance is %s", ErrServerTerminated, server.State)
+	}
+
+	if server.State == "running" {
+		if _, err := s.ExecuteCommand(ctx, instanceID, "save-all flush"); err != nil {
+			log.Printf("Warning: could not save the world of %s before stopping it: %v", instanceID, err)
+		}
+	}
+
+	if err := s.StopInstance(ctx, instanceID, reason); err != nil {
+		return nil, err
+	}
+	return s.registry.GetServer(ctx, instanceID)
+}
+
+/*
+StartServer() => starts a stopped server, without waiting for it to run: EC2 takes minutes to boot it, longer than a
+request should be held. The server is "pending" until then; the ServerMonitor reports it "running" on its event stream,
+with its new public IP. Docker restarts the Minecraft container on boot with the world kept in /opt/minecraft-data.
+Starting a server that is pending or running does nothing.
+*/
+func (s *MinecraftService) StartServer(ctx context.Context, instanceID, reason string) (*models.ServerRecord, error) {
+	server, err := s.GetServer(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	switch server.State {
+	case "pending", "running":
+		return server, nil
+	case "stopping":
+		return nil, fmt.Errorf("%w: instance is still stopping, try again in a moment", ErrServerNotStopped)
+	case "shutting-down", "terminated":
+		return nil, fmt.Errorf("%w: instance is %s", ErrServerTerminated, server.State)
+	}
+
+	_, err = s.ec2Service.client.StartInstances(ctx, &ec2.StartInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start instance: %v", err)
+	}
+	s.recordState(ctx, instanceID, models.ServerStateUpdate{State: "pending", Reason: reason})
+	log.Printf("Starting Minecraft server %s", instanceID)
+	return s.registry.GetServer(ctx, instanceID)
+}
+
+// TerminateServer() => terminates a server for good. Its world is deleted with the instance, unless it is on a data volume.
+func (s *MinecraftService) TerminateServer(ctx context.Context, instanceID, reason string) (*models.ServerRecord, error) {
+	server, err := s.GetServer(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if server.State == "shutting-down" || server.State == "terminated" {
+		return server, nil
+	}
+
+	if err := s.TerminateInstance(ctx, instanceID, reason); err != nil {
+		return nil, err
+	}
+	log.Printf("Terminated Minecraft server %s: %s", instanceID, reason)
+	return s.registry.GetServer(ctx, instanceID)
+}
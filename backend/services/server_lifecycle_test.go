@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+// waitForInstanceState() => waits until the fake cloud reports an instance in state, then records it as the monitor does.
+func (c *testCloud) waitForInstanceState(t *testing.T, instanceID string, state types.InstanceStateName) {
+	t.Helper()
+	ctx := context.Background()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		output, err := c.fake.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instanceID}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		instance := output.Reservations[0].Instances[0]
+		if instance.State.Name == state {
+			c.minecraft.recordState(ctx, instanceID, stateUpdateFromInstance(instance))
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("instance %s is %s after 5s, want %s", instanceID, instance.State.Name, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestStartServerDoesNotWait checks that a start returns before the server runs, and that its event stream then
+// reports it running with its new public IP.
+func TestStartServerDoesNotWait(t *testing.T) {
+	ctx := context.Background()
+	cloud := newTestCloud(t)
+	job := cloud.waitForJob(t, cloud.submit(t, "player").JobID)
+	if job.Server == nil {
+		t.Fatalf("job ended in phase %s (%s)", job.Phase, job.Error)
+	}
+	instanceID := job.Server.InstanceID
+
+	events, unsubscribe, err := cloud.monitor.Subscribe(instanceID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	if err := cloud.minecraft.StopInstance(ctx, instanceID, "test"); err != nil {
+		t.Fatal(err)
+	}
+	cloud.waitForInstanceState(t, instanceID, types.InstanceStateNameStopped)
+
+	server, err := cloud.minecraft.StartServer(ctx, instanceID, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.State != "pending" || server.PublicIP != "" {
+		t.Fatalf("StartServer() = %s (IP %q), want pending without an IP yet", server.State, server.PublicIP)
+	}
+	cloud.monitor.Watch(instanceID, instanceID)
+
+	// Starting again while it boots changes nothing
+	if again, err := cloud.minecraft.StartServer(ctx, instanceID, "test"); err != nil || again.State != "pending" {
+		t.Fatalf("second StartServer() = %+v, %v, want it pending", again, err)
+	}
+
+	stopped := false // Seen stopping (the monitor may miss the short stopped state between two polls)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type != models.EventEC2State {
+				continue
+			}
+			if event.State == "stopping" || event.State == "stopped" {
+				stopped = true
+			}
+			if stopped && event.State == "running" {
+				if event.PublicIP == "" || event.PublicIP == job.Server.PublicIP {
+					t.Fatalf("running event %+v, want the new public IP (the previous one was %s)", event, job.Server.PublicIP)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("no running event after the start")
+		}
+	}
+}
@@ -0,0 +1,254 @@
+/*
+server_monitor.go
+In this file you will find the ServerMonitor, which watches EC2 instances and turns what it observes into
+server events: EC2 state changes (from DescribeInstances) and the setup stages printed by ec2-init.sh on the
+serial console (from GetConsoleOutput). A watch runs while somebody listens to the instance's event stream.
+*/
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+/*
+Markers printed by ec2-init.sh on the serial console ("MCSG-STAGE <stage>"). The backend reads them back with
+GetConsoleOutput, which is the only channel it has to know what is happening inside the instance.
+*/
+const (
+	consoleStagePrefix      = "MCSG-STAGE "
+	stageSetupStarted       = "setup_started"
+	stagePackagesUpdated    = "packages_updated"
+	stageDockerInstalled    = "docker_installed"
+	stageImagePullStarted   = "image_pull_started"
+	stageImagePulled        = "image_pulled"
+	stageDataVolumeMounted  = "data_volume_mounted"
+	stageWorldRestored      = "world_restored"
+	stageDockerReady        = "docker_ready"
+	stageAutoShutdownActive = "auto_shutdown_enabled"
+	stageMinecraftReady     = "minecraft_ready"
+)
+
+// consoleStages describes every stage, in the order ec2-init.sh goes through them, and how it is reported.
+var consoleStages = []struct {
+	stage     string
+	eventType models.ServerEventType
+	message   string
+}{
+	{stageSetupStarted, models.EventCloudInit, "Instance setup started"},
+	{stagePackagesUpdated, models.EventCloudInit, "System packages updated"},
+	{stageDockerInstalled, models.EventCloudInit, "Docker installed and started"},
+	{stageImagePullStarted, models.EventDockerPull, "Pulling the Minecraft server Docker image"},
+	{stageImagePulled, models.EventDockerPull, "Minecraft server Docker image pulled"},
+	{stageDataVolumeMounted, models.EventCloudInit, "Data volume mounted, the world is kept on it"},
+	{stageWorldRestored, models.EventCloudInit, "World restored from the backup"},
+	{stageDockerReady, models.EventCloudInit, "Minecraft container started"},
+	{stageAutoShutdownActive, models.EventCloudInit, "Idle auto-shutdown monitor enabled"},
+	{stageMinecraftReady, models.EventMinecraftDone, "Minecraft server is done loading and accepts players"},
+}
+
+// parseConsoleStages() => returns the stage markers found in a console output, in the order they were printed.
+func parseConsoleStages(console string) []string {
+	var stages []string
+	for _, line := range strings.Split(console, "\n") {
+		index := strings.Index(line, consoleStagePrefix)
+		if index < 0 {
+			continue
+		}
+		fields := strings.Fields(line[index+len(consoleStagePrefix):])
+		if len(fields) > 0 {
+			stages = append(stages, fields[0])
+		}
+	}
+	return stages
+}
+
+// ServerMonitor polls instances that have listeners and publishes what changes.
+type ServerMonitor struct {
+	ec2Service *EC2Service
+	broker     *EventBroker
+
+	mu       sync.Mutex
+	watching map[string]bool // Instance IDs with a running watch
+
+	interval    time.Duration // Delay between two polls of the same instance
+	idleTimeout time.Duration // A watch without listeners stops after this long
+}
+
+// NewServerMonitor() => creates a monitor publishing to the given broker.
+func NewServerMonitor(ec2Service *EC2Service, broker *EventBroker) *ServerMonitor {
+	interval := ec2Service.pollInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return &ServerMonitor{
+		ec2Service:  ec2Service,
+		broker:      broker,
+		watching:    make(map[string]bool),
+		interval:    interval,
+		idleTimeout: time.Minute,
+	}
+}
+
+/*
+Subscribe() => subscribes to the event stream of a server, given either its instance ID or the ID of the job that
+created it, and makes sure the instance is being watched while the subscription is open. Returns ErrServerNotFound
+when the ID matches neither a known stream nor an existing instance.
+*/
+func (m *ServerMonitor) Subscribe(id string, lastEventID int64) (<-chan models.ServerEvent, func(), error) {
+	instanceID := m.broker.InstanceOf(id)
+	if instanceID == "" && strings.HasPrefix(id, "i-") {
+		if _, err := m.ec2Service.GetInstanceInfo(id); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrServerNotFound, err)
+		}
+		instanceID = id
+	}
+	if instanceID == "" && !m.broker.Exists(id) {
+		return nil, nil, fmt.Errorf("%w: no server or provisioning job with ID %s", ErrServerNotFound, id)
+	}
+
+	events, unsubscribe := m.broker.Subscribe(id, lastEventID)
+	if instanceID != "" {
+		m.Watch(instanceID, id)
+	}
+	return events, unsubscribe, nil
+}
+
+// Watch() => starts watching an instance (if it is not watched already), publishing its events under topic.
+func (m *ServerMonitor) Watch(instanceID, topic string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.watching[instanceID] {
+		return
+	}
+	m.watching[instanceID] = true
+	go m.watch(instanceID, topic)
+}
+
+// watch() => the polling loop of a single instance. It stops when the instance is terminated or nobody listens anymore.
+func (m *ServerMonitor) watch(instanceID, topic string) {
+	defer func() {
+		m.mu.Lock()
+		delete(m.watching, instanceID)
+		m.mu.Unlock()
+	}()
+
+	seenStages := make(map[string]bool)
+	lastState := ""
+	idleSince := time.Time{}
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		state, address := m.pollState(ctx, instanceID, topic, &lastState)
+		if state == "running" {
+			m.pollConsole(ctx, instanceID, topic, seenStages)
+
+			// Console output can lag minutes behind on AWS: a server answering the Server List Ping is ready for sure.
+			if !seenStages[stageMinecraftReady] && address != "" && minecraftAnswersPing(ctx, address) {
+				m.publishStage(instanceID, topic, stageMinecraftReady, seenStages)
+			}
+		}
+		cancel()
+
+		if state == "terminated" {
+			return
+		}
+		if m.broker.Subscribers(topic) == 0 {
+			if idleSince.IsZero() {
+				idleSince = time.Now()
+			} else if time.Since(idleSince) > m.idleTimeout {
+				return
+			}
+		} else {
+			idleSince = time.Time{}
+		}
+
+		time.Sleep(m.interval)
+	}
+}
+
+// pollState() => publishes an ec2_state event when the instance state changed. Returns the state and Minecraft address.
+func (m *ServerMonitor) pollState(ctx context.Context, instanceID, topic string, lastState *string) (string, string) {
+	result, err := m.ec2Service.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil || len(result.Reservations) == 0 || len(result.Reservations[0].Instances) == 0 {
+		log.Printf("Warning: could not describe watched instance %s: %v", instanceID, err)
+		return *lastState, ""
+	}
+
+	instance := result.Reservations[0].Instances[0]
+	state := string(instance.State.Name)
+	if state != *lastState {
+		*lastState = state
+		event := models.ServerEvent{
+			Type:       models.EventEC2State,
+			State:      state,
+			Message:    "Instance is " + state,
+			InstanceID: instanceID,
+		}
+		// A started server gets a new public IP: clients learn it from the running state
+		if state == "running" {
+			event.PublicIP = aws.ToString(instance.PublicIpAddress)
+		}
+		m.broker.Publish(topic, event)
+	}
+
+	address := ""
+	if ip := aws.ToString(instance.PublicIpAddress); ip != "" {
+		address = ip + ":25565"
+	}
+	return state, address
+}
+
+// pollConsole() => publishes the setup stages that appeared on the console since the last poll.
+func (m *ServerMonitor) pollConsole(ctx context.Context, instanceID, topic string, seenStages map[string]bool) {
+	console, err := m.ec2Service.getConsoleOutput(ctx, instanceID)
+	if err != nil {
+		log.Printf("Warning: could not read console of %s: %v", instanceID, err)
+		return
+	}
+	for _, stage := range parseConsoleStages(console) {
+		m.publishStage(instanceID, topic, stage, seenStages)
+	}
+}
+
+// minecraftAnswersPing() => reports whether a Minecraft server answers the Server List Ping on address.
+func minecraftAnswersPing(ctx context.Context, address string) bool {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := PingMinecraftServer(ctx, address)
+	return err == nil
+}
+
+// publishStage() => publishes a setup stage the first time it is seen.
+func (m *ServerMonitor) publishStage(instanceID, topic, stage string, seenStages map[string]bool) {
+	if seenStages[stage] {
+		return
+	}
+	seenStages[stage] = true
+
+	event := models.ServerEvent{
+		Type:       models.EventCloudInit,
+		Stage:      stage,
+		Message:    "Setup stage: " + stage,
+		InstanceID: instanceID,
+	}
+	for _, known := range consoleStages {
+		if known.stage == stage {
+			event.Type = known.eventType
+			event.Message = known.message
+		}
+	}
+	m.broker.Publish(topic, event)
+}
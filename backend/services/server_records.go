@@ -0,0 +1,203 @@
+/*
+server_records.go
+In this file you will find how the MinecraftService keeps the server registry (see storage/registry.go) in line with
+EC2: servers are recorded when they are launched, and their recorded state is refreshed from EC2 only when it has not
+been confirmed for a while, instead of calling DescribeInstances on every request.
+*/
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/storage"
+)
+
+const (
+	rconPasswordSecret   = "rcon_password"  // Name of the RCON password in the registry secrets
+	serverStateMaxAge    = 30 * time.Second // A recorded state older than this is refreshed from EC2 before being used
+	describeFilterValues = 200              // Maximum number of values of a DescribeInstances filter
+)
+
+// generateSecret() => returns a random secret of n bytes, encoded with URL-safe base64 so it can be used as is
+// in a shell script or a Docker environment variable.
+func generateSecret(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// recordFromInstance() => builds a registry record from an instance and the tags CreateMinecraftServer() writes.
+func recordFromInstance(instance types.Instance) *models.ServerRecord {
+	tags := instanceTags(instance)
+	record := &models.ServerRecord{
+		InstanceID:       aws.ToString(instance.InstanceId),
+		OwnerID:          tags[ownerTagKey],
+		ServerName:       tags["Name"],
+		ServerType:       tags["MinecraftType"],
+		MinecraftVersion: tags["MinecraftVersion"],
+		InstanceType:     string(instance.InstanceType),
+		PublicIP:         aws.ToString(instance.PublicIpAddress),
+		PrivateIP:        aws.ToString(instance.PrivateIpAddress),
+		DataVolumeID:     dataVolumeOf(instance),
+	}
+	if instance.State != nil {
+		record.State = string(instance.State.Name)
+	}
+	if instance.Placement != nil {
+		record.AvailabilityZone = aws.ToString(instance.Placement.AvailabilityZone)
+	}
+	if instance.LaunchTime != nil {
+		record.LaunchTime = instance.LaunchTime.UTC().Format(time.RFC3339)
+	}
+	// Older servers tagged CreatedAt in the local time of the backend: store it in UTC like everything else.
+	if createdAt, err := time.Parse(time.RFC3339, tags["CreatedAt"]); err == nil {
+		record.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	} else {
+		record.CreatedAt = record.LaunchTime
+	}
+	return record
+}
+
+// stateUpdateFromInstance() => describes what DescribeInstances reports about an instance as a registry update.
+func stateUpdateFromInstance(instance types.Instance) models.ServerStateUpdate {
+	update := models.ServerStateUpdate{
+		PublicIP:  aws.ToString(instance.PublicIpAddress),
+		PrivateIP: aws.ToString(instance.PrivateIpAddress),
+		Reason:    aws.ToString(instance.StateTransitionReason),
+	}
+	if instance.State != nil {
+		update.State = string(instance.State.Name)
+	}
+	if update.Reason == "" && instance.StateReason != nil {
+		update.Reason = aws.ToString(instance.StateReason.Message)
+	}
+	return update
+}
+
+// recordState() => applies an observation to the registry. Failures are only logged: the registry must never block
+// an operation that already happened in EC2.
+func (s *MinecraftService) recordState(ctx context.Context, instanceID string, update models.ServerStateUpdate) {
+	if _, err := s.registry.UpdateServerState(ctx, instanceID, update); err != nil {
+		log.Printf("Warning: failed to record state %q of %s: %v", update.State, instanceID, err)
+	}
+}
+
+/*
+getServerRecord() => returns the registry record of a Minecraft server. A server created before the registry existed
+is recorded from its EC2 tags the first time it is asked for; instances not tagged Type=MinecraftServer and
+CreatedBy=MinecraftServerGenerator are not found.
+*/
+func (s *MinecraftService) getServerRecord(ctx context.Context, instanceID string) (*models.ServerRecord, error) {
+	record, err := s.registry.GetServer(ctx, instanceID)
+	if err == nil {
+		return record, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	result, err := s.ec2Service.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrServerNotFound, err)
+	}
+	if len(result.Reservations) == 0 || len(result.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("%w: instance %s not found", ErrServerNotFound, instanceID)
+	}
+	instance := result.Reservations[0].Instances[0]
+	tags := instanceTags(instance)
+	if tags[serverTypeTagKey] != serverTypeTagValue || tags[createdByTagKey] != createdByTagValue {
+		return nil, fmt.Errorf("%w: instance %s is not a Minecraft server", ErrServerNotFound, instanceID)
+	}
+
+	record = recordFromInstance(instance)
+	if err := s.registry.CreateServer(ctx, record); err != nil && !errors.Is(err, storage.ErrAlreadyExists) {
+		return nil, err
+	}
+	log.Printf("Recorded existing Minecraft server %s in the registry", instanceID)
+	return s.registry.GetServer(ctx, instanceID)
+}
+
+// GetServer() => returns the registry record of a Minecraft server, refreshed from EC2 if it is not recent enough.
+func (s *MinecraftService) GetServer(ctx context.Context, instanceID string) (*models.ServerRecord, error) {
+	record, err := s.getServerRecord(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	records := []models.ServerRecord{*record}
+	s.syncServers(ctx, records)
+	return &records[0], nil
+}
+
+// ServerHistory() => returns the recorded state transitions of a server, oldest first.
+func (s *MinecraftService) ServerHistory(ctx context.Context, instanceID string) ([]models.ServerTransition, error) {
+	return s.registry.ListTransitions(ctx, instanceID)
+}
+
+/*
+syncServers() => refreshes from EC2 the records (updated in place) whose state was not confirmed in the last
+serverStateMaxAge. Instances EC2 does not know anymore were terminated long ago and are recorded as such.
+*/
+func (s *MinecraftService) syncServers(ctx context.Context, records []models.ServerRecord) {
+	stale := make(map[string]int)
+	var staleIDs []string
+	for i, record := range records {
+		if record.State == "terminated" {
+			continue
+		}
+		syncedAt, err := time.Parse(time.RFC3339, record.SyncedAt)
+		if err == nil && time.Since(syncedAt) < serverStateMaxAge {
+			continue
+		}
+		stale[record.InstanceID] = i
+		staleIDs = append(staleIDs, record.InstanceID)
+	}
+
+	for start := 0; start < len(staleIDs); start += describeFilterValues {
+		end := min(start+describeFilterValues, len(staleIDs))
+		// A filter (unlike InstanceIds) does not fail when one of the instances does not exist anymore.
+		instances, err := s.ec2Service.describeInstancesPages(ctx, []types.Filter{
+			{Name: aws.String("instance-id"), Values: staleIDs[start:end]},
+		})
+		if err != nil {
+			log.Printf("Warning: could not refresh the state of %d servers: %v", end-start, err)
+			continue
+		}
+
+		found := make(map[string]bool)
+		for _, instance := range instances {
+			id := aws.ToString(instance.InstanceId)
+			found[id] = true
+			s.applyState(ctx, &records[stale[id]], stateUpdateFromInstance(instance))
+		}
+		for _, id := range staleIDs[start:end] {
+			if !found[id] {
+				s.applyState(ctx, &records[stale[id]], models.ServerStateUpdate{
+					State:  "terminated",
+					Reason: "instance no longer exists in EC2",
+				})
+			}
+		}
+	}
+}
+
+// applyState() => records an observation and reflects it on an in-memory record.
+func (s *MinecraftService) applyState(ctx context.Context, record *models.ServerRecord, update models.ServerStateUpdate) {
+	s.recordState(ctx, record.InstanceID, update)
+	if refreshed, err := s.registry.GetServer(ctx, record.InstanceID); err == nil {
+		*record = *refreshed
+	}
+}
@@ -0,0 +1,260 @@
+/*
+slp_client.go
+In this file you will find a client for the Minecraft Java Edition Server List Ping (SLP) protocol, the same
+request the game sends to show a server in the multiplayer list. It is how the backend knows whether Minecraft
+really accepts players (the EC2 instance is "running" minutes before that), and it reports the MOTD, version,
+players and latency of the server.
+Protocol reference: https://minecraft.wiki/w/Java_Edition_protocol/Server_List_Ping
+*/
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+const (
+	slpProtocolVersion = -1      // By convention, -1 means "the client does not know the version, just pinging"
+	slpNextStateStatus = 1       // Handshake next state: status (2 would be login)
+	slpMaxPacketLength = 1 << 21 // Status responses are small, but may include a base64 favicon
+)
+
+// slpStatusResponse is the JSON document sent by the server in the status response packet.
+type slpStatusResponse struct {
+	Version struct {
+		Name     string `json:"name"`
+		Protocol int    `json:"protocol"`
+	} `json:"version"`
+	Players struct {
+		Max    int `json:"max"`
+		Online int `json:"online"`
+	} `json:"players"`
+	Description json.RawMessage `json:"description"` // Either a plain string or a chat component
+}
+
+// slpChatComponent is the subset of a Minecraft chat component needed to extract its plain text.
+type slpChatComponent struct {
+	Text  string            `json:"text"`
+	Extra []json.RawMessage `json:"extra"`
+}
+
+/*
+PingMinecraftServer() => performs a Server List Ping against address ("host:port") and returns the server status.
+The latency is measured with the ping/pong exchange that follows the status request.
+*/
+func PingMinecraftServer(ctx context.Context, address string) (*models.MinecraftServerStatus, error) {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server address %q: %v", address, err)
+	}
+	port, err := strconv.ParseUint(portText, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server port %q: %v", portText, err)
+	}
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", address, err)
+	}
+	defer conn.Close()
+
+	// Never hang forever on a server that accepts the connection but does not answer.
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+	reader := bufio.NewReader(conn)
+
+	// 1. Handshake (packet 0x00): protocol version, server address, port, next state.
+	var handshake bytes.Buffer
+	writeVarInt(&handshake, slpProtocolVersion)
+	writeString(&handshake, host)
+	binary.Write(&handshake, binary.BigEndian, uint16(port))
+	writeVarInt(&handshake, slpNextStateStatus)
+	if err := writePacket(conn, 0x00, handshake.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to send handshake: %v", err)
+	}
+
+	// 2. Status request (packet 0x00, no fields) and its response (packet 0x00 with a JSON string).
+	if err := writePacket(conn, 0x00, nil); err != nil {
+		return nil, fmt.Errorf("failed to send status request: %v", err)
+	}
+	packetID, payload, err := readPacket(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status response: %v", err)
+	}
+	if packetID != 0x00 {
+		return nil, fmt.Errorf("unexpected packet 0x%02x in status response", packetID)
+	}
+	statusJSON, err := readString(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode status response: %v", err)
+	}
+
+	var status slpStatusResponse
+	if err := json.Unmarshal([]byte(statusJSON), &status); err != nil {
+		return nil, fmt.Errorf("failed to parse status JSON: %v", err)
+	}
+
+	// 3. Ping (packet 0x01 with a long) / pong (same packet echoed back) to measure the latency.
+	var ping bytes.Buffer
+	sentAt := time.Now()
+	binary.Write(&ping, binary.BigEndian, sentAt.UnixMilli())
+	if err := writePacket(conn, 0x01, ping.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to send ping: %v", err)
+	}
+	packetID, payload, err = readPacket(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pong: %v", err)
+	}
+	if packetID != 0x01 || !bytes.Equal(payload, ping.Bytes()) {
+		return nil, fmt.Errorf("invalid pong from server")
+	}
+
+	return &models.MinecraftServerStatus{
+		Online:        true,
+		MOTD:          parseDescription(status.Description),
+		Version:       status.Version.Name,
+		Protocol:      status.Version.Protocol,
+		PlayersOnline: status.Players.Online,
+		PlayersMax:    status.Players.Max,
+		LatencyMs:     time.Since(sentAt).Milliseconds(),
+	}, nil
+}
+
+// parseDescription() => returns the plain text of a MOTD, which may be a string or a (nested) chat component.
+func parseDescription(raw json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return stripFormattingCodes(text)
+	}
+
+	var component slpChatComponent
+	if err := json.Unmarshal(raw, &component); err != nil {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteString(component.Text)
+	for _, extra := range component.Extra {
+		builder.WriteString(parseDescription(extra))
+	}
+	return stripFormattingCodes(builder.String())
+}
+
+// stripFormattingCodes() => removes the legacy "§x" color/formatting codes from a MOTD.
+func stripFormattingCodes(text string) string {
+	var builder strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == '§' {
+			i++ // Skip the code character too
+			continue
+		}
+		builder.WriteRune(runes[i])
+	}
+	return builder.String()
+}
+
+// writePacket() => frames a packet as VarInt(length) + VarInt(packet ID) + payload and writes it.
+func writePacket(w io.Writer, packetID int32, payload []byte) error {
+	var body bytes.Buffer
+	writeVarInt(&body, packetID)
+	body.Write(payload)
+
+	var packet bytes.Buffer
+	writeVarInt(&packet, int32(body.Len()))
+	packet.Write(body.Bytes())
+
+	_, err := w.Write(packet.Bytes())
+	return err
+}
+
+// readPacket() => reads a length-prefixed packet and returns its ID and payload.
+func readPacket(r *bufio.Reader) (int32, []byte, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length <= 0 || length > slpMaxPacketLength {
+		return 0, nil, fmt.Errorf("invalid packet length %d", length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	bodyReader := bytes.NewReader(body)
+	packetID, err := readVarInt(bodyReader)
+	if err != nil {
+		return 0, nil, err
+	}
+	payload, _ := io.ReadAll(bodyReader)
+	return packetID, payload, nil
+}
+
+// writeVarInt() => encodes a 32-bit integer with the variable-length encoding used by the protocol
+// (7 bits per byte, least significant group first, high bit set when more bytes follow).
+func writeVarInt(buf *bytes.Buffer, value int32) {
+	unsigned := uint32(value)
+	for {
+		if unsigned&^0x7F == 0 {
+			buf.WriteByte(byte(unsigned))
+			return
+		}
+		buf.WriteByte(byte(unsigned&0x7F | 0x80))
+		unsigned >>= 7
+	}
+}
+
+// readVarInt() => decodes a VarInt, rejecting encodings longer than 5 bytes.
+func readVarInt(r io.ByteReader) (int32, error) {
+	var result uint32
+	for i := 0; i < 5; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		result |= uint32(b&0x7F) << (7 * i)
+		if b&0x80 == 0 {
+			return int32(result), nil
+		}
+	}
+	return 0, errors.New("VarInt is too big")
+}
+
+// writeString() => encodes a string as VarInt(length in bytes) + UTF-8 bytes.
+func writeString(buf *bytes.Buffer, value string) {
+	writeVarInt(buf, int32(len(value)))
+	buf.WriteString(value)
+}
+
+// readString() => decodes a string written by writeString().
+func readString(r *bytes.Reader) (string, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return "", err
+	}
+	if length < 0 || int(length) > r.Len() {
+		return "", fmt.Errorf("invalid string length %d", length)
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
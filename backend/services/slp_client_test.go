@@ -0,0 +1,247 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVarInt(t *testing.T) {
+	cases := []struct {
+		value   int32
+		encoded string
+	}{
+		{0, "00"},
+		{1, "01"},
+		{127, "7f"},
+		{128, "8001"},
+		{255, "ff01"},
+		{25565, "ddc701"},
+		{2097151, "ffff7f"},
+		{2147483647, "ffffffff07"},
+		{-1, "ffffffff0f"},
+		{-2147483648, "8080808008"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		writeVarInt(&buf, c.value)
+		if got := hex.EncodeToString(buf.Bytes()); got != c.encoded {
+			t.Errorf("writeVarInt(%d) = %s, want %s", c.value, got, c.encoded)
+		}
+		decoded, err := readVarInt(bytes.NewReader(buf.Bytes()))
+		if err != nil || decoded != c.value {
+			t.Errorf("readVarInt(%s) = %d, %v, want %d", c.encoded, decoded, err, c.value)
+		}
+	}
+
+	for name, encoded := range map[string]string{
+		"empty":     "",
+		"truncated": "ff",
+		"too long":  "ffffffffff01",
+	} {
+		raw, _ := hex.DecodeString(encoded)
+		if _, err := readVarInt(bytes.NewReader(raw)); err == nil {
+			t.Errorf("readVarInt() accepted a %s VarInt (%s)", name, encoded)
+		}
+	}
+}
+
+func TestReadPacket(t *testing.T) {
+	framed := func(length int32, body []byte) []byte {
+		var buf bytes.Buffer
+		writeVarInt(&buf, length)
+		buf.Write(body)
+		return buf.Bytes()
+	}
+	var valid bytes.Buffer
+	if err := writePacket(&valid, 0x01, []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+
+	packetID, payload, err := readPacket(bufio.NewReader(bytes.NewReader(valid.Bytes())))
+	if err != nil || packetID != 0x01 || string(payload) != "payload" {
+		t.Fatalf("readPacket() = 0x%02x, %q, %v", packetID, payload, err)
+	}
+
+	for name, raw := range map[string][]byte{
+		"empty stream":     nil,
+		"zero length":      framed(0, nil),
+		"negative length":  framed(-1, nil),
+		"oversized":        framed(slpMaxPacketLength+1, []byte{0x00}),
+		"truncated body":   framed(100, []byte{0x00, 'x'}),
+		"truncated length": {0x80},
+	} {
+		if _, _, err := readPacket(bufio.NewReader(bytes.NewReader(raw))); err == nil {
+			t.Errorf("readPacket() accepted a packet with %s", name)
+		}
+	}
+}
+
+// startFakeSLPServer() => listens on a local port and runs serve on the first connection. It returns the address.
+func startFakeSLPServer(t *testing.T, serve func(conn net.Conn, reader *bufio.Reader)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn, bufio.NewReader(conn))
+	}()
+	return listener.Addr().String()
+}
+
+// statusPacket() => returns the payload of a status response holding statusJSON.
+func statusPacket(statusJSON string) []byte {
+	var buf bytes.Buffer
+	writeString(&buf, statusJSON)
+	return buf.Bytes()
+}
+
+// answerStatus() => reads the handshake and the status request, then answers with response (a raw frame).
+func answerStatus(t *testing.T, reader *bufio.Reader, conn net.Conn, response []byte) bool {
+	for i := 0; i < 2; i++ {
+		if _, _, err := readPacket(reader); err != nil {
+			t.Errorf("fake server: %v", err)
+			return false
+		}
+	}
+	_, err := conn.Write(response)
+	return err == nil
+}
+
+func TestPingMinecraftServer(t *testing.T) {
+	const statusJSON = `{"version":{"name":"1.21.4","protocol":769},"players":{"max":20,"online":3},` +
+		`"description":{"text":"§aWelcome ","extra":["to ",{"text":"§lthe server"}]}}`
+
+	var handshake struct {
+		protocol  int32
+		host      string
+		port      uint16
+		nextState int32
+	}
+	address := startFakeSLPServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		packetID, payload, err := readPacket(reader)
+		if err != nil || packetID != 0x00 {
+			t.Errorf("fake server: handshake 0x%02x, %v", packetID, err)
+			return
+		}
+		fields := bytes.NewReader(payload)
+		handshake.protocol, _ = readVarInt(fields)
+		handshake.host, _ = readString(fields)
+		binary.Read(fields, binary.BigEndian, &handshake.port)
+		handshake.nextState, _ = readVarInt(fields)
+
+		if packetID, payload, err := readPacket(reader); err != nil || packetID != 0x00 || len(payload) != 0 {
+			t.Errorf("fake server: status request 0x%02x %x, %v", packetID, payload, err)
+			return
+		}
+		writePacket(conn, 0x00, statusPacket(statusJSON))
+
+		packetID, payload, err = readPacket(reader)
+		if err != nil || packetID != 0x01 || len(payload) != 8 {
+			t.Errorf("fake server: ping 0x%02x %x, %v", packetID, payload, err)
+			return
+		}
+		writePacket(conn, 0x01, payload)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	status, err := PingMinecraftServer(ctx, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Online || status.MOTD != "Welcome to the server" || status.Version != "1.21.4" || status.Protocol != 769 ||
+		status.PlayersOnline != 3 || status.PlayersMax != 20 {
+		t.Fatalf("status = %+v", status)
+	}
+
+	_, port, _ := net.SplitHostPort(address)
+	if handshake.protocol != slpProtocolVersion || handshake.host != "127.0.0.1" ||
+		strconv.Itoa(int(handshake.port)) != port || handshake.nextState != slpNextStateStatus {
+		t.Fatalf("handshake = %+v", handshake)
+	}
+}
+
+func TestPingMinecraftServerBadResponses(t *testing.T) {
+	frame := func(length int32, body []byte) []byte {
+		var buf bytes.Buffer
+		writeVarInt(&buf, length)
+		buf.Write(body)
+		return buf.Bytes()
+	}
+	packet := func(packetID int32, payload []byte) []byte {
+		var buf bytes.Buffer
+		writePacket(&buf, packetID, payload)
+		return buf.Bytes()
+	}
+	hugeString := func() []byte {
+		var buf bytes.Buffer
+		writeVarInt(&buf, 1000)
+		buf.WriteString("{}")
+		return buf.Bytes()
+	}
+
+	cases := map[string][]byte{
+		"oversized packet":       frame(slpMaxPacketLength+1, []byte{0x00}),
+		"truncated packet":       frame(1000, append([]byte{0x00}, statusPacket(`{"version":`)...)),
+		"VarInt length too long": {0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		"string past the packet": packet(0x00, hugeString()),
+		"wrong packet ID":        packet(0x05, statusPacket(`{}`)),
+		"not JSON":               packet(0x00, statusPacket(`<html>`)),
+	}
+	for name, response := range cases {
+		t.Run(name, func(t *testing.T) {
+			address := startFakeSLPServer(t, func(conn net.Conn, reader *bufio.Reader) {
+				answerStatus(t, reader, conn, response)
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if status, err := PingMinecraftServer(ctx, address); err == nil {
+				t.Fatalf("PingMinecraftServer() = %+v, want an error", status)
+			}
+		})
+	}
+
+	t.Run("wrong pong", func(t *testing.T) {
+		address := startFakeSLPServer(t, func(conn net.Conn, reader *bufio.Reader) {
+			if !answerStatus(t, reader, conn, packet(0x00, statusPacket(`{"version":{"name":"1.21.4"}}`))) {
+				return
+			}
+			readPacket(reader)
+			writePacket(conn, 0x01, []byte{0, 0, 0, 0, 0, 0, 0, 0})
+		})
+		if _, err := PingMinecraftServer(context.Background(), address); err == nil {
+			t.Fatal("PingMinecraftServer() accepted a pong that does not echo the ping")
+		}
+	})
+
+	t.Run("silent server", func(t *testing.T) {
+		done := make(chan struct{})
+		defer close(done)
+		address := startFakeSLPServer(t, func(conn net.Conn, reader *bufio.Reader) {
+			<-done
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		started := time.Now()
+		if _, err := PingMinecraftServer(ctx, address); err == nil {
+			t.Fatal("PingMinecraftServer() succeeded against a server that never answers")
+		}
+		if elapsed := time.Since(started); elapsed > 2*time.Second {
+			t.Fatalf("PingMinecraftServer() took %v, the deadline of the context was not applied", elapsed)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+/*
+trial_service.go
+In this file you will find the trial attempts of the users: the test_service_trial_attempts and
+custom_server_trial_attempts counters kept by the access policy (see the access package). Creating a server consumes one
+attempt of the counter its request names ("trial": "test" or "custom"). The attempt is taken when the creation is
+queued, so that a user cannot launch more servers than they have attempts by sending requests in parallel, and given
+back when the creation fails.
+*/
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/access"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+// ErrNoTrialAttempts is returned (wrapped) when the owner of a request has no attempt left on its trial.
+var ErrNoTrialAttempts = access.ErrNoTrialAttempts
+
+const refundTimeout = 30 * time.Second
+
+// TrialService consumes and refunds the trial attempts of the users, through the access policy.
+type TrialService struct {
+	policy access.AccessPolicy
+}
+
+/*
+NewTrialService() => creates the trial service. When an access policy is configured, the servers created from now on
+by minecraftService are charged on the trial attempts of their owner.
+*/
+func NewTrialService(policy access.AccessPolicy, minecraftService *MinecraftService) *TrialService {
+	service := &TrialService{policy: policy}
+	if service.Enabled() {
+		minecraftService.trials = service
+	}
+	return service
+}
+
+// Enabled() => reports whether an access policy is configured (a nil service is disabled).
+func (s *TrialService) Enabled() bool {
+	return s != nil && s.policy != nil
+}
+
+// Consume() => takes one attempt of a trial from a user, or fails with ErrNoTrialAttempts when none is left.
+func (s *TrialService) Consume(ctx context.Context, userID, trial string) error {
+	if userID == "" {
+		return errors.New("no user to charge")
+	}
+	return s.policy.ConsumeTrial(ctx, userID, trial)
+}
+
+// Refund() => gives a consumed attempt of a trial back to a user.
+func (s *TrialService) Refund(ctx context.Context, userID, trial string) error {
+	if userID == "" {
+		return errors.New("no user to refund")
+	}
+	return s.policy.RefundTrial(ctx, userID, trial)
+}
+
+// refundTrial() => gives back the trial attempt a failed creation consumed. Failures are only logged.
+func (s *TrialService) refundTrial(req models.MinecraftServerRequest) {
+	if !req.ChargeTrial || !s.Enabled() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), refundTimeout)
+	defer cancel()
+	if err := s.Refund(ctx, req.OwnerID, req.Trial); err != nil {
+		log.Printf("Warning: failed to refund the %s trial attempt of %s: %v", req.Trial, req.OwnerID, err)
+		return
+	}
+	log.Printf("Refunded the %s trial attempt of %s", req.Trial, req.OwnerID)
+}
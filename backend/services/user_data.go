@@ -0,0 +1,127 @@
+/*
+user_data.go
+In this file you will find how the user data of the Minecraft servers is rendered. scripts/ec2-init.sh is a
+text/template, embedded in the binary, executed with a userDataContext holding everything the backend decided about
+the server: the environment of the Minecraft container, its data volume, the backup to restore, the backup agent, the
+idle policy and the callbacks. Values are quoted for the shell by the template itself ({{shell .Value}}).
+The script is gzipped before it is handed to EC2 (cloud-init unpacks it), to stay within the 16 KB EC2 allows.
+The template is checked when the backend starts (ValidateUserDataTemplate), so a broken script stops the deployment
+instead of every server launched with it.
+*/
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/scripts"
+)
+
+const (
+	userDataTemplateName = "ec2-init.sh"
+	maxUserDataSize      = 16 << 10 // EC2 limit of the user data, before it is base64 encoded
+)
+
+// userDataContext is what the user data template of a server is executed with.
+type userDataContext struct {
+	DockerEnv        []string          // Environment of the Minecraft container, as unquoted KEY=VALUE (docker run -e flags)
+	DataVolumeDevice string            // Device the data volume is attached on ("" without a data volume)
+	RestoreURL       string            // Pre-signed download URL of the backup to restore ("" when none)
+	Backups          backupSettings    // Settings of the backup agent
+	IdlePolicy       models.IdlePolicy // Idle policy enforced by the auto-shutdown monitor
+	Region           string            // Region of the backend, used when the instance metadata does not tell it
+	Callbacks        callbackSettings  // Settings of the lifecycle callbacks
+}
+
+// backupSettings are the settings of the backup agent of a server (the agent is disabled when Bucket is empty).
+type backupSettings struct {
+	Bucket           string
+	Prefix           string
+	Endpoint         string
+	Region           string
+	IntervalSeconds  int64 // 0 disables the scheduled backups
+	LevelName        string
+	MinecraftVersion string
+}
+
+// callbackSettings are the settings of the lifecycle callbacks of a server (disabled when URL is empty).
+type callbackSettings struct {
+	URL   string
+	Token string
+}
+
+// userDataTemplate() => parses the user data template, once.
+var userDataTemplate = sync.OnceValues(func() (*template.Template, error) {
+	return template.New(userDataTemplateName).
+		Funcs(template.FuncMap{"shell": shellQuote}).
+		ParseFS(scripts.FS, userDataTemplateName)
+})
+
+// renderUserData() => executes the user data template.
+func renderUserData(data userDataContext) (string, error) {
+	tmpl, err := userDataTemplate()
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the user data template: %v", err)
+	}
+	var script strings.Builder
+	if err := tmpl.Execute(&script, data); err != nil {
+		return "", fmt.Errorf("failed to render the user data template: %v", err)
+	}
+	return script.String(), nil
+}
+
+// encodeUserData() => gzips and base64 encodes a user data script, as RunInstances expects it.
+func encodeUserData(script string) (string, error) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write([]byte(script)); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	if compressed.Len() > maxUserDataSize {
+		return "", fmt.Errorf("user data is %d bytes once compressed, EC2 accepts at most %d", compressed.Len(), maxUserDataSize)
+	}
+	return base64.StdEncoding.EncodeToString(compressed.Bytes()), nil
+}
+
+/*
+ValidateUserDataTemplate() => parses the user data template and renders it with every option turned on, checking the
+result is a bash script EC2 accepts. Called at startup.
+*/
+func ValidateUserDataTemplate() error {
+	sample := userDataContext{
+		DockerEnv:        []string{"EULA=true", "TYPE=VANILLA", "VERSION=LATEST"},
+		DataVolumeDevice: dataVolumeDevice,
+		RestoreURL:       "https://bucket.s3.amazonaws.com/backups/restore.tar.gz",
+		Backups: backupSettings{
+			Bucket:           "bucket",
+			Prefix:           "backups",
+			Region:           "us-east-1",
+			IntervalSeconds:  6 * 60 * 60,
+			LevelName:        "world",
+			MinecraftVersion: "LATEST",
+		},
+		Region:    "us-east-1",
+		Callbacks: callbackSettings{URL: "https://backend.example.com" + callbackPath, Token: "token"},
+	}
+	sample.IdlePolicy.SetDefaults()
+
+	script, err := renderUserData(sample)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(script, "#!/bin/bash\n") {
+		return errors.New("the user data template does not start with #!/bin/bash")
+	}
+	_, err = encodeUserData(script)
+	return err
+}
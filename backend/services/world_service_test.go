@@ -0,0 +1,132 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io/fs"
+	"testing"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+// zipEntry is a file of a test archive.
+type zipEntry struct {
+	name    string
+	content string
+	mode    fs.FileMode // Mode of the entry; 0 for a regular file
+	size    uint64      // Unpacked size claimed by the archive; 0 for the size of content
+}
+
+// levelDat is the start of a gzip compressed level.dat.
+const levelDat = gzipMagic + "\x08\x00 compressed NBT"
+
+// buildZip() => returns an in-memory zip archive of entries.
+func buildZip(t *testing.T, entries ...zipEntry) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Store}
+		if entry.mode != 0 {
+			header.SetMode(entry.mode)
+		}
+		// Raw entries keep the sizes of their header, so an archive can claim more than it holds
+		header.CompressedSize64 = uint64(len(entry.content))
+		header.UncompressedSize64 = uint64(len(entry.content))
+		if entry.size != 0 {
+			header.UncompressedSize64 = entry.size
+		}
+		header.CRC32 = crc32.ChecksumIEEE([]byte(entry.content))
+		file, err := writer.CreateRaw(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write([]byte(entry.content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestValidateWorldArchive(t *testing.T) {
+	valid := []struct {
+		name     string
+		entries  []zipEntry
+		levelDir string
+	}{
+		{"level.dat at the root", []zipEntry{{name: "level.dat", content: levelDat}, {name: "region/r.0.0.mca", content: "chunks"}}, ""},
+		{"world in a folder", []zipEntry{{name: "MyWorld/", mode: fs.ModeDir | 0o755}, {name: "MyWorld/level.dat", content: levelDat}}, "MyWorld"},
+		{"shallowest level.dat", []zipEntry{
+			{name: "MyWorld/backups/old/level.dat", content: levelDat},
+			{name: "MyWorld/level.dat", content: levelDat},
+			{name: "MyWorld/DIM1/data/level.dat", content: "not the world"},
+		}, "MyWorld"},
+		{"Windows separators", []zipEntry{{name: `MyWorld\level.dat`, content: levelDat}}, "MyWorld"},
+	}
+	for _, c := range valid {
+		t.Run(c.name, func(t *testing.T) {
+			archive := buildZip(t, c.entries...)
+			levelDir, err := validateWorldArchive(archive, archive.Size())
+			if err != nil || levelDir != c.levelDir {
+				t.Fatalf("validateWorldArchive() = %q, %v, want %q", levelDir, err, c.levelDir)
+			}
+		})
+	}
+
+	invalid := map[string][]zipEntry{
+		"missing level.dat":   {{name: "MyWorld/region/r.0.0.mca", content: "chunks"}},
+		"level.dat not gzip":  {{name: "level.dat", content: "plain text"}},
+		"empty level.dat":     {{name: "level.dat"}},
+		"parent directory":    {{name: "level.dat", content: levelDat}, {name: "../evil.sh", content: "x"}},
+		"parent in the path":  {{name: "level.dat", content: levelDat}, {name: "MyWorld/../../evil.sh", content: "x"}},
+		"Windows parent":      {{name: "level.dat", content: levelDat}, {name: `MyWorld\..\..\evil.sh`, content: "x"}},
+		"absolute path":       {{name: "level.dat", content: levelDat}, {name: "/etc/cron.d/evil", content: "x"}},
+		"drive letter":        {{name: "level.dat", content: levelDat}, {name: `C:\evil.bat`, content: "x"}},
+		"drive letter, slash": {{name: "level.dat", content: levelDat}, {name: "C:/evil.bat", content: "x"}},
+		"symlink":             {{name: "level.dat", content: levelDat}, {name: "region", content: "/etc", mode: fs.ModeSymlink | 0o777}},
+		"oversize total": {
+			{name: "level.dat", content: levelDat},
+			{name: "region/r.0.0.mca", content: "x", size: maxWorldUnpackedSize / 2},
+			{name: "region/r.0.1.mca", content: "x", size: maxWorldUnpackedSize / 2},
+		},
+	}
+	for name, entries := range invalid {
+		t.Run(name, func(t *testing.T) {
+			archive := buildZip(t, entries...)
+			if levelDir, err := validateWorldArchive(archive, archive.Size()); !errors.Is(err, ErrInvalidWorld) {
+				t.Fatalf("validateWorldArchive() = %q, %v, want ErrInvalidWorld", levelDir, err)
+			}
+		})
+	}
+
+	t.Run("not a zip", func(t *testing.T) {
+		archive := bytes.NewReader([]byte(levelDat))
+		if _, err := validateWorldArchive(archive, archive.Size()); !errors.Is(err, ErrInvalidWorld) {
+			t.Fatalf("validateWorldArchive() error = %v, want ErrInvalidWorld", err)
+		}
+	})
+}
+
+func TestPrepareRequestWorldID(t *testing.T) {
+	service := newTestMinecraftService(t)
+	cases := map[string]bool{
+		newWorldID():                  true,
+		"world-3f9c2a7d1b8e4c60":      true,
+		"world-/../../backups/x":      false,
+		"world-3f9c2a7d1b8e4c60/../x": false,
+		"world-3F9C2A7D1B8E4C60":      false,
+		"world-3f9c2a7d":              false,
+		"world-3f9c2a7d1b8e4c6g":      false,
+		"backups/world-3f9c2a7d1b8e":  false,
+	}
+	for worldID, valid := range cases {
+		req := models.MinecraftServerRequest{EULA: true, WorldID: worldID}
+		err := service.PrepareRequest(&req)
+		if valid && errors.Is(err, ErrInvalidServerRequest) || !valid && !errors.Is(err, ErrInvalidServerRequest) {
+			t.Errorf("PrepareRequest(world_id %q) error = %v, want valid: %t", worldID, err, valid)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+/*
+registry.go
+In this file you will find the ServerRepository interface, the storage layer where the backend records every
+server it creates: the creation request, the owner, the instance ID, every lifecycle transition and, once the
+instance is gone, when and why it was terminated. It also keeps the per-server secrets (e.g. the RCON password),
+which never leave the backend. The AccessRepository keeps who may use the backend, when access is managed in the
+database rather than in Supabase, and the APIKeyRepository the (hashed) API keys of the clients.
+*/
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+)
+
+var (
+	// ErrNotFound is returned when a record does not exist.
+	ErrNotFound = errors.New("record not found")
+	// ErrAlreadyExists is returned when creating a record that already exists.
+	ErrAlreadyExists = errors.New("record already exists")
+)
+
+// ServerFilter selects the servers returned by ListServers().
+type ServerFilter struct {
+	OwnerID           string // Only the servers of this user; empty means every server
+	IncludeTerminated bool   // Terminated servers are left out unless this is set
+}
+
+// ServerRepository stores server records, their state transitions and their secrets.
+type ServerRepository interface {
+	// CreateServer() => records a new server. Its initial state is recorded as the first transition.
+	CreateServer(ctx context.Context, record *models.ServerRecord) error
+	// GetServer() => returns a server by instance ID, or ErrNotFound.
+	GetServer(ctx context.Context, instanceID string) (*models.ServerRecord, error)
+	// ListServers() => returns the servers matching the filter, newest first.
+	ListServers(ctx context.Context, filter ServerFilter) ([]models.ServerRecord, error)
+	// UpdateServerState() => applies an observation to a server, recording a transition when its state changed.
+	// Reports whether the state changed.
+	UpdateServerState(ctx context.Context, instanceID string, update models.ServerStateUpdate) (bool, error)
+	// SetLaunchError() => records why the launch of a server failed, so the garbage collector can clean it up.
+	SetLaunchError(ctx context.Context, instanceID, message string) error
+	// SetDataVolume() => records the EBS volume holding the world of a server.
+	SetDataVolume(ctx context.Context, instanceID, volumeID string) error
+	// RecordCallback() => records the last lifecycle event a server reported, and when it was first ready.
+	RecordCallback(ctx context.Context, instanceID, event string) error
+	// ListTransitions() => returns the state transitions of a server, oldest first.
+	ListTransitions(ctx context.Context, instanceID string) ([]models.ServerTransition, error)
+
+	// SaveSecret() => stores (or replaces) a named secret of a server.
+	SaveSecret(ctx context.Context, instanceID, name, value string) error
+	// Secret() => returns a named secret of a server, or ErrNotFound.
+	Secret(ctx context.Context, instanceID, name string) (string, error)
+
+	// Close() => releases the underlying resources.
+	Close() error
+}
+
+/*
+AccessRepository stores the access of the users (approval and trial attempts), for the "database" access policy.
+Trial attempts are changed atomically: they never go below zero, however many creations run in parallel.
+*/
+type AccessRepository interface {
+	// UserAccess() => returns the access of a user, or ErrNotFound.
+	UserAccess(ctx context.Context, userID string) (*models.UserAccess, error)
+	// SaveUserAccess() => stores (or replaces) the access of a user.
+	SaveUserAccess(ctx context.Context, access models.UserAccess) error
+	// AddTrialAttempts() => adds delta to a trial counter of a user. Reports false, changing nothing, when the counter
+	// would go below zero; ErrNotFound when the user is unknown.
+	AddTrialAttempts(ctx context.Context, userID, trial string, delta int) (bool, error)
+}
+
+// APIKeyRepository stores the API keys. Only the hash of each key is stored, never the key itself.
+type APIKeyRepository interface {
+	// CreateAPIKey() => records a new key, with the hash of its secret.
+	CreateAPIKey(ctx context.Context, key *models.APIKey, hash string) error
+	// APIKey() => returns a key and the hash of its secret, or ErrNotFound.
+	APIKey(ctx context.Context, id string) (*models.APIKey, string, error)
+	// ListAPIKeys() => returns every key, revoked ones included, newest first.
+	ListAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	// RevokeAPIKey() => revokes a key, or returns ErrNotFound. Revoking a revoked key changes nothing.
+	RevokeAPIKey(ctx context.Context, id string) error
+	// TouchAPIKey() => records that a key was just used.
+	TouchAPIKey(ctx context.Context, id string) error
+}
@@ -0,0 +1,557 @@
+/*
+sqlite_registry.go
+In this file you will find the SQLite implementation of the ServerRepository, the default storage of the backend.
+It uses a pure Go SQLite driver, so the backend still builds with CGO_ENABLED=0. The schema is created and upgraded
+at startup with the numbered migrations below (the current version is kept in PRAGMA user_version).
+*/
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/diego4lbarracin/The_Minecraft_Server_Generator/models"
+	_ "modernc.org/sqlite"
+)
+
+/*
+sqliteMigrations are applied in order, once each. Never edit a migration that has been released: append a new one.
+Timestamps are stored as RFC 3339 strings in UTC, so they sort chronologically.
+*/
+var sqliteMigrations = []string{
+	`CREATE TABLE servers (
+		instance_id        TEXT PRIMARY KEY,
+		owner_id           TEXT NOT NULL DEFAULT '',
+		server_name        TEXT NOT NULL DEFAULT '',
+		server_type        TEXT NOT NULL DEFAULT '',
+		minecraft_version  TEXT NOT NULL DEFAULT '',
+		instance_type      TEXT NOT NULL DEFAULT '',
+		availability_zone  TEXT NOT NULL DEFAULT '',
+		state              TEXT NOT NULL DEFAULT '',
+		public_ip          TEXT NOT NULL DEFAULT '',
+		private_ip         TEXT NOT NULL DEFAULT '',
+		request            TEXT NOT NULL DEFAULT '',
+		created_at         TEXT NOT NULL,
+		updated_at         TEXT NOT NULL,
+		synced_at          TEXT NOT NULL DEFAULT '',
+		launch_time        TEXT NOT NULL DEFAULT '',
+		terminated_at      TEXT NOT NULL DEFAULT '',
+		termination_reason TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX servers_owner ON servers (owner_id, created_at);
+	CREATE TABLE server_transitions (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		instance_id TEXT NOT NULL,
+		from_state  TEXT NOT NULL,
+		to_state    TEXT NOT NULL,
+		reason      TEXT NOT NULL DEFAULT '',
+		at          TEXT NOT NULL
+	);
+	CREATE INDEX server_transitions_instance ON server_transitions (instance_id, id);
+	CREATE TABLE server_secrets (
+		instance_id TEXT NOT NULL,
+		name        TEXT NOT NULL,
+		value       TEXT NOT NULL,
+		PRIMARY KEY (instance_id, name)
+	);`,
+	`ALTER TABLE servers ADD COLUMN launch_error TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE servers ADD COLUMN data_volume_id TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE servers ADD COLUMN ready_at TEXT NOT NULL DEFAULT '';
+	ALTER TABLE servers ADD COLUMN last_callback TEXT NOT NULL DEFAULT '';
+	ALTER TABLE servers ADD COLUMN last_callback_at TEXT NOT NULL DEFAULT '';`,
+	`CREATE TABLE user_access (
+		user_id                      TEXT PRIMARY KEY,
+		approved                     INTEGER NOT NULL DEFAULT 0,
+		test_service_trial_attempts  INTEGER NOT NULL DEFAULT 0,
+		custom_server_trial_attempts INTEGER NOT NULL DEFAULT 0,
+		updated_at                   TEXT NOT NULL DEFAULT ''
+	);`,
+	`CREATE TABLE api_keys (
+		id           TEXT PRIMARY KEY,
+		name         TEXT NOT NULL,
+		owner_id     TEXT NOT NULL,
+		scopes       TEXT NOT NULL,
+		key_hash     TEXT NOT NULL,
+		created_by   TEXT NOT NULL DEFAULT '',
+		created_at   TEXT NOT NULL,
+		expires_at   TEXT NOT NULL DEFAULT '',
+		last_used_at TEXT NOT NULL DEFAULT '',
+		revoked_at   TEXT NOT NULL DEFAULT ''
+	);`,
+}
+
+// serverColumns is the column list read by every query returning servers, in the order scanServer() expects.
+const serverColumns = `instance_id, owner_id, server_name, server_type, minecraft_version, instance_type, availability_zone,
+	state, public_ip, private_ip, request, created_at, updated_at, synced_at, launch_time, terminated_at, termination_reason, launch_error, data_volume_id,
+	ready_at, last_callback, last_callback_at`
+
+// SQLiteRegistry is a ServerRepository stored in a SQLite database file.
+type SQLiteRegistry struct {
+	db *sql.DB
+}
+
+var _ ServerRepository = (*SQLiteRegistry)(nil)
+
+/*
+NewSQLiteRegistry() => opens (creating it if needed) the SQLite database at path and brings its schema up to date.
+The special path ":memory:" keeps everything in memory, which is handy for local development.
+*/
+func NewSQLiteRegistry(path string) (*SQLiteRegistry, error) {
+	if path != ":memory:" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create registry directory: %v", err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open registry database: %v", err)
+	}
+	// SQLite allows a single writer: one connection avoids "database is locked" errors (and keeps ":memory:" shared).
+	db.SetMaxOpenConns(1)
+
+	registry := &SQLiteRegistry{db: db}
+	if err := registry.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return registry, nil
+}
+
+// migrate() => applies the migrations the database does not have yet.
+func (r *SQLiteRegistry) migrate(ctx context.Context) error {
+	var version int
+	if err := r.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read registry schema version: %v", err)
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := r.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to start registry migration %d: %v", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply registry migration %d: %v", i+1, err)
+		}
+		// PRAGMA does not accept placeholders; the value is a trusted integer.
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record registry migration %d: %v", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit registry migration %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// now() => the current time in the format stored in the database.
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// CreateServer() => records a new server, with its initial state as the first transition.
+func (r *SQLiteRegistry) CreateServer(ctx context.Context, record *models.ServerRecord) error {
+	request := ""
+	if record.Request != nil {
+		encoded, err := json.Marshal(record.Request)
+		if err != nil {
+			return fmt.Errorf("failed to encode server request: %v", err)
+		}
+		request = string(encoded)
+	}
+
+	timestamp := now()
+	if record.CreatedAt == "" {
+		record.CreatedAt = timestamp
+	}
+	record.UpdatedAt = timestamp
+	if record.SyncedAt == "" {
+		record.SyncedAt = timestamp
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO servers (`+serverColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.InstanceID, record.OwnerID, record.ServerName, record.ServerType, record.MinecraftVersion,
+		record.InstanceType, record.AvailabilityZone, record.State, record.PublicIP, record.PrivateIP, request,
+		record.CreatedAt, record.UpdatedAt, record.SyncedAt, record.LaunchTime, record.TerminatedAt, record.TerminationReason,
+		record.LaunchError, record.DataVolumeID, record.ReadyAt, record.LastCallback, record.LastCallbackAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return fmt.Errorf("%w: server %s", ErrAlreadyExists, record.InstanceID)
+		}
+		return fmt.Errorf("failed to insert server %s: %v", record.InstanceID, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO server_transitions (instance_id, from_state, to_state, reason, at) VALUES (?, '', ?, ?, ?)`,
+		record.InstanceID, record.State, "server recorded", timestamp)
+	if err != nil {
+		return fmt.Errorf("failed to record transition of %s: %v", record.InstanceID, err)
+	}
+
+	return tx.Commit()
+}
+
+// GetServer() => returns a server by instance ID, or ErrNotFound.
+func (r *SQLiteRegistry) GetServer(ctx context.Context, instanceID string) (*models.ServerRecord, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+serverColumns+` FROM servers WHERE instance_id = ?`, instanceID)
+	record, err := scanServer(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: server %s", ErrNotFound, instanceID)
+	}
+	return record, err
+}
+
+// ListServers() => returns the servers matching the filter, newest first.
+func (r *SQLiteRegistry) ListServers(ctx context.Context, filter ServerFilter) ([]models.ServerRecord, error) {
+	query := `SELECT ` + serverColumns + ` FROM servers WHERE 1 = 1`
+	var args []any
+	if filter.OwnerID != "" {
+		query += ` AND owner_id = ?`
+		args = append(args, filter.OwnerID)
+	}
+	if !filter.IncludeTerminated {
+		query += ` AND state <> 'terminated'`
+	}
+	query += ` ORDER BY created_at DESC, instance_id`
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list servers: %v", err)
+	}
+	defer rows.Close()
+
+	records := []models.ServerRecord{}
+	for rows.Next() {
+		record, err := scanServer(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *record)
+	}
+	return records, rows.Err()
+}
+
+// UpdateServerState() => applies an observation to a server, recording a transition when its state changed.
+func (r *SQLiteRegistry) UpdateServerState(ctx context.Context, instanceID string, update models.ServerStateUpdate) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var current, terminatedAt string
+	err = tx.QueryRowContext(ctx, `SELECT state, terminated_at FROM servers WHERE instance_id = ?`, instanceID).Scan(&current, &terminatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("%w: server %s", ErrNotFound, instanceID)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read server %s: %v", instanceID, err)
+	}
+
+	timestamp := now()
+	changed := update.State != "" && update.State != current
+	if !changed {
+		// Nothing but the addresses can change: just note that the record was confirmed.
+		_, err = tx.ExecContext(ctx, `UPDATE servers SET public_ip = ?, private_ip = ?, synced_at = ? WHERE instance_id = ?`,
+			update.PublicIP, update.PrivateIP, timestamp, instanceID)
+		if err != nil {
+			return false, fmt.Errorf("failed to update server %s: %v", instanceID, err)
+		}
+		return false, tx.Commit()
+	}
+
+	// The first reason given once the termination started explains it best (EC2 itself only says "User initiated").
+	terminationReason := ""
+	if (update.State == "shutting-down" || update.State == "terminated") && terminatedAt == "" {
+		terminationReason = update.Reason
+	}
+	if update.State == "terminated" && terminatedAt == "" {
+		terminatedAt = timestamp
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE servers SET state = ?, public_ip = ?, private_ip = ?, updated_at = ?, synced_at = ?,
+		terminated_at = ?, termination_reason = CASE WHEN termination_reason = '' THEN ? ELSE termination_reason END
+		WHERE instance_id = ?`,
+		update.State, update.PublicIP, update.PrivateIP, timestamp, timestamp, terminatedAt, terminationReason, instanceID)
+	if err != nil {
+		return false, fmt.Errorf("failed to update server %s: %v", instanceID, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO server_transitions (instance_id, from_state, to_state, reason, at) VALUES (?, ?, ?, ?, ?)`,
+		instanceID, current, update.State, update.Reason, timestamp)
+	if err != nil {
+		return false, fmt.Errorf("failed to record transition of %s: %v", instanceID, err)
+	}
+
+	return true, tx.Commit()
+}
+
+// SetLaunchError() => records why the launch of a server failed.
+func (r *SQLiteRegistry) SetLaunchError(ctx context.Context, instanceID, message string) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE servers SET launch_error = ?, updated_at = ? WHERE instance_id = ?`,
+		message, now(), instanceID)
+	if err != nil {
+		return fmt.Errorf("failed to record launch error of %s: %v", instanceID, err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("%w: server %s", ErrNotFound, instanceID)
+	}
+	return nil
+}
+
+// SetDataVolume() => records the EBS volume holding the world of a server.
+func (r *SQLiteRegistry) SetDataVolume(ctx context.Context, instanceID, volumeID string) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE servers SET data_volume_id = ?, updated_at = ? WHERE instance_id = ?`,
+		volumeID, now(), instanceID)
+	if err != nil {
+		return fmt.Errorf("failed to record data volume of %s: %v", instanceID, err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("%w: server %s", ErrNotFound, instanceID)
+	}
+	return nil
+}
+
+// RecordCallback() => records the last lifecycle event a server reported, and when it was first ready.
+func (r *SQLiteRegistry) RecordCallback(ctx context.Context, instanceID, event string) error {
+	at := now()
+	readyAt := ""
+	if event == models.CallbackReady {
+		readyAt = at
+	}
+	result, err := r.db.ExecContext(ctx, `UPDATE servers SET last_callback = ?, last_callback_at = ?,
+		ready_at = CASE WHEN ready_at = '' THEN ? ELSE ready_at END, updated_at = ? WHERE instance_id = ?`,
+		event, at, readyAt, at, instanceID)
+	if err != nil {
+		return fmt.Errorf("failed to record callback of %s: %v", instanceID, err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("%w: server %s", ErrNotFound, instanceID)
+	}
+	return nil
+}
+
+// ListTransitions() => returns the state transitions of a server, oldest first.
+func (r *SQLiteRegistry) ListTransitions(ctx context.Context, instanceID string) ([]models.ServerTransition, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT from_state, to_state, reason, at FROM server_transitions WHERE instance_id = ? ORDER BY id`, instanceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transitions of %s: %v", instanceID, err)
+	}
+	defer rows.Close()
+
+	transitions := []models.ServerTransition{}
+	for rows.Next() {
+		var transition models.ServerTransition
+		if err := rows.Scan(&transition.FromState, &transition.ToState, &transition.Reason, &transition.At); err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, transition)
+	}
+	return transitions, rows.Err()
+}
+
+// SaveSecret() => stores (or replaces) a named secret of a server.
+func (r *SQLiteRegistry) SaveSecret(ctx context.Context, instanceID, name, value string) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO server_secrets (instance_id, name, value) VALUES (?, ?, ?)
+		ON CONFLICT (instance_id, name) DO UPDATE SET value = excluded.value`, instanceID, name, value)
+	if err != nil {
+		return fmt.Errorf("failed to save secret %s of %s: %v", name, instanceID, err)
+	}
+	return nil
+}
+
+// Secret() => returns a named secret of a server, or ErrNotFound.
+func (r *SQLiteRegistry) Secret(ctx context.Context, instanceID, name string) (string, error) {
+	var value string
+	err := r.db.QueryRowContext(ctx, `SELECT value FROM server_secrets WHERE instance_id = ? AND name = ?`, instanceID, name).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("%w: secret %s of %s", ErrNotFound, name, instanceID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %s of %s: %v", name, instanceID, err)
+	}
+	return value, nil
+}
+
+// UserAccess() => returns the access of a user, or ErrNotFound.
+func (r *SQLiteRegistry) UserAccess(ctx context.Context, userID string) (*models.UserAccess, error) {
+	access := models.UserAccess{UserID: userID}
+	err := r.db.QueryRowContext(ctx, `SELECT approved, test_service_trial_attempts, custom_server_trial_attempts
+		FROM user_access WHERE user_id = ?`, userID).Scan(&access.Approved, &access.TestServiceTrialAttempts, &access.CustomServerTrialAttempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: user %s", ErrNotFound, userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access of %s: %v", userID, err)
+	}
+	return &access, nil
+}
+
+// SaveUserAccess() => stores (or replaces) the access of a user.
+func (r *SQLiteRegistry) SaveUserAccess(ctx context.Context, access models.UserAccess) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO user_access (user_id, approved, test_service_trial_attempts, custom_server_trial_attempts, updated_at)
+		VALUES (?, ?, ?, ?, ?) ON CONFLICT (user_id) DO UPDATE SET approved = excluded.approved,
+		test_service_trial_attempts = excluded.test_service_trial_attempts,
+		custom_server_trial_attempts = excluded.custom_server_trial_attempts, updated_at = excluded.updated_at`,
+		access.UserID, access.Approved, access.TestServiceTrialAttempts, access.CustomServerTrialAttempts, now())
+	if err != nil {
+		return fmt.Errorf("failed to save access of %s: %v", access.UserID, err)
+	}
+	return nil
+}
+
+// Counter of each trial in the user_access table
+var trialAttemptColumns = map[string]string{
+	models.TrialTest:   "test_service_trial_attempts",
+	models.TrialCustom: "custom_server_trial_attempts",
+}
+
+// AddTrialAttempts() => adds delta to a trial counter of a user, in a single statement so it never goes below zero.
+func (r *SQLiteRegistry) AddTrialAttempts(ctx context.Context, userID, trial string, delta int) (bool, error) {
+	column, ok := trialAttemptColumns[trial]
+	if !ok {
+		return false, fmt.Errorf("unknown trial %q", trial)
+	}
+	result, err := r.db.ExecContext(ctx, `UPDATE user_access SET `+column+` = `+column+` + ?, updated_at = ?
+		WHERE user_id = ? AND `+column+` + ? >= 0`, delta, now(), userID, delta)
+	if err != nil {
+		return false, fmt.Errorf("failed to update the %s trial attempts of %s: %v", trial, userID, err)
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected > 0 {
+		return err == nil, err
+	}
+	if _, err := r.UserAccess(ctx, userID); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// apiKeyColumns is the column list read by every query returning API keys, in the order scanAPIKey() expects.
+const apiKeyColumns = `id, name, owner_id, scopes, created_by, created_at, expires_at, last_used_at, revoked_at`
+
+// CreateAPIKey() => records a new key, with the hash of its secret.
+func (r *SQLiteRegistry) CreateAPIKey(ctx context.Context, key *models.APIKey, hash string) error {
+	if key.CreatedAt == "" {
+		key.CreatedAt = now()
+	}
+	_, err := r.db.ExecContext(ctx, `INSERT INTO api_keys (id, name, owner_id, scopes, key_hash, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		key.ID, key.Name, key.OwnerID, strings.Join(key.Scopes, ","), hash, key.CreatedBy, key.CreatedAt, key.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to record API key %s: %v", key.ID, err)
+	}
+	return nil
+}
+
+// APIKey() => returns a key and the hash of its secret, or ErrNotFound.
+func (r *SQLiteRegistry) APIKey(ctx context.Context, id string) (*models.APIKey, string, error) {
+	var hash string
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, `SELECT `+apiKeyColumns+`, key_hash FROM api_keys WHERE id = ?`, id), &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", fmt.Errorf("%w: API key %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read API key %s: %v", id, err)
+	}
+	return key, hash, nil
+}
+
+// ListAPIKeys() => returns every key, revoked ones included, newest first.
+func (r *SQLiteRegistry) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created_at DESC, id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %v", err)
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey() => revokes a key, or returns ErrNotFound. Revoking a revoked key changes nothing.
+func (r *SQLiteRegistry) RevokeAPIKey(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = CASE WHEN revoked_at = '' THEN ? ELSE revoked_at END
+		WHERE id = ?`, now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key %s: %v", id, err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("%w: API key %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+// TouchAPIKey() => records that a key was just used.
+func (r *SQLiteRegistry) TouchAPIKey(ctx context.Context, id string) error {
+	if _, err := r.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = ? WHERE id = ?`, now(), id); err != nil {
+		return fmt.Errorf("failed to record use of API key %s: %v", id, err)
+	}
+	return nil
+}
+
+// scanAPIKey() => reads a row of apiKeyColumns, followed by the extra columns of the query.
+func scanAPIKey(row rowScanner, extra ...any) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	dest := append([]any{&key.ID, &key.Name, &key.OwnerID, &scopes, &key.CreatedBy, &key.CreatedAt, &key.ExpiresAt,
+		&key.LastUsedAt, &key.RevokedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	key.Scopes = []string{}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	return &key, nil
+}
+
+// Close() => closes the database.
+func (r *SQLiteRegistry) Close() error {
+	return r.db.Close()
+}
+
+// rowScanner is what scanServer() needs from *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanServer() => reads a server selected with serverColumns.
+func scanServer(row rowScanner) (*models.ServerRecord, error) {
+	var record models.ServerRecord
+	var request string
+	err := row.Scan(&record.InstanceID, &record.OwnerID, &record.ServerName, &record.ServerType, &record.MinecraftVersion,
+		&record.InstanceType, &record.AvailabilityZone, &record.State, &record.PublicIP, &record.PrivateIP, &request,
+		&record.CreatedAt, &record.UpdatedAt, &record.SyncedAt, &record.LaunchTime, &record.TerminatedAt, &record.TerminationReason,
+		&record.LaunchError, &record.DataVolumeID, &record.ReadyAt, &record.LastCallback, &record.LastCallbackAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if request != "" {
+		record.Request = &models.MinecraftServerRequest{}
+		if err := json.Unmarshal([]byte(request), record.Request); err != nil {
+			return nil, fmt.Errorf("failed to decode request of server %s: %v", record.InstanceID, err)
+		}
+	}
+	return &record, nil
+}
@@ -1,10 +1,33 @@
 import { useState, useEffect } from "react";
 import { useNavigate } from "react-router-dom";
 import { useAuth } from "../context/AuthContext";
-import { supabase } from "../config/supabaseClient";
 import Footer from "../components/Footer";
 import CreateServerForm from "../components/CreateServerForm";
 
+// Server creation is asynchronous: the backend answers with a job ID that is
+// polled until the EC2 instance is running and has a public IP.
+const waitForServer = async (apiUrl, token, jobId) => {
+  for (;;) {
+    const response = await fetch(`${apiUrl}/minecraft/jobs/${jobId}`, {
+      headers: { Authorization: `Bearer ${token}` },
+    });
+    if (!response.ok) {
+      const errorData = await response.json();
+      throw new Error(errorData.message || "Failed to check server status");
+    }
+
+    const job = await response.json();
+    if (job.phase === "failed") {
+      throw new Error(job.error || "Failed to create server");
+    }
+    if (job.server?.public_ip) {
+      return job.server;
+    }
+
+    await new Promise((resolve) => setTimeout(resolve, 3000));
+  }
+};
+
 const DashboardPage = () => {
   const navigate = useNavigate();
   const { user, profile, signOut, getAuthToken, refreshProfile } = useAuth();
@@ -105,24 +128,7 @@ const DashboardPage = () => {
 
       const apiUrl = import.meta.env.VITE_API_URL;
 
-      // Decrement custom server trial attempts
-      const { error: decrementError } = await supabase
-        .from("profiles")
-        .update({
-          custom_server_trial_attempts:
-            (profile?.custom_server_trial_attempts ?? 1) - 1,
-        })
-        .eq("id", user?.id);
-
-      if (decrementError) {
-        console.error(
-          "Failed to decrement custom_server_trial_attempts:",
-          decrementError,
-        );
-        throw new Error("Could not update trial attempts. Please try again.");
-      }
-      await refreshProfile();
-
+      // The backend consumes a custom server trial attempt (and gives it back if the creation fails)
       const response = await fetch(`${apiUrl}/minecraft/create`, {
         method: "POST",
         headers: {
@@ -142,6 +148,7 @@ const DashboardPage = () => {
           online_mode: !formData.allowCrackedPlayers,
           motd: `${formData.serverName} - Welcome!`,
           max_players: 10,
+          trial: "custom",
         }),
       });
 
@@ -150,7 +157,8 @@ const DashboardPage = () => {
         throw new Error(errorData.message || "Failed to create server");
       }
 
-      const data = await response.json();
+      const job = await response.json();
+      const data = await waitForServer(apiUrl, token, job.job_id);
       console.log("Server created:", data);
 
       // Redirect to server status page
@@ -182,6 +190,7 @@ const DashboardPage = () => {
       setError(err.message || "Failed to create server. Please try again.");
     } finally {
       setIsCreating(false);
+      await refreshProfile();
     }
   };
 
@@ -203,25 +212,8 @@ const DashboardPage = () => {
         throw new Error("Not authenticated. Please log in again.");
       }
 
-      // Decrement test service trial attempts
-      const { error: decrementError } = await supabase
-        .from("profiles")
-        .update({
-          test_service_trial_attempts:
-            (profile?.test_service_trial_attempts ?? 1) - 1,
-        })
-        .eq("id", user?.id);
-
-      if (decrementError) {
-        console.error(
-          "Failed to decrement test_service_trial_attempts:",
-          decrementError,
-        );
-        throw new Error("Could not update trial attempts. Please try again.");
-      }
-      await refreshProfile();
-
-      // Call the backend API with authentication
+      // Call the backend API with authentication. The backend consumes a test service trial attempt (and gives it
+      // back if the creation fails)
       const apiUrl = import.meta.env.VITE_API_URL;
       const response = await fetch(`${apiUrl}/minecraft/create`, {
         method: "POST",
@@ -242,6 +234,7 @@ const DashboardPage = () => {
           memory: "3G",
           online_mode: false,
           instance_type: "t3.medium",
+          trial: "test",
         }),
       });
 
@@ -250,7 +243,8 @@ const DashboardPage = () => {
         throw new Error(errorData.message || "Failed to create server");
       }
 
-      const data = await response.json();
+      const job = await response.json();
+      const data = await waitForServer(apiUrl, token, job.job_id);
       console.log("Server created:", data);
 
       // Redirect to server status page
@@ -269,6 +263,7 @@ const DashboardPage = () => {
       setError(err.message || "Failed to create server. Please try again.");
     } finally {
       setIsRunning(false);
+      await refreshProfile();
     }
   };
 
@@ -1,4 +1,4 @@
-import { useState, useEffect } from "react";
+import { useState, useEffect, useRef } from "react";
 import { useSearchParams, useNavigate } from "react-router-dom";
 import { useAuth } from "../context/AuthContext";
 import CustomAlert from "../components/CustomAlert";
@@ -17,9 +17,11 @@ const ServerStatusPage = () => {
   const [showConfirm, setShowConfirm] = useState(false);
   const [alertMessage, setAlertMessage] = useState("");
   const [isStopping, setIsStopping] = useState(false);
-  const [userStoppedServer, setUserStoppedServer] = useState(false);
   const [showInactivityAlert, setShowInactivityAlert] = useState(false);
   const [showCopiedMessage, setShowCopiedMessage] = useState(false);
+  // Mirror of isActive and "the user stopped the server", readable from the event stream callback
+  const isActiveRef = useRef(false);
+  const userStoppedRef = useRef(false);
 
   useEffect(() => {
     // Get server data from URL params
@@ -46,58 +48,77 @@ const ServerStatusPage = () => {
     }
   }, [timeRemaining]);
 
-  // Poll EC2 instance status every 5 seconds
+  // Follow the server lifecycle over Server-Sent Events instead of polling
   useEffect(() => {
     if (!serverData?.instanceId) return;
-    // Stop polling if inactivity alert has been shown
+    // Stop listening if inactivity alert has been shown
     if (showInactivityAlert) return;
 
-    const checkServerStatus = async () => {
+    const controller = new AbortController();
+
+    const handleServerEvent = (event) => {
+      if (event.type !== "ec2_state") return;
+      const isNowActive = event.state === "running";
+
+      // If server was active but now stopped, and user didn't stop it, show inactivity alert
+      if (isActiveRef.current && !isNowActive && !userStoppedRef.current) {
+        setShowInactivityAlert(true);
+      }
+      isActiveRef.current = isNowActive;
+      setIsActive(isNowActive);
+    };
+
+    const followServerEvents = async () => {
       try {
         const token = await getAuthToken();
         const apiUrl = import.meta.env.VITE_API_URL;
 
+        // fetch() is used instead of EventSource because EventSource cannot send the Authorization header
         const response = await fetch(
-          `${apiUrl}/minecraft/info/${serverData.instanceId}`,
+          `${apiUrl}/minecraft/servers/${serverData.instanceId}/events`,
           {
             headers: {
               Authorization: `Bearer ${token}`,
             },
+            signal: controller.signal,
           },
         );
-
-        if (response.ok) {
-          const data = await response.json();
-          const wasActive = isActive;
-          const isNowActive = data.state === "running";
-
-          // Set active status based on EC2 instance state
-          setIsActive(isNowActive);
-
-          // If server was active but now stopped, and user didn't stop it, show inactivity alert
-          if (wasActive && !isNowActive && !userStoppedServer) {
-            setShowInactivityAlert(true);
+        if (!response.ok || !response.body) return;
+
+        const reader = response.body
+          .pipeThrough(new TextDecoderStream())
+          .getReader();
+        let buffer = "";
+
+        for (;;) {
+          const { value, done } = await reader.read();
+          if (done) break;
+
+          // SSE messages are separated by a blank line; keep the incomplete tail for the next chunk
+          buffer += value;
+          const messages = buffer.split("\n\n");
+          buffer = messages.pop();
+
+          for (const message of messages) {
+            const dataLine = message
+              .split("\n")
+              .find((line) => line.startsWith("data:"));
+            if (dataLine) {
+              handleServerEvent(JSON.parse(dataLine.slice(5)));
+            }
           }
         }
       } catch (error) {
-        console.error("Error checking server status:", error);
+        if (error.name !== "AbortError") {
+          console.error("Error following server events:", error);
+        }
       }
     };
 
-    // Check immediately
-    checkServerStatus();
-
-    // Then check every 5 seconds
-    const interval = setInterval(checkServerStatus, 5000);
+    followServerEvents();
 
-    return () => clearInterval(interval);
-  }, [
-    serverData,
-    getAuthToken,
-    showInactivityAlert,
-    isActive,
-    userStoppedServer,
-  ]);
+    return () => controller.abort();
+  }, [serverData, getAuthToken, showInactivityAlert]);
 
   const formatTime = (seconds) => {
     const mins = Math.floor(seconds / 60);
@@ -141,8 +162,9 @@ const ServerStatusPage = () => {
       // Show success alert
       setAlertMessage("Server has been stopped successfully!");
       setShowAlert(true);
+      userStoppedRef.current = true;
+      isActiveRef.current = false;
       setIsActive(false);
-      setUserStoppedServer(true);
     } catch (error) {
       console.error("Error stopping server:", error);
       alert(`Failed to stop server: ${error.message}`);